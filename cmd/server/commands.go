@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"github.com/soul-ua/server/internal/accounts"
+	"github.com/soul-ua/server/internal/registration"
+	"github.com/soul-ua/server/internal/reports"
+	"github.com/soul-ua/server/pkg/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const commandsUsage = `admin commands, storage is locked by running server, so it should be stopped first:
+  invite [count]               issue invite codes
+  reports [all]                list open reports, or all of them
+  resolve <report-id> [note]   mark report as resolved
+  suspend <username> [report]  suspend account, report is resolved if given
+  reinstate <username>         reinstate suspended account`
+
+// runCommand of server admin instead of starting the server
+func runCommand(command string, args []string, accountsUC accounts.Accounts, invites *registration.Invites, reportsUC reports.Reports) error {
+	switch command {
+	case "invite":
+		count := 1
+		if len(args) > 0 {
+			var err error
+			if count, err = strconv.Atoi(args[0]); err != nil || count < 1 {
+				return fmt.Errorf("invalid invite count %q", args[0])
+			}
+		}
+
+		codes, err := invites.Issue(count)
+		if err != nil {
+			return fmt.Errorf("failed to issue invites: %w", err)
+		}
+		for _, code := range codes {
+			fmt.Println(code)
+		}
+	case "reports":
+		list, err := reportsUC.List(len(args) > 0 && args[0] == "all")
+		if err != nil {
+			return fmt.Errorf("failed to list reports: %w", err)
+		}
+		for _, report := range list {
+			fmt.Printf("%s %s %s reported %q envelope %s verified=%v in_inbox=%v: %s\n",
+				report.ID, time.Unix(report.CreatedAt, 0).Format(time.DateTime), report.Reporter, report.Reported,
+				report.EnvelopeID, report.Verified, report.InInbox, report.Reason)
+			if len(report.Content) > 0 {
+				fmt.Printf("  content: %s\n", report.Content)
+			}
+			if report.ResolvedAt != 0 {
+				fmt.Printf("  resolved: %s\n", report.Resolution)
+			}
+		}
+	case "resolve":
+		if len(args) < 1 {
+			return fmt.Errorf("report ID is required")
+		}
+		if err := reportsUC.Resolve(args[0], strings.Join(args[1:], " ")); err != nil {
+			return fmt.Errorf("failed to resolve report: %w", err)
+		}
+	case "suspend":
+		if len(args) < 1 {
+			return fmt.Errorf("username is required")
+		}
+		if err := accountsUC.SetAccountState(args[0], protocol.AccountSuspended); err != nil {
+			return fmt.Errorf("failed to suspend account: %w", err)
+		}
+		if len(args) > 1 {
+			if err := reportsUC.Resolve(args[1], "suspended "+args[0]); err != nil {
+				return fmt.Errorf("failed to resolve report: %w", err)
+			}
+		}
+	case "reinstate":
+		if len(args) < 1 {
+			return fmt.Errorf("username is required")
+		}
+		if err := accountsUC.SetAccountState(args[0], protocol.AccountActive); err != nil {
+			return fmt.Errorf("failed to reinstate account: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown command %q\n%s", command, commandsUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/soul-ua/server/internal/blobs"
+	"github.com/soul-ua/server/internal/inbox"
+	"github.com/soul-ua/server/internal/webserver"
+	"github.com/soul-ua/server/pkg/protocol"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// config of the server binary. Every option is a flag, an environment variable named after it with SOUL_ prefix
+// (-data-dir is SOUL_DATA_DIR) and a key of JSON config file given with -config or SOUL_CONFIG.
+// Flags override environment, environment overrides the config file.
+type config struct {
+	DataDir   string
+	Listen    string
+	PublicURL string
+	TLSCert   string
+	TLSKey    string
+
+	KeyName  string
+	KeyEmail string
+	AdminKey string
+
+	Registration           string
+	RegistrationDifficulty int
+	RateLimit              bool
+	RequirePadding         bool
+
+	MaxPayloadSize      int64
+	MaxAttachmentSize   int64
+	MaxRetention        time.Duration
+	InboxMaxCount       int64
+	InboxMaxBytes       int64
+	InboxSenderMaxCount int64
+	InboxSenderMaxBytes int64
+	InboxServerMaxCount int64
+	InboxServerMaxBytes int64
+	BlobQuota           int64
+	BlobMaxAge          time.Duration
+	SweepInterval       time.Duration
+	ChatMaxOpen         int
+	ChatIdleTimeout     time.Duration
+
+	Chats        bool
+	Attachments  bool
+	Stream       bool
+	SealedSender bool
+	Ratchet      bool
+}
+
+func defaultConfig() config {
+	return config{
+		DataDir:  ".data",
+		Listen:   ":8080",
+		KeyName:  "server",
+		KeyEmail: "server@soul.ua",
+
+		Registration:   protocol.RegistrationOpen,
+		RateLimit:      true,
+		RequirePadding: webserver.DefaultPadPolicy.Required,
+
+		MaxPayloadSize:      webserver.DefaultMaxPayloadSize,
+		MaxAttachmentSize:   webserver.DefaultMaxAttachmentSize,
+		MaxRetention:        webserver.DefaultMaxRetention,
+		InboxMaxCount:       inbox.DefaultLimits.MaxCount,
+		InboxMaxBytes:       inbox.DefaultLimits.MaxBytes,
+		InboxSenderMaxCount: inbox.DefaultLimits.SenderMaxCount,
+		InboxSenderMaxBytes: inbox.DefaultLimits.SenderMaxBytes,
+		InboxServerMaxCount: inbox.DefaultLimits.ServerMaxCount,
+		InboxServerMaxBytes: inbox.DefaultLimits.ServerMaxBytes,
+		BlobQuota:           blobs.DefaultPolicy.Quota,
+		BlobMaxAge:          blobs.DefaultPolicy.MaxAge,
+		SweepInterval:       time.Minute,
+		ChatMaxOpen:         256,
+		ChatIdleTimeout:     5 * time.Minute,
+
+		Chats:        true,
+		Attachments:  true,
+		Stream:       true,
+		SealedSender: true,
+		Ratchet:      true,
+	}
+}
+
+func (c *config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory of databases and blobs, it is created if missing")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address to listen on")
+	fs.StringVar(&c.PublicURL, "public-url", c.PublicURL, "URL clients reach the server at, advertised in server info")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "certificate file, HTTPS is served when it is set with -tls-key")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "private key file of -tls-cert")
+
+	fs.StringVar(&c.KeyName, "key-name", c.KeyName, "name of server key when it is generated on the first start")
+	fs.StringVar(&c.KeyEmail, "key-email", c.KeyEmail, "email of server key when it is generated on the first start")
+	fs.StringVar(&c.AdminKey, "admin-key", c.AdminKey, "armored public key file of admin, admin endpoints are disabled without it")
+
+	fs.StringVar(&c.Registration, "registration", c.Registration, "registration policy: open, invite or proof-of-work")
+	fs.IntVar(&c.RegistrationDifficulty, "registration-difficulty", c.RegistrationDifficulty, "proof of work difficulty in bits, from 1 to 32")
+	fs.BoolVar(&c.RateLimit, "rate-limit", c.RateLimit, "limit rate of registrations, contact requests, sends and reports")
+	fs.BoolVar(&c.RequirePadding, "require-padding", c.RequirePadding, "reject payloads which are not padded")
+
+	fs.Int64Var(&c.MaxPayloadSize, "max-payload-size", c.MaxPayloadSize, "request body limit in bytes")
+	fs.Int64Var(&c.MaxAttachmentSize, "max-attachment-size", c.MaxAttachmentSize, "size limit of uploaded blobs in bytes")
+	fs.DurationVar(&c.MaxRetention, "max-retention", c.MaxRetention, "how long undelivered envelopes are kept")
+	fs.Int64Var(&c.InboxMaxCount, "inbox-max-count", c.InboxMaxCount, "envelopes in one inbox, 0 is unlimited")
+	fs.Int64Var(&c.InboxMaxBytes, "inbox-max-bytes", c.InboxMaxBytes, "bytes in one inbox, 0 is unlimited")
+	fs.Int64Var(&c.InboxSenderMaxCount, "inbox-sender-max-count", c.InboxSenderMaxCount, "envelopes of one sender in one inbox, 0 is unlimited")
+	fs.Int64Var(&c.InboxSenderMaxBytes, "inbox-sender-max-bytes", c.InboxSenderMaxBytes, "bytes of one sender in one inbox, 0 is unlimited")
+	fs.Int64Var(&c.InboxServerMaxCount, "inbox-server-max-count", c.InboxServerMaxCount, "server notices in one inbox on top of inbox limits, 0 is unlimited")
+	fs.Int64Var(&c.InboxServerMaxBytes, "inbox-server-max-bytes", c.InboxServerMaxBytes, "bytes of server notices in one inbox on top of inbox limits, 0 is unlimited")
+	fs.Int64Var(&c.BlobQuota, "blob-quota", c.BlobQuota, "bytes of blobs one user can upload")
+	fs.DurationVar(&c.BlobMaxAge, "blob-max-age", c.BlobMaxAge, "how long blobs are kept after upload")
+	fs.DurationVar(&c.SweepInterval, "sweep-interval", c.SweepInterval, "how often expired envelopes and chat messages are removed")
+	fs.IntVar(&c.ChatMaxOpen, "chat-max-open", c.ChatMaxOpen, "chat databases kept open when they are not in use")
+	fs.DurationVar(&c.ChatIdleTimeout, "chat-idle-timeout", c.ChatIdleTimeout, "how long chat database is kept open after the last use")
+
+	fs.BoolVar(&c.Chats, "chats", c.Chats, "serve group chats")
+	fs.BoolVar(&c.Attachments, "attachments", c.Attachments, "serve blob uploads and accept envelopes with attachments")
+	fs.BoolVar(&c.Stream, "stream", c.Stream, "serve streams of ephemeral envelopes and presence")
+	fs.BoolVar(&c.SealedSender, "sealed-sender", c.SealedSender, "accept sealed sender envelopes")
+	fs.BoolVar(&c.Ratchet, "ratchet", c.Ratchet, "serve prekeys and accept double ratchet envelopes")
+
+	return fs
+}
+
+// loadConfig from args, environment and config file, returns args left after flags, which are admin command
+func loadConfig(args []string, getenv func(string) string) (config, []string, error) {
+	// flags are parsed first to find config file and applied last, so they override everything else
+	parsed := defaultConfig()
+	fs := parsed.flagSet()
+	configFile := fs.String("config", getenv("SOUL_CONFIG"), "JSON config file with options named as flags, e.g. {\"data-dir\": \"/var/lib/soul\"}")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: server [flags] [command]\n%s\n\nflags, also set as SOUL_ environment variables, e.g. SOUL_DATA_DIR:\n", commandsUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return config{}, nil, err
+	}
+
+	c := defaultConfig()
+	options := c.flagSet()
+
+	if *configFile != "" {
+		if err := readConfigFile(options, *configFile); err != nil {
+			return config{}, nil, err
+		}
+	}
+
+	var envErr error
+	options.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		if v := getenv(name); v != "" && envErr == nil {
+			if err := f.Value.Set(v); err != nil {
+				envErr = fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return config{}, nil, envErr
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && flagErr == nil {
+			flagErr = options.Set(f.Name, f.Value.String())
+		}
+	})
+	if flagErr != nil {
+		return config{}, nil, flagErr
+	}
+
+	return c, fs.Args(), c.validate()
+}
+
+// readConfigFile sets options from JSON object, values are strings, numbers or booleans
+func readConfigFile(options *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to decode config file %s: %w", path, err)
+	}
+
+	for name, raw := range values {
+		if options.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q in config file %s", name, path)
+		}
+
+		value := string(bytes.TrimSpace(raw))
+		if strings.HasPrefix(value, `"`) {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("invalid %q in config file %s: %w", name, path, err)
+			}
+		}
+
+		if err := options.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %q in config file %s: %w", name, path, err)
+		}
+	}
+
+	return nil
+}
+
+func envName(flagName string) string {
+	return "SOUL_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// validate returns every problem of config at once, named as flags
+func (c *config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.DataDir != "", "data-dir is required")
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address %q: %w", c.Listen, err))
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"public-url %q should be absolute http or https URL", c.PublicURL)
+	}
+
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls-cert and tls-key should be set together")
+	for name, path := range map[string]string{"tls-cert": c.TLSCert, "tls-key": c.TLSKey, "admin-key": c.AdminKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	check(c.KeyName != "", "key-name is required")
+
+	switch c.Registration {
+	case protocol.RegistrationOpen, protocol.RegistrationInvite:
+	case protocol.RegistrationProofOfWork:
+		check(c.RegistrationDifficulty >= 1 && c.RegistrationDifficulty <= protocol.MaxProofOfWorkDifficulty,
+			"registration-difficulty should be number of bits from 1 to %d", protocol.MaxProofOfWorkDifficulty)
+	default:
+		errs = append(errs, fmt.Errorf("unknown registration policy %q, it should be open, invite or proof-of-work", c.Registration))
+	}
+
+	check(c.MaxPayloadSize > 0, "max-payload-size should be positive")
+	check(c.MaxAttachmentSize > 0, "max-attachment-size should be positive")
+	check(c.MaxRetention > 0, "max-retention should be positive")
+	check(c.InboxMaxCount >= 0 && c.InboxMaxBytes >= 0 && c.InboxSenderMaxCount >= 0 && c.InboxSenderMaxBytes >= 0 &&
+		c.InboxServerMaxCount >= 0 && c.InboxServerMaxBytes >= 0,
+		"inbox limits should not be negative")
+	check(c.BlobQuota > 0, "blob-quota should be positive")
+	check(c.BlobMaxAge > 0, "blob-max-age should be positive")
+	check(c.SweepInterval > 0, "sweep-interval should be positive")
+	check(c.ChatMaxOpen > 0, "chat-max-open should be positive")
+	check(c.ChatIdleTimeout > 0, "chat-idle-timeout should be positive")
+
+	return errors.Join(errs...)
+}
+
+// webserverConfig reads admin key file, other options are validated already
+func (c *config) webserverConfig() (webserver.Config, error) {
+	wc := webserver.DefaultConfig()
+	wc.URL = strings.TrimSuffix(c.PublicURL, "/")
+	wc.MaxPayloadSize = c.MaxPayloadSize
+	wc.MaxAttachmentSize = c.MaxAttachmentSize
+	wc.MaxRetention = c.MaxRetention
+	wc.InboxLimits = inbox.Limits{
+		MaxCount:       c.InboxMaxCount,
+		MaxBytes:       c.InboxMaxBytes,
+		SenderMaxCount: c.InboxSenderMaxCount,
+		SenderMaxBytes: c.InboxSenderMaxBytes,
+		ServerMaxCount: c.InboxServerMaxCount,
+		ServerMaxBytes: c.InboxServerMaxBytes,
+	}
+	if !c.RateLimit {
+		wc.RateLimits = nil
+	}
+	wc.PadPolicy.Required = c.RequirePadding
+	wc.Chats = c.Chats
+	wc.Attachments = c.Attachments
+	wc.Stream = c.Stream
+	wc.SealedSender = c.SealedSender
+	wc.Ratchet = c.Ratchet
+
+	if c.AdminKey != "" {
+		data, err := os.ReadFile(c.AdminKey)
+		if err != nil {
+			return webserver.Config{}, fmt.Errorf("failed to read admin key: %w", err)
+		}
+		wc.AdminKey = string(data)
+	}
+
+	return wc, nil
+}
+
+func (c *config) blobPolicy() blobs.Policy {
+	policy := blobs.DefaultPolicy
+	policy.Quota = c.BlobQuota
+	policy.MaxAge = c.BlobMaxAge
+	return policy
+}
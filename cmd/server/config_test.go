@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{"data-dir": "/from/file", "listen": "127.0.0.1:9000", "max-retention": "48h", "inbox-max-count": 50, "rate-limit": false}`
+	if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"SOUL_CONFIG":   path,
+		"SOUL_DATA_DIR": "/from/env",
+		"SOUL_LISTEN":   "127.0.0.1:9001",
+	}
+	cfg, args, err := loadConfig([]string{"-listen", "127.0.0.1:9002", "invite", "5"}, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	if cfg.DataDir != "/from/env" {
+		t.Errorf("Expected environment to override file, got data dir %q", cfg.DataDir)
+	}
+	if cfg.Listen != "127.0.0.1:9002" {
+		t.Errorf("Expected flag to override environment, got listen %q", cfg.Listen)
+	}
+	if cfg.MaxRetention != 48*time.Hour || cfg.InboxMaxCount != 50 || cfg.RateLimit {
+		t.Errorf("Expected file options to apply, got retention %v inbox count %d rate limit %v", cfg.MaxRetention, cfg.InboxMaxCount, cfg.RateLimit)
+	}
+	if cfg.KeyName != "server" || cfg.BlobQuota != defaultConfig().BlobQuota {
+		t.Errorf("Expected defaults for options which are not set, got %+v", cfg)
+	}
+	if len(args) != 2 || args[0] != "invite" || args[1] != "5" {
+		t.Errorf("Expected command args to be left, got %v", args)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	noEnv := func(string) string { return "" }
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-listen", "8080"}, "invalid listen address"},
+		{[]string{"-public-url", "soul.example.com"}, "public-url"},
+		{[]string{"-tls-cert", "cert.pem"}, "tls-cert and tls-key should be set together"},
+		{[]string{"-registration", "proof-of-work"}, "registration-difficulty"},
+		{[]string{"-registration", "closed"}, "unknown registration policy"},
+		{[]string{"-max-retention", "0s"}, "max-retention should be positive"},
+		{[]string{"-admin-key", "/missing/admin.asc"}, "admin-key"},
+		{[]string{"-chat-max-open", "0"}, "chat-max-open should be positive"},
+		{[]string{"-chat-idle-timeout", "0s"}, "chat-idle-timeout should be positive"},
+	}
+	for _, test := range tests {
+		_, _, err := loadConfig(test.args, noEnv)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected %v to fail with %q, got %v", test.args, test.expected, err)
+		}
+	}
+
+	if _, _, err := loadConfig(nil, func(name string) string {
+		if name == "SOUL_MAX_PAYLOAD_SIZE" {
+			return "big"
+		}
+		return ""
+	}); err == nil || !strings.Contains(err.Error(), "SOUL_MAX_PAYLOAD_SIZE") {
+		t.Errorf("Expected invalid environment variable to be named, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"port": 8080}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadConfig([]string{"-config", path}, noEnv); err == nil || !strings.Contains(err.Error(), `unknown option "port"`) {
+		t.Errorf("Expected unknown option in config file to fail, got %v", err)
+	}
+
+	cfg, _, err := loadConfig([]string{"-public-url", "https://soul.example.com/"}, noEnv)
+	if err != nil {
+		t.Fatalf("Error loading valid config: %v", err)
+	}
+	if wc, err := cfg.webserverConfig(); err != nil || wc.URL != "https://soul.example.com" {
+		t.Errorf("Expected public URL without trailing slash, got %q %v", wc.URL, err)
+	}
+
+	cfg, _, err = loadConfig([]string{"-chats=false", "-ratchet=false"}, noEnv)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if wc, _ := cfg.webserverConfig(); wc.Chats || wc.Ratchet || !wc.Attachments || !wc.Stream || !wc.SealedSender {
+		t.Errorf("Expected only chats and ratchet to be disabled, got %+v", wc)
+	}
+}
@@ -1,43 +1,147 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/soul-ua/server/internal/accounts"
+	"github.com/soul-ua/server/internal/blobs"
+	"github.com/soul-ua/server/internal/chat"
+	"github.com/soul-ua/server/internal/expiry"
+	"github.com/soul-ua/server/internal/inbox"
+	"github.com/soul-ua/server/internal/prekeys"
+	"github.com/soul-ua/server/internal/ratelimit"
+	"github.com/soul-ua/server/internal/registration"
+	"github.com/soul-ua/server/internal/reports"
 	"github.com/soul-ua/server/internal/webserver"
 	"github.com/soul-ua/server/pkg/protocol"
 	"go.etcd.io/bbolt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 )
 
 func main() {
-	bdb, err := bbolt.Open(".data/storage.db", 0600, nil)
-	if err != nil {
+	cfg, args, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		log.Fatal("failed to create data directory: ", err)
+	}
+	inbox.Dir = cfg.DataDir
+
+	storagePath := filepath.Join(cfg.DataDir, "storage.db")
+	bdb, err := bbolt.Open(storagePath, 0600, &bbolt.Options{Timeout: time.Second})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		log.Fatalf("storage %s is locked, is another server running?", storagePath)
+	} else if err != nil {
 		panic(err)
 	}
 
 	accountsUsecase := accounts.NewAccountsBBolt(bdb)
-	_, _, err = ensureServerKeys(accountsUsecase)
+	invites := registration.NewInvitesBBolt(bdb)
+	reportsUsecase := reports.NewReportsBBolt(bdb)
+
+	if len(args) > 0 {
+		err := runCommand(args[0], args[1:], accountsUsecase, invites, reportsUsecase)
+		if closeErr := bdb.Close(); closeErr != nil {
+			log.Println("failed to close storage:", closeErr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	_, _, err = ensureServerKeys(accountsUsecase, cfg.KeyName, cfg.KeyEmail)
+	if err != nil {
+		panic(err)
+	}
+
+	chats, err := chat.NewStore(cfg.DataDir, cfg.ChatMaxOpen, cfg.ChatIdleTimeout)
+	if err != nil {
+		panic(err)
+	}
+
+	prekeysUsecase := prekeys.NewPrekeysBBolt(bdb)
+
+	blobStorage, err := blobs.NewFilesystem(cfg.DataDir)
+	if err != nil {
+		panic(err)
+	}
+
+	blobStore, err := blobs.NewStore(bdb, blobStorage, cfg.blobPolicy())
+	if err != nil {
+		panic(err)
+	}
+
+	webserverConfig, err := cfg.webserverConfig()
 	if err != nil {
 		panic(err)
 	}
 
-	srv, err := webserver.NewWebserver(accountsUsecase)
+	srv, err := webserver.NewWebserver(accountsUsecase, chats, prekeysUsecase, blobStore, ratelimit.NewLimiterMemory(), newRegistrationPolicy(cfg, invites), reportsUsecase, webserverConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	if err := srv.Start(":8080"); err != nil {
+	sweeper := expiry.NewSweeper(chats, blobStore, cfg.SweepInterval)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		log.Println("* shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("failed to shutdown webserver:", err)
+		}
+	}()
+
+	if err := srv.StartTLS(cfg.Listen, cfg.TLSCert, cfg.TLSKey); err != nil {
 		panic(err)
 	}
+
+	sweeper.Close()
+	blobStore.Close()
+	if err := chats.Close(); err != nil {
+		log.Println("failed to close chats:", err)
+	}
+	if err := bdb.Close(); err != nil {
+		log.Println("failed to close storage:", err)
+	}
+}
+
+// newRegistrationPolicy of validated config
+func newRegistrationPolicy(cfg config, invites *registration.Invites) registration.Policy {
+	switch cfg.Registration {
+	case protocol.RegistrationInvite:
+		return invites
+	case protocol.RegistrationProofOfWork:
+		return registration.NewProofOfWork(cfg.RegistrationDifficulty)
+	default:
+		return registration.NewOpen()
+	}
 }
 
-func ensureServerKeys(accountsUC accounts.Accounts) (string, string, error) {
+// ensureServerKeys generates server keys with name and email on the first start
+func ensureServerKeys(accountsUC accounts.Accounts, name, email string) (string, string, error) {
 	var serverPublicKey string
 	serverPrivateKey, err := accountsUC.GetUserPrivateKeyArmor("server")
 	if errors.Is(err, accounts.ErrorAccountNotFound) {
 		log.Println("* generate server keys")
-		serverPrivateKey, serverPublicKey, err = protocol.GeneratePair("sever", "server@soul.ua")
+		serverPrivateKey, serverPublicKey, err = protocol.GeneratePair(name, email)
 		if err != nil {
 			return "", "", fmt.Errorf("failed generate server keys: %w", err)
 		}
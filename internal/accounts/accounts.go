@@ -1,9 +1,42 @@
 package accounts
 
+import "github.com/soul-ua/server/pkg/protocol"
+
 type Accounts interface {
 	RegisterAccount(username, publicKey string) error
 	RegisterAccountPrivateKey(username, privateKey string) error
 
 	GetUserPublicKeyArmor(username string) (string, error)
 	GetUserPrivateKeyArmor(username string) (string, error)
+	// GetAccount returns public key and state, unlike GetUserPublicKeyArmor it returns key of deleted account,
+	// so requests of suspended and deleted accounts are verified before they are rejected
+	GetAccount(username string) (publicKey, state string, err error)
+
+	// AddDeliveryVerifier stores sha256 of delivery token username shared with a contact for sealed sender
+	// envelopes, verifier itself is the handle to revoke it, contact is never known to the server
+	AddDeliveryVerifier(username string, verifier []byte) error
+	RevokeDeliveryVerifier(username string, verifier []byte) error
+	// HasDeliveryVerifier reports whether verifier is one of username verifiers
+	HasDeliveryVerifier(username string, verifier []byte) (bool, error)
+
+	SetPrivacySettings(username string, settings protocol.PrivacySettings) error
+	// GetPrivacySettings returns defaults if user has not set them
+	GetPrivacySettings(username string) (protocol.PrivacySettings, error)
+
+	// Block or mute blocked by username, blocking again replaces the entry
+	Block(username string, blocked protocol.BlockedUser) error
+	Unblock(username, blocked string) error
+	// GetBlock of sender in username blocklist, false if sender is not blocked
+	GetBlock(username, sender string) (protocol.BlockedUser, bool, error)
+	GetBlocklist(username string) ([]protocol.BlockedUser, error)
+
+	// SetAccountState to one of protocol.Account* states, deleted accounts can't change state
+	SetAccountState(username, state string) error
+	// GetAccountState returns ErrorAccountNotFound if username is not registered
+	GetAccountState(username string) (string, error)
+	// ListAccounts sorted by username after after, deleted accounts are listed too
+	ListAccounts(after string, limit int) ([]protocol.AdminAccount, error)
+	// DeleteAccount marks account deleted and removes its settings and blocklist, public key is kept,
+	// so the username stays taken, but GetUserPublicKeyArmor returns ErrorAccountNotFound for it
+	DeleteAccount(username string) error
 }
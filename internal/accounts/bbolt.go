@@ -1,7 +1,10 @@
 package accounts
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
 	"go.etcd.io/bbolt"
 	"log"
 )
@@ -9,6 +12,8 @@ import (
 var (
 	ErrAccountAlreadyExists = errors.New("account already exists")
 	ErrorAccountNotFound    = errors.New("account not found")
+	ErrAccountSuspended     = errors.New("account is suspended")
+	ErrAccountDeleted       = errors.New("account is deleted")
 )
 
 type accountsMemory struct {
@@ -66,6 +71,9 @@ func (a *accountsMemory) GetUserPublicKeyArmor(username string) (string, error)
 			log.Println("account not found for username", username)
 			return ErrorAccountNotFound
 		}
+		if accountState(tx, username) == protocol.AccountDeleted {
+			return ErrorAccountNotFound
+		}
 
 		return nil
 	})
@@ -76,6 +84,30 @@ func (a *accountsMemory) GetUserPublicKeyArmor(username string) (string, error)
 	return string(publicKey), nil
 }
 
+func (a *accountsMemory) GetAccount(username string) (string, string, error) {
+	var publicKey, state string
+	err := a.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		if bucket == nil {
+			return ErrorAccountNotFound
+		}
+
+		key := bucket.Get([]byte(username))
+		if key == nil {
+			return ErrorAccountNotFound
+		}
+		publicKey = string(key)
+		state = accountState(tx, username)
+
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return publicKey, state, nil
+}
+
 func (a *accountsMemory) GetUserPrivateKeyArmor(username string) (string, error) {
 	var privateKey []byte
 	err := a.bdb.View(func(tx *bbolt.Tx) error {
@@ -97,3 +129,301 @@ func (a *accountsMemory) GetUserPrivateKeyArmor(username string) (string, error)
 
 	return string(privateKey), nil
 }
+
+// AddDeliveryVerifier stores verifier as a key in username bucket of "delivery-verifiers", verifiers have no
+// contact label, so lookup of a presented token doesn't tell the server who sends sealed envelopes
+func (a *accountsMemory) AddDeliveryVerifier(username string, verifier []byte) error {
+	return a.bdb.Update(func(tx *bbolt.Tx) error {
+		verifiers, err := tx.CreateBucketIfNotExists([]byte("delivery-verifiers"))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := verifiers.CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(verifier, []byte{})
+	})
+}
+
+func (a *accountsMemory) RevokeDeliveryVerifier(username string, verifier []byte) error {
+	return a.bdb.Update(func(tx *bbolt.Tx) error {
+		if bucket := a.deliveryVerifiers(tx, username); bucket != nil {
+			return bucket.Delete(verifier)
+		}
+		return nil
+	})
+}
+
+func (a *accountsMemory) HasDeliveryVerifier(username string, verifier []byte) (bool, error) {
+	found := false
+	err := a.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := a.deliveryVerifiers(tx, username)
+		if bucket == nil || len(verifier) == 0 {
+			return nil
+		}
+
+		found = bucket.Get(verifier) != nil
+		return nil
+	})
+	return found, err
+}
+
+func (a *accountsMemory) deliveryVerifiers(tx *bbolt.Tx, username string) *bbolt.Bucket {
+	verifiers := tx.Bucket([]byte("delivery-verifiers"))
+	if verifiers == nil {
+		return nil
+	}
+	return verifiers.Bucket([]byte(username))
+}
+
+func (a *accountsMemory) SetPrivacySettings(username string, settings protocol.PrivacySettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	return a.bdb.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("privacy"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(username), data)
+	})
+}
+
+func (a *accountsMemory) GetPrivacySettings(username string) (protocol.PrivacySettings, error) {
+	settings := protocol.PrivacySettings{
+		Presence: protocol.PresenceEveryone,
+	}
+
+	err := a.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("privacy"))
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(username))
+		if data == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, &settings)
+	})
+	if err != nil {
+		return protocol.PrivacySettings{}, err
+	}
+
+	return settings, nil
+}
+
+// Block stores entry as JSON in nested bucket of username in "blocklists" bucket
+func (a *accountsMemory) Block(username string, blocked protocol.BlockedUser) error {
+	data, err := json.Marshal(blocked)
+	if err != nil {
+		return err
+	}
+
+	return a.bdb.Update(func(tx *bbolt.Tx) error {
+		blocklists, err := tx.CreateBucketIfNotExists([]byte("blocklists"))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := blocklists.CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(blocked.Username), data)
+	})
+}
+
+func (a *accountsMemory) Unblock(username, blocked string) error {
+	return a.bdb.Update(func(tx *bbolt.Tx) error {
+		bucket := a.blocklist(tx, username)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete([]byte(blocked))
+	})
+}
+
+func (a *accountsMemory) GetBlock(username, sender string) (protocol.BlockedUser, bool, error) {
+	var blocked protocol.BlockedUser
+	found := false
+	err := a.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := a.blocklist(tx, username)
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(sender))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(data, &blocked)
+	})
+	return blocked, found, err
+}
+
+func (a *accountsMemory) GetBlocklist(username string) ([]protocol.BlockedUser, error) {
+	blocklist := make([]protocol.BlockedUser, 0)
+	err := a.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := a.blocklist(tx, username)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var blocked protocol.BlockedUser
+			if err := json.Unmarshal(v, &blocked); err != nil {
+				return fmt.Errorf("failed to decode blocklist entry %s: %w", k, err)
+			}
+			blocklist = append(blocklist, blocked)
+			return nil
+		})
+	})
+	return blocklist, err
+}
+
+func (a *accountsMemory) blocklist(tx *bbolt.Tx, username string) *bbolt.Bucket {
+	blocklists := tx.Bucket([]byte("blocklists"))
+	if blocklists == nil {
+		return nil
+	}
+	return blocklists.Bucket([]byte(username))
+}
+
+// SetAccountState stores state in "account-states" bucket, accounts without state are active
+func (a *accountsMemory) SetAccountState(username, state string) error {
+	switch state {
+	case protocol.AccountActive, protocol.AccountSuspended:
+	case protocol.AccountDeleted:
+		return a.DeleteAccount(username)
+	default:
+		return fmt.Errorf("unknown account state %q", state)
+	}
+
+	return a.bdb.Update(func(tx *bbolt.Tx) error {
+		if err := checkAccountState(tx, username); err != nil {
+			return err
+		}
+
+		bucket, err := tx.CreateBucketIfNotExists([]byte("account-states"))
+		if err != nil {
+			return err
+		}
+
+		if state == protocol.AccountActive {
+			return bucket.Delete([]byte(username))
+		}
+		return bucket.Put([]byte(username), []byte(state))
+	})
+}
+
+func (a *accountsMemory) GetAccountState(username string) (string, error) {
+	var state string
+	err := a.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		if bucket == nil || bucket.Get([]byte(username)) == nil {
+			return ErrorAccountNotFound
+		}
+
+		state = accountState(tx, username)
+		return nil
+	})
+	return state, err
+}
+
+func (a *accountsMemory) ListAccounts(after string, limit int) ([]protocol.AdminAccount, error) {
+	list := make([]protocol.AdminAccount, 0)
+	err := a.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		k, _ := c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, _ = c.Next()
+		}
+		for ; k != nil && len(list) < limit; k, _ = c.Next() {
+			list = append(list, protocol.AdminAccount{
+				Username: string(k),
+				State:    accountState(tx, string(k)),
+			})
+		}
+		return nil
+	})
+	return list, err
+}
+
+// DeleteAccount keeps public key in "accounts" bucket, RegisterAccount checks it to keep the username taken
+func (a *accountsMemory) DeleteAccount(username string) error {
+	return a.bdb.Update(func(tx *bbolt.Tx) error {
+		if err := checkAccountState(tx, username); err != nil {
+			return err
+		}
+
+		if bucket := tx.Bucket([]byte("privacy")); bucket != nil {
+			if err := bucket.Delete([]byte(username)); err != nil {
+				return err
+			}
+		}
+
+		if verifiers := tx.Bucket([]byte("delivery-verifiers")); verifiers != nil {
+			if verifiers.Bucket([]byte(username)) != nil {
+				if err := verifiers.DeleteBucket([]byte(username)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if a.blocklist(tx, username) != nil {
+			if err := tx.Bucket([]byte("blocklists")).DeleteBucket([]byte(username)); err != nil {
+				return err
+			}
+		}
+
+		bucket, err := tx.CreateBucketIfNotExists([]byte("account-states"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(username), []byte(protocol.AccountDeleted))
+	})
+}
+
+// checkAccountState fails if username is not registered or deleted
+func checkAccountState(tx *bbolt.Tx, username string) error {
+	accounts := tx.Bucket([]byte("accounts"))
+	if accounts == nil || accounts.Get([]byte(username)) == nil {
+		return ErrorAccountNotFound
+	}
+
+	if accountState(tx, username) == protocol.AccountDeleted {
+		return ErrAccountDeleted
+	}
+	return nil
+}
+
+func accountState(tx *bbolt.Tx, username string) string {
+	bucket := tx.Bucket([]byte("account-states"))
+	if bucket == nil {
+		return protocol.AccountActive
+	}
+
+	state := bucket.Get([]byte(username))
+	if state == nil {
+		return protocol.AccountActive
+	}
+	return string(state)
+}
@@ -0,0 +1,240 @@
+package accounts
+
+import (
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccountStates(t *testing.T) {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer bdb.Close()
+
+	a := NewAccountsBBolt(bdb)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		if err := a.RegisterAccount(username, "key of "+username); err != nil {
+			t.Fatalf("Error registering %s: %v", username, err)
+		}
+	}
+
+	if state, err := a.GetAccountState("alice"); err != nil || state != protocol.AccountActive {
+		t.Errorf("Expected alice to be active, got %q %v", state, err)
+	}
+	if _, err := a.GetAccountState("dave"); !errors.Is(err, ErrorAccountNotFound) {
+		t.Errorf("Expected ErrorAccountNotFound for unknown account, got %v", err)
+	}
+	if err := a.SetAccountState("dave", protocol.AccountSuspended); !errors.Is(err, ErrorAccountNotFound) {
+		t.Errorf("Expected unknown account not to be suspended, got %v", err)
+	}
+
+	if err := a.SetAccountState("alice", protocol.AccountSuspended); err != nil {
+		t.Fatalf("Error suspending alice: %v", err)
+	}
+	if err := a.SetPrivacySettings("bob", protocol.PrivacySettings{Presence: protocol.PresenceNobody}); err != nil {
+		t.Fatalf("Error setting privacy: %v", err)
+	}
+	if err := a.Block("bob", protocol.BlockedUser{Username: "carol"}); err != nil {
+		t.Fatalf("Error blocking: %v", err)
+	}
+	if err := a.DeleteAccount("bob"); err != nil {
+		t.Fatalf("Error deleting bob: %v", err)
+	}
+
+	list, err := a.ListAccounts("", 10)
+	if err != nil {
+		t.Fatalf("Error listing accounts: %v", err)
+	}
+	expected := []protocol.AdminAccount{
+		{Username: "alice", State: protocol.AccountSuspended},
+		{Username: "bob", State: protocol.AccountDeleted},
+		{Username: "carol", State: protocol.AccountActive},
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, list)
+	}
+	for i := range expected {
+		if list[i] != expected[i] {
+			t.Errorf("Expected %v at %d, got %v", expected[i], i, list[i])
+		}
+	}
+	if page, _ := a.ListAccounts("alice", 1); len(page) != 1 || page[0].Username != "bob" {
+		t.Errorf("Expected page after alice to be bob, got %v", page)
+	}
+
+	if _, err := a.GetUserPublicKeyArmor("bob"); !errors.Is(err, ErrorAccountNotFound) {
+		t.Errorf("Expected deleted account key to be gone, got %v", err)
+	}
+	if key, state, err := a.GetAccount("bob"); err != nil || key != "key of bob" || state != protocol.AccountDeleted {
+		t.Errorf("Expected deleted account to keep key for verification, got %q %q %v", key, state, err)
+	}
+	if err := a.RegisterAccount("bob", "new key"); !errors.Is(err, ErrAccountAlreadyExists) {
+		t.Errorf("Expected deleted username to stay taken, got %v", err)
+	}
+	if err := a.SetAccountState("bob", protocol.AccountActive); !errors.Is(err, ErrAccountDeleted) {
+		t.Errorf("Expected deleted account not to be reinstated, got %v", err)
+	}
+	if blocklist, _ := a.GetBlocklist("bob"); len(blocklist) != 0 {
+		t.Errorf("Expected blocklist of deleted account to be removed, got %v", blocklist)
+	}
+	if settings, _ := a.GetPrivacySettings("bob"); settings.Presence != protocol.PresenceEveryone {
+		t.Errorf("Expected privacy settings of deleted account to be removed, got %v", settings)
+	}
+
+	if err := a.SetAccountState("alice", protocol.AccountActive); err != nil {
+		t.Fatalf("Error reinstating alice: %v", err)
+	}
+	if state, _ := a.GetAccountState("alice"); state != protocol.AccountActive {
+		t.Errorf("Expected alice to be active after reinstate, got %q", state)
+	}
+}
+
+func TestDeliveryVerifiers(t *testing.T) {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer bdb.Close()
+
+	a := NewAccountsBBolt(bdb)
+	if err := a.RegisterAccount("alice", "key of alice"); err != nil {
+		t.Fatalf("Error registering alice: %v", err)
+	}
+
+	bob, carol := []byte("verifier of bob"), []byte("verifier of carol")
+	for _, verifier := range [][]byte{bob, carol} {
+		if err := a.AddDeliveryVerifier("alice", verifier); err != nil {
+			t.Fatalf("Error adding verifier: %v", err)
+		}
+	}
+
+	if ok, err := a.HasDeliveryVerifier("alice", bob); err != nil || !ok {
+		t.Errorf("Expected verifier of bob to be valid, got %v %v", ok, err)
+	}
+	if ok, _ := a.HasDeliveryVerifier("dave", bob); ok {
+		t.Errorf("Expected verifier to be valid only for its account")
+	}
+	if ok, _ := a.HasDeliveryVerifier("alice", nil); ok {
+		t.Errorf("Expected empty verifier to be rejected")
+	}
+
+	if err := a.RevokeDeliveryVerifier("alice", bob); err != nil {
+		t.Fatalf("Error revoking verifier: %v", err)
+	}
+	if ok, _ := a.HasDeliveryVerifier("alice", bob); ok {
+		t.Errorf("Expected revoked verifier to be rejected")
+	}
+	if ok, _ := a.HasDeliveryVerifier("alice", carol); !ok {
+		t.Errorf("Expected verifier of other contact to stay valid")
+	}
+
+	// verifiers are stored without contact labels
+	_ = bdb.View(func(tx *bbolt.Tx) error {
+		return a.(*accountsMemory).deliveryVerifiers(tx, "alice").ForEach(func(k, v []byte) error {
+			if string(k) != string(carol) || len(v) != 0 {
+				t.Errorf("Expected only verifier itself to be stored, got %q %q", k, v)
+			}
+			return nil
+		})
+	})
+
+	if err := a.DeleteAccount("alice"); err != nil {
+		t.Fatalf("Error deleting alice: %v", err)
+	}
+	if ok, _ := a.HasDeliveryVerifier("alice", carol); ok {
+		t.Errorf("Expected verifiers of deleted account to be removed")
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer bdb.Close()
+
+	a := NewAccountsBBolt(bdb)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		if err := a.RegisterAccount(username, "key of "+username); err != nil {
+			t.Fatalf("Error registering %s: %v", username, err)
+		}
+	}
+
+	if blocklist, err := a.GetBlocklist("alice"); err != nil || blocklist == nil || len(blocklist) != 0 {
+		t.Errorf("Expected empty blocklist, got %v %v", blocklist, err)
+	}
+	if _, found, err := a.GetBlock("alice", "bob"); err != nil || found {
+		t.Errorf("Expected bob not to be blocked, got %v %v", found, err)
+	}
+
+	if err := a.Block("alice", protocol.BlockedUser{Username: "bob", Since: 1}); err != nil {
+		t.Fatalf("Error blocking bob: %v", err)
+	}
+	if err := a.Block("alice", protocol.BlockedUser{Username: "carol", Mute: true, Since: 2}); err != nil {
+		t.Fatalf("Error muting carol: %v", err)
+	}
+	if err := a.Block("bob", protocol.BlockedUser{Username: "alice", Since: 3}); err != nil {
+		t.Fatalf("Error blocking alice: %v", err)
+	}
+
+	if blocked, found, err := a.GetBlock("alice", "bob"); err != nil || !found || blocked.Mute || blocked.Since != 1 {
+		t.Errorf("Expected bob to be blocked, got %+v %v %v", blocked, found, err)
+	}
+	if blocked, found, err := a.GetBlock("alice", "carol"); err != nil || !found || !blocked.Mute {
+		t.Errorf("Expected carol to be muted, got %+v %v %v", blocked, found, err)
+	}
+	if _, found, _ := a.GetBlock("carol", "alice"); found {
+		t.Errorf("Expected block to apply only to blocklist of its owner")
+	}
+
+	// blocking again replaces the entry
+	if err := a.Block("alice", protocol.BlockedUser{Username: "carol", Since: 4}); err != nil {
+		t.Fatalf("Error blocking carol: %v", err)
+	}
+	blocklist, err := a.GetBlocklist("alice")
+	if err != nil {
+		t.Fatalf("Error getting blocklist: %v", err)
+	}
+	expected := []protocol.BlockedUser{{Username: "bob", Since: 1}, {Username: "carol", Since: 4}}
+	if len(blocklist) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, blocklist)
+	}
+	for i := range expected {
+		if blocklist[i] != expected[i] {
+			t.Errorf("Expected %v at %d, got %v", expected[i], i, blocklist[i])
+		}
+	}
+
+	if err := a.Unblock("alice", "bob"); err != nil {
+		t.Fatalf("Error unblocking bob: %v", err)
+	}
+	if _, found, _ := a.GetBlock("alice", "bob"); found {
+		t.Errorf("Expected bob to be unblocked")
+	}
+	if err := a.Unblock("alice", "bob"); err != nil {
+		t.Errorf("Expected unblocking again to succeed, got %v", err)
+	}
+	if err := a.Unblock("carol", "alice"); err != nil {
+		t.Errorf("Expected unblocking with empty blocklist to succeed, got %v", err)
+	}
+	if blocklist, _ := a.GetBlocklist("alice"); len(blocklist) != 1 || blocklist[0].Username != "carol" {
+		t.Errorf("Expected only carol left, got %v", blocklist)
+	}
+
+	if err := a.DeleteAccount("alice"); err != nil {
+		t.Fatalf("Error deleting alice: %v", err)
+	}
+	if blocklist, err := a.GetBlocklist("alice"); err != nil || len(blocklist) != 0 {
+		t.Errorf("Expected blocklist of deleted account to be removed, got %v %v", blocklist, err)
+	}
+	if _, found, _ := a.GetBlock("alice", "carol"); found {
+		t.Errorf("Expected blocks of deleted account to be removed")
+	}
+	if _, found, _ := a.GetBlock("bob", "alice"); !found {
+		t.Errorf("Expected blocks of other accounts to stay, deleted username is never reused")
+	}
+}
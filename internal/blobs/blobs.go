@@ -0,0 +1,38 @@
+package blobs
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrBlobNotFound   = errors.New("blob not found")
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("chunk offset does not match upload offset")
+	ErrChunkTooLarge  = errors.New("chunk exceeds upload size")
+)
+
+// Upload in progress, BlobID is set once all Size bytes are written
+type Upload struct {
+	ID     string `json:"id"`
+	Owner  string `json:"owner"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+	BlobID string `json:"blob_id,omitempty"`
+}
+
+// Storage keeps encrypted blobs addressed by sha256 of their content, it never sees plaintext
+type Storage interface {
+	// CreateUpload with ID given by the caller, it should be UUID
+	CreateUpload(uploadID, owner string, size int64) (Upload, error)
+	GetUpload(uploadID string) (Upload, error)
+	// WriteChunk appends chunk at offset, which must be the current upload offset, so a chunk sent twice
+	// after a lost response is rejected instead of duplicated. The last chunk completes the upload.
+	WriteChunk(uploadID string, offset int64, chunk []byte) (Upload, error)
+	// DeleteUpload removes upload whether it is complete or not, the blob it produced is kept
+	DeleteUpload(uploadID string) error
+
+	// Open blob for reading, the caller must close it
+	Open(blobID string) (io.ReadSeekCloser, error)
+	Delete(blobID string) error
+}
@@ -0,0 +1,286 @@
+package blobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/soul-ua/server/pkg/protocol"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// filesystem keeps blobs in dir/blobs/<first two hex digits>/<blob ID>, uploads in progress are
+// dir/uploads/<upload ID>.part with metadata next to it, so they survive restarts
+type filesystem struct {
+	dir string
+
+	mu      sync.Mutex // guards uploads
+	uploads map[string]*uploadLock
+	// blobMu is shared by all uploads, it is held only to move completed upload to its content address
+	blobMu sync.Mutex
+}
+
+// uploadLock serializes writes of one upload, different uploads are written, synced and hashed in parallel
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+var _ Storage = &filesystem{}
+
+func NewFilesystem(dir string) (Storage, error) {
+	for _, sub := range []string{"blobs", "uploads"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create %s directory: %w", sub, err)
+		}
+	}
+
+	return &filesystem{
+		dir:     dir,
+		uploads: make(map[string]*uploadLock),
+	}, nil
+}
+
+func (f *filesystem) CreateUpload(uploadID, owner string, size int64) (Upload, error) {
+	if err := uuid.Validate(uploadID); err != nil {
+		return Upload{}, fmt.Errorf("invalid upload ID: %w", err)
+	}
+
+	upload := Upload{
+		ID:    uploadID,
+		Owner: owner,
+		Size:  size,
+	}
+
+	defer f.lockUpload(uploadID)()
+
+	part, err := os.OpenFile(f.partPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return Upload{}, fmt.Errorf("failed to create upload: %w", err)
+	}
+	if err := part.Close(); err != nil {
+		return Upload{}, fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	if err := f.saveUpload(upload); err != nil {
+		return Upload{}, err
+	}
+
+	return upload, nil
+}
+
+func (f *filesystem) GetUpload(uploadID string) (Upload, error) {
+	defer f.lockUpload(uploadID)()
+
+	return f.loadUpload(uploadID)
+}
+
+func (f *filesystem) WriteChunk(uploadID string, offset int64, chunk []byte) (Upload, error) {
+	defer f.lockUpload(uploadID)()
+
+	upload, err := f.loadUpload(uploadID)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	if upload.BlobID != "" || offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+	if offset+int64(len(chunk)) > upload.Size {
+		return upload, ErrChunkTooLarge
+	}
+
+	part, err := os.OpenFile(f.partPath(uploadID), os.O_WRONLY, 0600)
+	if err != nil {
+		return Upload{}, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer part.Close()
+
+	// the part file may be longer than offset if metadata was not saved after the previous write
+	if _, err := part.WriteAt(chunk, offset); err != nil {
+		return Upload{}, fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := part.Sync(); err != nil {
+		return Upload{}, fmt.Errorf("failed to write chunk: %w", err)
+	}
+
+	upload.Offset += int64(len(chunk))
+	if upload.Offset == upload.Size {
+		if err := part.Truncate(upload.Size); err != nil {
+			return Upload{}, fmt.Errorf("failed to truncate upload: %w", err)
+		}
+		if upload.BlobID, err = f.complete(uploadID); err != nil {
+			return Upload{}, err
+		}
+	}
+
+	if err := f.saveUpload(upload); err != nil {
+		return Upload{}, err
+	}
+
+	return upload, nil
+}
+
+// complete moves part file to its content address, must be called with lock of the upload held
+func (f *filesystem) complete(uploadID string) (string, error) {
+	part, err := os.Open(f.partPath(uploadID))
+	if err != nil {
+		return "", fmt.Errorf("failed to open upload: %w", err)
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, part)
+	part.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to hash upload: %w", err)
+	}
+	blobID := hex.EncodeToString(hash.Sum(nil))
+
+	f.blobMu.Lock()
+	defer f.blobMu.Unlock()
+
+	path := f.blobPath(blobID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		// same content was uploaded before
+		return blobID, os.Remove(f.partPath(uploadID))
+	}
+
+	if err := os.Rename(f.partPath(uploadID), path); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	return blobID, nil
+}
+
+func (f *filesystem) Open(blobID string) (io.ReadSeekCloser, error) {
+	if !protocol.ValidBlobID(blobID) {
+		return nil, ErrBlobNotFound
+	}
+
+	file, err := os.Open(f.blobPath(blobID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+
+	return file, nil
+}
+
+func (f *filesystem) DeleteUpload(uploadID string) error {
+	if err := uuid.Validate(uploadID); err != nil {
+		return ErrUploadNotFound
+	}
+
+	defer f.lockUpload(uploadID)()
+
+	for _, path := range []string{f.partPath(uploadID), f.metaPath(uploadID)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete upload: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (f *filesystem) Delete(blobID string) error {
+	if !protocol.ValidBlobID(blobID) {
+		return ErrBlobNotFound
+	}
+
+	f.blobMu.Lock()
+	defer f.blobMu.Unlock()
+
+	if err := os.Remove(f.blobPath(blobID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	return nil
+}
+
+// lockUpload locks uploadID and returns its unlock, locks are dropped when nobody holds or waits for them
+func (f *filesystem) lockUpload(uploadID string) func() {
+	f.mu.Lock()
+	l, ok := f.uploads[uploadID]
+	if !ok {
+		l = &uploadLock{}
+		f.uploads[uploadID] = l
+	}
+	l.refs++
+	f.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		f.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(f.uploads, uploadID)
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (f *filesystem) loadUpload(uploadID string) (Upload, error) {
+	if err := uuid.Validate(uploadID); err != nil {
+		return Upload{}, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(f.metaPath(uploadID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Upload{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return Upload{}, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	var upload Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return Upload{}, fmt.Errorf("failed to decode upload: %w", err)
+	}
+
+	return upload, nil
+}
+
+// saveUpload replaces metadata atomically, so a crash never leaves it half written
+func (f *filesystem) saveUpload(upload Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload: %w", err)
+	}
+
+	tmp := f.metaPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upload: %w", err)
+	}
+
+	if err := os.Rename(tmp, f.metaPath(upload.ID)); err != nil {
+		return fmt.Errorf("failed to write upload: %w", err)
+	}
+
+	return nil
+}
+
+func (f *filesystem) partPath(uploadID string) string {
+	return filepath.Join(f.dir, "uploads", uploadID+".part")
+}
+
+func (f *filesystem) metaPath(uploadID string) string {
+	return filepath.Join(f.dir, "uploads", uploadID+".json")
+}
+
+func (f *filesystem) blobPath(blobID string) string {
+	return filepath.Join(f.dir, "blobs", blobID[:2], blobID)
+}
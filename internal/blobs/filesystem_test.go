@@ -0,0 +1,123 @@
+package blobs
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/soul-ua/server/pkg/protocol"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestResumableUpload(t *testing.T) {
+	s, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating storage: %v", err)
+	}
+
+	content := []byte("encrypted attachment")
+	upload, err := s.CreateUpload(uuid.NewString(), "alice", int64(len(content)))
+	if err != nil {
+		t.Fatalf("Error creating upload: %v", err)
+	}
+
+	if _, err := s.WriteChunk(upload.ID, 0, content[:9]); err != nil {
+		t.Fatalf("Error writing chunk: %v", err)
+	}
+
+	// the first chunk again, e.g. response was lost and client retried
+	if _, err := s.WriteChunk(upload.ID, 0, content[:9]); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("Expected ErrOffsetMismatch, got %v", err)
+	}
+
+	upload, err = s.GetUpload(upload.ID)
+	if err != nil || upload.Offset != 9 || upload.Owner != "alice" {
+		t.Fatalf("Expected upload at offset 9, got %+v, %v", upload, err)
+	}
+
+	if _, err := s.WriteChunk(upload.ID, 9, append(content[9:], 'x')); !errors.Is(err, ErrChunkTooLarge) {
+		t.Errorf("Expected ErrChunkTooLarge, got %v", err)
+	}
+
+	upload, err = s.WriteChunk(upload.ID, 9, content[9:])
+	if err != nil {
+		t.Fatalf("Error writing chunk: %v", err)
+	}
+	if upload.BlobID != protocol.BlobID(content) {
+		t.Fatalf("Expected blob ID %s, got %s", protocol.BlobID(content), upload.BlobID)
+	}
+
+	blob, err := s.Open(upload.BlobID)
+	if err != nil {
+		t.Fatalf("Error opening blob: %v", err)
+	}
+	defer blob.Close()
+
+	data, _ := io.ReadAll(blob)
+	if string(data) != string(content) {
+		t.Errorf("Blob content %q, want %q", data, content)
+	}
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	s, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating storage: %v", err)
+	}
+
+	// same content in every upload, so they all complete to the same content address
+	content := []byte("encrypted attachment")
+	blobIDs := make([]string, 8)
+	errs := make([]error, len(blobIDs))
+	var wg sync.WaitGroup
+	for i := range blobIDs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			upload, err := s.CreateUpload(uuid.NewString(), "alice", int64(len(content)))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			for offset := 0; offset < len(content); offset += 5 {
+				if upload, err = s.WriteChunk(upload.ID, int64(offset), content[offset:min(offset+5, len(content))]); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+			blobIDs[i] = upload.BlobID
+		}(i)
+	}
+	wg.Wait()
+
+	for i, blobID := range blobIDs {
+		if errs[i] != nil {
+			t.Fatalf("Error uploading: %v", errs[i])
+		}
+		if blobID != protocol.BlobID(content) {
+			t.Errorf("Expected blob ID %s, got %s", protocol.BlobID(content), blobID)
+		}
+	}
+
+	if n := len(s.(*filesystem).uploads); n != 0 {
+		t.Errorf("Expected upload locks to be dropped, got %d", n)
+	}
+}
+
+func TestOpenUnknownBlob(t *testing.T) {
+	s, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating storage: %v", err)
+	}
+
+	for _, id := range []string{protocol.BlobID([]byte("missing")), "../../etc/passwd"} {
+		if _, err := s.Open(id); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("Expected ErrBlobNotFound for %q, got %v", id, err)
+		}
+	}
+
+	if _, err := s.GetUpload("../x"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected ErrUploadNotFound, got %v", err)
+	}
+}
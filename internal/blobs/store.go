@@ -0,0 +1,573 @@
+package blobs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.etcd.io/bbolt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+var (
+	blobsBucket   = []byte("blobs")
+	uploadsBucket = []byte("blob-uploads")
+	usageBucket   = []byte("blob-usage")
+	refsBucket    = []byte("blob-refs")
+)
+
+// Policy of blob retention, zero durations disable the rule
+type Policy struct {
+	// Quota in bytes per user, uploads in progress are counted in full
+	Quota int64
+	// MaxAge after upload when blob is deleted even if some recipients have not downloaded it
+	MaxAge time.Duration
+	// DownloadedGrace keeps blob after the last recipient downloaded it, so broken downloads can be retried
+	DownloadedGrace time.Duration
+	// UnreferencedGrace is how long uploads and blobs which are not sent in any envelope are kept
+	UnreferencedGrace time.Duration
+	// CollectInterval of the background collector
+	CollectInterval time.Duration
+}
+
+var DefaultPolicy = Policy{
+	Quota:             1 << 30,
+	MaxAge:            30 * 24 * time.Hour,
+	DownloadedGrace:   time.Hour,
+	UnreferencedGrace: 24 * time.Hour,
+	CollectInterval:   10 * time.Minute,
+}
+
+// Store adds ownership, quotas and reference counting on top of Storage. Blobs are referenced by envelopes
+// and chat messages which mention them, and are collected once every recipient downloaded them or when they expire.
+type Store struct {
+	storage Storage
+	bdb     *bbolt.DB
+	policy  Policy
+
+	// filesMu orders deletion of blob files after their records are gone with recording of new blobs
+	filesMu sync.Mutex
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+type blobRecord struct {
+	Owner     string `json:"owner"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+
+	// Refs maps envelope or chat message ID to recipient who has not downloaded the blob yet,
+	// chat messages have no recipient and keep the blob until it expires
+	Refs         map[string]string `json:"refs,omitempty"`
+	Referenced   bool              `json:"referenced,omitempty"`
+	DownloadedAt int64             `json:"downloaded_at,omitempty"` // when the last reference was dropped
+}
+
+// uploadRecord is kept after completion, so clients which lost the last response can learn blob ID
+type uploadRecord struct {
+	Owner     string `json:"owner"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+	BlobID    string `json:"blob_id,omitempty"` // set on completion, size is counted in the blob then
+}
+
+func NewStore(bdb *bbolt.DB, storage Storage, policy Policy) (*Store, error) {
+	err := bdb.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{blobsBucket, uploadsBucket, usageBucket, refsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob buckets: %w", err)
+	}
+
+	s := &Store{
+		storage: storage,
+		bdb:     bdb,
+		policy:  policy,
+
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if policy.CollectInterval > 0 {
+		go s.collectLoop()
+	} else {
+		close(s.done)
+	}
+
+	return s, nil
+}
+
+// CreateUpload reserves size bytes of owner quota until the upload is collected or the blob is deleted.
+// Reservation is committed before Storage creates the upload, reservation left by a crash in between
+// is collected as an old upload.
+func (s *Store) CreateUpload(owner string, size int64) (Upload, error) {
+	uploadID := uuid.NewString()
+	err := s.bdb.Update(func(tx *bbolt.Tx) error {
+		used := getUsage(tx, owner)
+		if s.policy.Quota > 0 && used+size > s.policy.Quota {
+			return ErrQuotaExceeded
+		}
+
+		if err := putJSON(tx.Bucket(uploadsBucket), uploadID, uploadRecord{
+			Owner:     owner,
+			Size:      size,
+			CreatedAt: time.Now().Unix(),
+		}); err != nil {
+			return err
+		}
+
+		return putUsage(tx, owner, used+size)
+	})
+	if err != nil {
+		return Upload{}, err
+	}
+
+	upload, err := s.storage.CreateUpload(uploadID, owner, size)
+	if err != nil {
+		if releaseErr := s.bdb.Update(func(tx *bbolt.Tx) error { return deleteUploadRecord(tx, uploadID) }); releaseErr != nil {
+			log.Println("failed to release upload reservation", uploadID, releaseErr)
+		}
+		return Upload{}, err
+	}
+
+	return upload, nil
+}
+
+// GetUpload from Storage, upload completed by Storage but not recorded yet is recorded now
+func (s *Store) GetUpload(uploadID string) (Upload, error) {
+	upload, err := s.storage.GetUpload(uploadID)
+	if err != nil || upload.BlobID == "" {
+		return upload, err
+	}
+
+	if err := s.recordBlob(uploadID, upload.BlobID); err != nil {
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// WriteChunk to Storage, the last chunk turns upload reservation into blob owned by the uploader.
+// Storage completes the upload before it is recorded, so the last chunk sent again after a failed record
+// is not a mismatch, it records the blob again.
+func (s *Store) WriteChunk(uploadID string, offset int64, chunk []byte) (Upload, error) {
+	upload, err := s.storage.WriteChunk(uploadID, offset, chunk)
+	if errors.Is(err, ErrOffsetMismatch) && upload.BlobID != "" && offset+int64(len(chunk)) == upload.Size {
+		err = nil
+	}
+	if err != nil || upload.BlobID == "" {
+		return upload, err
+	}
+
+	if err := s.recordBlob(uploadID, upload.BlobID); err != nil {
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// recordBlob of completed upload, it does nothing if the upload is recorded already.
+// Collect may delete the file of the same content which was stored before, so it is checked under filesMu.
+func (s *Store) recordBlob(uploadID, blobID string) error {
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+
+	recorded := true
+	err := s.bdb.View(func(tx *bbolt.Tx) error {
+		var reserved uploadRecord
+		ok, err := getJSON(tx.Bucket(uploadsBucket), uploadID, &reserved)
+		recorded = !ok || reserved.BlobID != ""
+		return err
+	})
+	if err != nil || recorded {
+		return err
+	}
+
+	blob, err := s.storage.Open(blobID)
+	if err != nil {
+		return fmt.Errorf("failed to record blob: %w", err)
+	}
+	_ = blob.Close()
+
+	err = s.bdb.Update(func(tx *bbolt.Tx) error {
+		uploads := tx.Bucket(uploadsBucket)
+		var reserved uploadRecord
+		if ok, err := getJSON(uploads, uploadID, &reserved); err != nil || !ok || reserved.BlobID != "" {
+			return err
+		}
+
+		completed := reserved
+		completed.BlobID = blobID
+		if err := putJSON(uploads, uploadID, completed); err != nil {
+			return err
+		}
+
+		blobs := tx.Bucket(blobsBucket)
+		var record blobRecord
+		if ok, err := getJSON(blobs, blobID, &record); err != nil {
+			return err
+		} else if ok {
+			// same content is stored and counted already
+			return putUsage(tx, reserved.Owner, getUsage(tx, reserved.Owner)-reserved.Size)
+		}
+
+		return putJSON(blobs, blobID, blobRecord{
+			Owner:     reserved.Owner,
+			Size:      reserved.Size,
+			CreatedAt: time.Now().Unix(),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record blob: %w", err)
+	}
+	return nil
+}
+
+// Check that every blob exists, so envelope mentioning them can be accepted
+func (s *Store) Check(blobIDs []string) error {
+	return s.bdb.View(func(tx *bbolt.Tx) error {
+		blobs := tx.Bucket(blobsBucket)
+		for _, blobID := range blobIDs {
+			if blobs.Get([]byte(blobID)) == nil {
+				return fmt.Errorf("%w: %s", ErrBlobNotFound, blobID)
+			}
+		}
+		return nil
+	})
+}
+
+// Reference blobs from envelope or chat message refID, blobs are kept until recipient downloads them,
+// empty recipient keeps them until they expire
+func (s *Store) Reference(refID, recipient string, blobIDs []string) error {
+	return s.bdb.Update(func(tx *bbolt.Tx) error {
+		blobs := tx.Bucket(blobsBucket)
+		for _, blobID := range blobIDs {
+			var record blobRecord
+			if ok, err := getJSON(blobs, blobID, &record); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("%w: %s", ErrBlobNotFound, blobID)
+			}
+
+			if record.Refs == nil {
+				record.Refs = make(map[string]string)
+			}
+			record.Refs[refID] = recipient
+			record.Referenced = true
+			record.DownloadedAt = 0
+
+			if err := putJSON(blobs, blobID, record); err != nil {
+				return err
+			}
+		}
+
+		return putJSON(tx.Bucket(refsBucket), refID, blobIDs)
+	})
+}
+
+// Release references of envelope or chat message refID, e.g. when it expires before recipient downloaded blobs
+func (s *Store) Release(refID string) error {
+	return s.bdb.Update(func(tx *bbolt.Tx) error {
+		refs := tx.Bucket(refsBucket)
+		var blobIDs []string
+		if ok, err := getJSON(refs, refID, &blobIDs); err != nil || !ok {
+			return err
+		}
+
+		now := time.Now().Unix()
+		blobs := tx.Bucket(blobsBucket)
+		for _, blobID := range blobIDs {
+			var record blobRecord
+			if ok, err := getJSON(blobs, blobID, &record); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+
+			if _, ok := record.Refs[refID]; !ok {
+				continue
+			}
+			delete(record.Refs, refID)
+			if len(record.Refs) == 0 {
+				record.DownloadedAt = now
+			}
+			if err := putJSON(blobs, blobID, record); err != nil {
+				return err
+			}
+		}
+
+		return refs.Delete([]byte(refID))
+	})
+}
+
+// Open blob for username, references of envelopes to username are dropped, the caller must close the blob
+func (s *Store) Open(blobID, username string) (io.ReadSeekCloser, error) {
+	err := s.bdb.Update(func(tx *bbolt.Tx) error {
+		blobs := tx.Bucket(blobsBucket)
+		var record blobRecord
+		if ok, err := getJSON(blobs, blobID, &record); err != nil {
+			return err
+		} else if !ok {
+			return ErrBlobNotFound
+		}
+
+		dropped := false
+		for refID, recipient := range record.Refs {
+			if recipient != username {
+				continue
+			}
+			delete(record.Refs, refID)
+			if err := unlinkRef(tx, refID, blobID); err != nil {
+				return err
+			}
+			dropped = true
+		}
+		if !dropped {
+			return nil
+		}
+
+		if len(record.Refs) == 0 {
+			record.DownloadedAt = time.Now().Unix()
+		}
+		return putJSON(blobs, blobID, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.Open(blobID)
+}
+
+// Usage of owner quota in bytes
+func (s *Store) Usage(owner string) (used int64, quota int64, err error) {
+	err = s.bdb.View(func(tx *bbolt.Tx) error {
+		used = getUsage(tx, owner)
+		return nil
+	})
+	return used, s.policy.Quota, err
+}
+
+// Collect deletes expired blobs and old uploads, returns number of deleted blobs and uploads.
+// Records are deleted in one transaction, files are deleted after it is committed.
+func (s *Store) Collect(now time.Time) (int, error) {
+	var expired, old []string
+	err := s.bdb.Update(func(tx *bbolt.Tx) error {
+		blobs := tx.Bucket(blobsBucket)
+		err := blobs.ForEach(func(k, v []byte) error {
+			var record blobRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if s.expired(record, now) {
+				expired = append(expired, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, blobID := range expired {
+			if err := deleteBlobRecord(tx, blobID); err != nil {
+				return err
+			}
+		}
+
+		uploads := tx.Bucket(uploadsBucket)
+		err = uploads.ForEach(func(k, v []byte) error {
+			var record uploadRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if s.policy.UnreferencedGrace > 0 && now.Sub(time.Unix(record.CreatedAt, 0)) > s.policy.UnreferencedGrace {
+				old = append(old, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, uploadID := range old {
+			if err := deleteUploadRecord(tx, uploadID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	for _, blobID := range expired {
+		if err := s.deleteBlobFile(blobID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, uploadID := range old {
+		if err := s.storage.DeleteUpload(uploadID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return len(expired) + len(old), errors.Join(errs...)
+}
+
+// deleteBlobFile unless the same content was uploaded and recorded again since its record was deleted
+func (s *Store) deleteBlobFile(blobID string) error {
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+
+	recorded := false
+	err := s.bdb.View(func(tx *bbolt.Tx) error {
+		recorded = tx.Bucket(blobsBucket).Get([]byte(blobID)) != nil
+		return nil
+	})
+	if err != nil || recorded {
+		return err
+	}
+
+	return s.storage.Delete(blobID)
+}
+
+func (s *Store) expired(record blobRecord, now time.Time) bool {
+	age := now.Sub(time.Unix(record.CreatedAt, 0))
+	switch {
+	case s.policy.MaxAge > 0 && age > s.policy.MaxAge:
+		return true
+	case !record.Referenced:
+		return s.policy.UnreferencedGrace > 0 && age > s.policy.UnreferencedGrace
+	case len(record.Refs) == 0:
+		return now.Sub(time.Unix(record.DownloadedAt, 0)) > s.policy.DownloadedGrace
+	default:
+		return false
+	}
+}
+
+// deleteBlobRecord with its references and owner usage, the file is deleted by the caller after commit
+func deleteBlobRecord(tx *bbolt.Tx, blobID string) error {
+	blobs := tx.Bucket(blobsBucket)
+	var record blobRecord
+	if ok, err := getJSON(blobs, blobID, &record); err != nil || !ok {
+		return err
+	}
+
+	for refID := range record.Refs {
+		if err := unlinkRef(tx, refID, blobID); err != nil {
+			return err
+		}
+	}
+
+	if err := blobs.Delete([]byte(blobID)); err != nil {
+		return err
+	}
+
+	return putUsage(tx, record.Owner, getUsage(tx, record.Owner)-record.Size)
+}
+
+// deleteUploadRecord and its reservation, the files are deleted by the caller after commit
+func deleteUploadRecord(tx *bbolt.Tx, uploadID string) error {
+	uploads := tx.Bucket(uploadsBucket)
+	var record uploadRecord
+	if ok, err := getJSON(uploads, uploadID, &record); err != nil || !ok {
+		return err
+	}
+
+	if err := uploads.Delete([]byte(uploadID)); err != nil {
+		return err
+	}
+
+	if record.BlobID != "" {
+		return nil
+	}
+	return putUsage(tx, record.Owner, getUsage(tx, record.Owner)-record.Size)
+}
+
+// Close stops the background collector, bbolt database is owned by the caller
+func (s *Store) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *Store) collectLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.policy.CollectInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			deleted, err := s.Collect(now)
+			if err != nil {
+				log.Println("failed to collect blobs:", err)
+			} else if deleted > 0 {
+				log.Printf("* collected %d blobs and uploads", deleted)
+			}
+		}
+	}
+}
+
+// unlinkRef removes blob from blobs referenced by refID
+func unlinkRef(tx *bbolt.Tx, refID, blobID string) error {
+	refs := tx.Bucket(refsBucket)
+	var blobIDs []string
+	if ok, err := getJSON(refs, refID, &blobIDs); err != nil || !ok {
+		return err
+	}
+
+	blobIDs = slices.DeleteFunc(blobIDs, func(id string) bool { return id == blobID })
+	if len(blobIDs) == 0 {
+		return refs.Delete([]byte(refID))
+	}
+	return putJSON(refs, refID, blobIDs)
+}
+
+func getUsage(tx *bbolt.Tx, owner string) int64 {
+	v := tx.Bucket(usageBucket).Get([]byte(owner))
+	if len(v) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+func putUsage(tx *bbolt.Tx, owner string, used int64) error {
+	if used <= 0 {
+		return tx.Bucket(usageBucket).Delete([]byte(owner))
+	}
+	return tx.Bucket(usageBucket).Put([]byte(owner), binary.BigEndian.AppendUint64(nil, uint64(used)))
+}
+
+func getJSON(bucket *bbolt.Bucket, key string, v interface{}) (bool, error) {
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func putJSON(bucket *bbolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
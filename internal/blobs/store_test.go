@@ -0,0 +1,156 @@
+package blobs
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, policy Policy) *Store {
+	dir := t.TempDir()
+
+	bdb, err := bbolt.Open(filepath.Join(dir, "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { _ = bdb.Close() })
+
+	storage, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatalf("Error creating storage: %v", err)
+	}
+
+	s, err := NewStore(bdb, storage, policy)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func upload(t *testing.T, s *Store, owner, content string) string {
+	t.Helper()
+
+	u, err := s.CreateUpload(owner, int64(len(content)))
+	if err != nil {
+		t.Fatalf("Error creating upload: %v", err)
+	}
+
+	u, err = s.WriteChunk(u.ID, 0, []byte(content))
+	if err != nil {
+		t.Fatalf("Error writing chunk: %v", err)
+	}
+
+	return u.BlobID
+}
+
+func TestStoreQuota(t *testing.T) {
+	s := newTestStore(t, Policy{Quota: 10})
+
+	upload(t, s, "alice", "123456")
+	if _, err := s.CreateUpload("alice", 5); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	if _, err := s.CreateUpload("bob", 5); err != nil {
+		t.Errorf("Quota of bob should not be affected by alice, got %v", err)
+	}
+
+	if used, quota, _ := s.Usage("alice"); used != 6 || quota != 10 {
+		t.Errorf("Expected 6 of 10 bytes used, got %d of %d", used, quota)
+	}
+}
+
+func TestStoreCollectsDownloaded(t *testing.T) {
+	s := newTestStore(t, Policy{MaxAge: time.Hour, UnreferencedGrace: time.Minute})
+	now := time.Now()
+
+	blobID := upload(t, s, "alice", "photo")
+	unreferenced := upload(t, s, "alice", "draft")
+
+	if err := s.Reference("envelope-1", "bob", []string{blobID}); err != nil {
+		t.Fatalf("Error referencing blob: %v", err)
+	}
+	if err := s.Reference("envelope-2", "carol", []string{blobID}); err != nil {
+		t.Fatalf("Error referencing blob: %v", err)
+	}
+
+	// owner download keeps references
+	for _, username := range []string{"alice", "bob"} {
+		blob, err := s.Open(blobID, username)
+		if err != nil {
+			t.Fatalf("Error opening blob for %s: %v", username, err)
+		}
+		blob.Close()
+	}
+
+	// unreferenced blob and both completed uploads
+	if n, _ := s.Collect(now.Add(2 * time.Minute)); n != 3 {
+		t.Errorf("Expected unreferenced blob and completed uploads to be collected, got %d", n)
+	}
+	if _, err := s.Open(unreferenced, "alice"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected unreferenced blob to be collected, got %v", err)
+	}
+
+	if err := s.Release("envelope-2"); err != nil {
+		t.Fatalf("Error releasing envelope: %v", err)
+	}
+
+	if n, _ := s.Collect(now.Add(2 * time.Minute)); n != 1 {
+		t.Errorf("Expected blob without references to be collected, got %d", n)
+	}
+	if _, err := s.Open(blobID, "bob"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected blob to be collected, got %v", err)
+	}
+
+	if used, _, _ := s.Usage("alice"); used != 0 {
+		t.Errorf("Expected no usage after collection, got %d", used)
+	}
+}
+
+func TestStoreCollectsExpired(t *testing.T) {
+	s := newTestStore(t, Policy{MaxAge: time.Hour})
+
+	blobID := upload(t, s, "alice", "photo")
+	if err := s.Reference("envelope-1", "bob", []string{blobID}); err != nil {
+		t.Fatalf("Error referencing blob: %v", err)
+	}
+
+	if n, _ := s.Collect(time.Now()); n != 0 {
+		t.Errorf("Expected nothing to be collected, got %d", n)
+	}
+	if n, _ := s.Collect(time.Now().Add(2 * time.Hour)); n != 1 {
+		t.Errorf("Expected expired blob to be collected, got %d", n)
+	}
+}
+
+func TestStoreRecordsCompletedUploadOnRetry(t *testing.T) {
+	s := newTestStore(t, Policy{})
+
+	u, err := s.CreateUpload("alice", 5)
+	if err != nil {
+		t.Fatalf("Error creating upload: %v", err)
+	}
+
+	// storage completes the upload, but the blob is not recorded, as if the record transaction failed
+	completed, err := s.storage.WriteChunk(u.ID, 0, []byte("hello"))
+	if err != nil {
+		t.Fatalf("Error writing chunk to storage: %v", err)
+	}
+	if err := s.Check([]string{completed.BlobID}); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Expected blob not to be recorded, got %v", err)
+	}
+
+	retried, err := s.WriteChunk(u.ID, 0, []byte("hello"))
+	if err != nil || retried.BlobID != completed.BlobID {
+		t.Fatalf("Expected retry of the last chunk to record blob, got %+v %v", retried, err)
+	}
+	if err := s.Check([]string{completed.BlobID}); err != nil {
+		t.Errorf("Expected blob to be recorded after retry, got %v", err)
+	}
+	if _, err := s.WriteChunk(u.ID, 0, []byte("hel")); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("Expected other chunks of completed upload to mismatch, got %v", err)
+	}
+}
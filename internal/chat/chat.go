@@ -1,70 +1,232 @@
 package chat
 
 import (
+	"errors"
 	"fmt"
-	"github.com/google/uuid"
 	"go.etcd.io/bbolt"
+	"sync"
 )
 
+var (
+	ErrNotMember       = errors.New("not a chat member")
+	ErrAlreadyMember   = errors.New("already a chat member")
+	ErrForbidden       = errors.New("not allowed by chat settings")
+	ErrArchived        = errors.New("chat is archived")
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// IsAdmin is true for owner as well
+func (r Role) IsAdmin() bool {
+	return r == RoleOwner || r == RoleAdmin
+}
+
+// Chat is an opened chat from the Store, database handle is shared between all users of the same chat
 type Chat struct {
-	bdb    *bbolt.DB
+	store  *Store
+	h      *handle
 	chatID string
+
+	closeOnce sync.Once
 }
 
-func NewChat(chatID string) (*Chat, error) {
-	if err := uuid.Validate(chatID); err != nil {
-		return nil, fmt.Errorf("invalid chat ID: %w", err)
-	}
+// Close releases the handle back to the Store, the database itself is closed by the Store
+func (c *Chat) Close() error {
+	c.closeOnce.Do(func() {
+		c.store.release(c.h)
+	})
+	return nil
+}
 
-	bdb, err := bbolt.Open(fmt.Sprintf(".data/chat-%s.db", chatID), 0600, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open chat database: %w", err)
-	}
+func (c *Chat) GetChatID() string {
+	return c.chatID
+}
 
-	chat := &Chat{
-		bdb:    bdb,
-		chatID: chatID,
-	}
+func (c *Chat) GetName() (string, error) {
+	name, err := c.getMetadata("name")
+	return string(name), err
+}
+
+func (c *Chat) IsArchived() (bool, error) {
+	archived, err := c.getMetadata("archived")
+	return string(archived) == "true", err
+}
+
+// SetArchived switch chat into read-only mode and back
+func (c *Chat) SetArchived(archived bool) error {
+	return c.db().Update(func(tx *bbolt.Tx) error {
+		metadata, err := tx.CreateBucketIfNotExists([]byte("metadata"))
+		if err != nil {
+			return err
+		}
 
-	return chat, nil
+		if archived {
+			return metadata.Put([]byte("archived"), []byte("true"))
+		}
+		return metadata.Delete([]byte("archived"))
+	})
 }
 
-func CreateChat(creatorUsername, name, publicKey string) (*Chat, error) {
-	chatID := uuid.New().String()
-	chat, err := NewChat(chatID)
+// AddMember to the chat or update role of existing one
+func (c *Chat) AddMember(username string, role Role) error {
+	err := c.db().Update(func(tx *bbolt.Tx) error {
+		members, err := tx.CreateBucketIfNotExists([]byte("members"))
+		if err != nil {
+			return err
+		}
+
+		return members.Put([]byte(username), []byte(role))
+	})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to add member: %w", err)
 	}
-	err = chat.bdb.Update(func(tx *bbolt.Tx) error {
-		metadata, err := tx.CreateBucketIfNotExists([]byte("metadata"))
+
+	return c.store.indexMember(username, c.chatID)
+}
+
+// Invite adds username as a member if invite policy allows it to inviter
+func (c *Chat) Invite(inviter, username string) error {
+	err := c.db().Update(func(tx *bbolt.Tx) error {
+		if _, err := writableMetadata(tx); err != nil {
+			return err
+		}
+
+		settings, err := readSettings(tx)
 		if err != nil {
 			return err
 		}
 
-		if metadata.Put([]byte("name"), []byte(name)) != nil {
-			return fmt.Errorf("failed to put name into metadata")
+		inviterRole := memberRole(tx, inviter)
+		if inviterRole == "" {
+			return ErrNotMember
+		}
+		if !settings.InvitePolicy.Allows(inviterRole) {
+			return ErrForbidden
 		}
 
-		if metadata.Put([]byte("creator"), []byte(creatorUsername)) != nil {
-			return fmt.Errorf("failed to put creator into metadata")
+		if memberRole(tx, username) != "" {
+			return ErrAlreadyMember
 		}
 
-		if metadata.Put([]byte("publicKey"), []byte(publicKey)) != nil {
-			return fmt.Errorf("failed to put publicKey into metadata")
+		members, err := tx.CreateBucketIfNotExists([]byte("members"))
+		if err != nil {
+			return err
 		}
 
-		return nil
+		return members.Put([]byte(username), []byte(RoleMember))
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return chat, nil
+
+	return c.store.indexMember(username, c.chatID)
 }
 
-func (c *Chat) Close() error {
-	return c.bdb.Close()
+// RemoveMember from the chat, owner can not be removed
+func (c *Chat) RemoveMember(username string) error {
+	err := c.db().Update(func(tx *bbolt.Tx) error {
+		if _, err := writableMetadata(tx); err != nil {
+			return err
+		}
+
+		role := memberRole(tx, username)
+		if role == "" {
+			return ErrNotMember
+		}
+		if role == RoleOwner {
+			return ErrForbidden
+		}
+
+		return tx.Bucket([]byte("members")).Delete([]byte(username))
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.store.unindexMember(username, c.chatID)
 }
 
-func (c *Chat) GetChatID() string {
-	return c.chatID
+// SetMemberRole switch member between admin and member, owner role can not be given or taken
+func (c *Chat) SetMemberRole(username string, role Role) error {
+	if role != RoleAdmin && role != RoleMember {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	return c.db().Update(func(tx *bbolt.Tx) error {
+		if _, err := writableMetadata(tx); err != nil {
+			return err
+		}
+
+		current := memberRole(tx, username)
+		if current == "" {
+			return ErrNotMember
+		}
+		if current == RoleOwner {
+			return ErrForbidden
+		}
+
+		return tx.Bucket([]byte("members")).Put([]byte(username), []byte(role))
+	})
+}
+
+// GetMemberRole returns empty Role if user is not a member
+func (c *Chat) GetMemberRole(username string) (Role, error) {
+	var role Role
+	err := c.db().View(func(tx *bbolt.Tx) error {
+		role = memberRole(tx, username)
+		return nil
+	})
+	return role, err
+}
+
+// Members returns username to Role map
+func (c *Chat) Members() (map[string]Role, error) {
+	result := make(map[string]Role)
+	err := c.db().View(func(tx *bbolt.Tx) error {
+		members := tx.Bucket([]byte("members"))
+		if members == nil {
+			return nil
+		}
+
+		return members.ForEach(func(k, v []byte) error {
+			result[string(k)] = Role(v)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func memberRole(tx *bbolt.Tx, username string) Role {
+	members := tx.Bucket([]byte("members"))
+	if members == nil {
+		return ""
+	}
+
+	return Role(members.Get([]byte(username)))
+}
+
+func (c *Chat) getMetadata(key string) ([]byte, error) {
+	var value []byte
+	err := c.db().View(func(tx *bbolt.Tx) error {
+		metadata := tx.Bucket([]byte("metadata"))
+		if metadata == nil {
+			return nil
+		}
+
+		// value is only valid inside transaction
+		value = append([]byte(nil), metadata.Get([]byte(key))...)
+		return nil
+	})
+	return value, err
+}
+
+func (c *Chat) db() *bbolt.DB {
+	return c.h.bdb
 }
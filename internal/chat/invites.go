@@ -0,0 +1,210 @@
+package chat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/google/uuid"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInviteNotFound  = errors.New("invite not found")
+	ErrInviteRevoked   = errors.New("invite revoked")
+	ErrInviteExpired   = errors.New("invite expired")
+	ErrInviteExhausted = errors.New("invite has no uses left")
+)
+
+// Invite is stored in the chat database, so it can be listed and revoked, token itself is issued by the webserver
+type Invite struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	ExpiresAt int64  `json:"expires_at"`
+	MaxUses   int    `json:"max_uses"` // 0 is unlimited
+	Uses      int    `json:"uses"`
+	Revoked   bool   `json:"revoked"`
+}
+
+func (c *Chat) CreateInvite(createdBy string, expiresAt time.Time, maxUses int) (Invite, error) {
+	if maxUses < 0 {
+		return Invite{}, fmt.Errorf("invalid max uses")
+	}
+
+	invite := Invite{
+		ID:        uuid.New().String(),
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt.Unix(),
+		MaxUses:   maxUses,
+	}
+
+	err := c.db().Update(func(tx *bbolt.Tx) error {
+		if _, err := writableMetadata(tx); err != nil {
+			return err
+		}
+
+		invites, err := tx.CreateBucketIfNotExists([]byte("invites"))
+		if err != nil {
+			return err
+		}
+
+		return putInvite(invites, invite)
+	})
+	if err != nil {
+		return Invite{}, err
+	}
+
+	return invite, nil
+}
+
+func (c *Chat) RevokeInvite(inviteID string) error {
+	return c.db().Update(func(tx *bbolt.Tx) error {
+		invites := tx.Bucket([]byte("invites"))
+		if invites == nil {
+			return ErrInviteNotFound
+		}
+
+		invite, err := getInvite(invites, inviteID)
+		if err != nil {
+			return err
+		}
+
+		invite.Revoked = true
+		return putInvite(invites, invite)
+	})
+}
+
+func (c *Chat) Invites() ([]Invite, error) {
+	result := make([]Invite, 0)
+	err := c.db().View(func(tx *bbolt.Tx) error {
+		invites := tx.Bucket([]byte("invites"))
+		if invites == nil {
+			return nil
+		}
+
+		return invites.ForEach(func(k, v []byte) error {
+			var invite Invite
+			if err := json.Unmarshal(v, &invite); err != nil {
+				return fmt.Errorf("failed to decode invite %s: %w", k, err)
+			}
+			result = append(result, invite)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// RedeemInvite adds username as a member and counts the use, invite policy is not checked, admin issued the invite
+func (c *Chat) RedeemInvite(inviteID, username string, now time.Time) error {
+	err := c.db().Update(func(tx *bbolt.Tx) error {
+		if _, err := writableMetadata(tx); err != nil {
+			return err
+		}
+
+		invites := tx.Bucket([]byte("invites"))
+		if invites == nil {
+			return ErrInviteNotFound
+		}
+
+		invite, err := getInvite(invites, inviteID)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case invite.Revoked:
+			return ErrInviteRevoked
+		case now.Unix() >= invite.ExpiresAt:
+			return ErrInviteExpired
+		case invite.MaxUses > 0 && invite.Uses >= invite.MaxUses:
+			return ErrInviteExhausted
+		}
+
+		if memberRole(tx, username) != "" {
+			return ErrAlreadyMember
+		}
+
+		members, err := tx.CreateBucketIfNotExists([]byte("members"))
+		if err != nil {
+			return err
+		}
+		if err := members.Put([]byte(username), []byte(RoleMember)); err != nil {
+			return err
+		}
+
+		invite.Uses++
+		return putInvite(invites, invite)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.store.indexMember(username, c.chatID)
+}
+
+// SignInviteToken returns "base64(json).signature", signature is made with server key so clients can verify it too
+func SignInviteToken(token protocol.ChatInviteToken, privateKey *crypto.Key) (string, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := protocol.Sign(data, privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign invite token: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(data) + "." + signature, nil
+}
+
+// VerifyInviteToken signed by publicKeyArmor, invite itself is checked by RedeemInvite
+func VerifyInviteToken(encoded, publicKeyArmor string) (protocol.ChatInviteToken, error) {
+	var token protocol.ChatInviteToken
+
+	payload, signature, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return token, fmt.Errorf("malformed invite token")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return token, fmt.Errorf("malformed invite token: %w", err)
+	}
+
+	if err := protocol.VerifySignArmor(data, signature, publicKeyArmor); err != nil {
+		return token, fmt.Errorf("invalid invite token signature")
+	}
+
+	if err := json.Unmarshal(data, &token); err != nil {
+		return token, fmt.Errorf("malformed invite token: %w", err)
+	}
+
+	return token, nil
+}
+
+func getInvite(invites *bbolt.Bucket, inviteID string) (Invite, error) {
+	var invite Invite
+	data := invites.Get([]byte(inviteID))
+	if data == nil {
+		return invite, ErrInviteNotFound
+	}
+
+	if err := json.Unmarshal(data, &invite); err != nil {
+		return invite, fmt.Errorf("failed to decode invite: %w", err)
+	}
+
+	return invite, nil
+}
+
+func putInvite(invites *bbolt.Bucket, invite Invite) error {
+	data, err := json.Marshal(invite)
+	if err != nil {
+		return err
+	}
+
+	return invites.Put([]byte(invite.ID), data)
+}
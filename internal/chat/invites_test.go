@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/soul-ua/server/pkg/protocol"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestInviteKey(t *testing.T) (*crypto.Key, string) {
+	privateKey, publicKey, err := protocol.GeneratePair("server", "server@test")
+	if err != nil {
+		t.Fatalf("Error generating keys: %v", err)
+	}
+	key, err := crypto.NewKeyFromArmored(privateKey)
+	if err != nil {
+		t.Fatalf("Error parsing key: %v", err)
+	}
+	return key, publicKey
+}
+
+func TestInviteToken(t *testing.T) {
+	key, publicKey := newTestInviteKey(t)
+	_, otherPublicKey := newTestInviteKey(t)
+
+	token := protocol.ChatInviteToken{ChatID: "chat", InviteID: "invite", ExpiresAt: 100}
+	encoded, err := SignInviteToken(token, key)
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+
+	if verified, err := VerifyInviteToken(encoded, publicKey); err != nil || verified != token {
+		t.Errorf("Expected %v, got %v %v", token, verified, err)
+	}
+
+	if _, err := VerifyInviteToken(encoded, otherPublicKey); err == nil {
+		t.Errorf("Expected token signed by another key to be rejected")
+	}
+
+	// token of another invite with signature copied from a valid one
+	_, signature, _ := strings.Cut(encoded, ".")
+	forged, _ := json.Marshal(protocol.ChatInviteToken{ChatID: "chat", InviteID: "other", ExpiresAt: 100})
+	if _, err := VerifyInviteToken(base64.StdEncoding.EncodeToString(forged)+"."+signature, publicKey); err == nil {
+		t.Errorf("Expected token with forged payload to be rejected")
+	}
+
+	for _, malformed := range []string{"", "no signature", "!!!." + signature} {
+		if _, err := VerifyInviteToken(malformed, publicKey); err == nil {
+			t.Errorf("Expected malformed token %q to be rejected", malformed)
+		}
+	}
+}
+
+func TestRedeemInvite(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	defer c.Close()
+
+	now := time.Now()
+
+	if err := c.RedeemInvite("unknown", "bob", now); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("Expected ErrInviteNotFound, got %v", err)
+	}
+
+	invite, err := c.CreateInvite("alice", now.Add(time.Hour), 2)
+	if err != nil {
+		t.Fatalf("Error creating invite: %v", err)
+	}
+
+	if err := c.RedeemInvite(invite.ID, "bob", now.Add(time.Hour)); !errors.Is(err, ErrInviteExpired) {
+		t.Errorf("Expected ErrInviteExpired, got %v", err)
+	}
+
+	if err := c.RedeemInvite(invite.ID, "bob", now); err != nil {
+		t.Fatalf("Error redeeming invite: %v", err)
+	}
+	if role, _ := c.GetMemberRole("bob"); role != RoleMember {
+		t.Errorf("Expected bob to join as member, got %q", role)
+	}
+	if chats, _ := s.UserChats("bob"); len(chats) != 1 || chats[0] != c.GetChatID() {
+		t.Errorf("Expected chat to be indexed for bob, got %v", chats)
+	}
+
+	if err := c.RedeemInvite(invite.ID, "bob", now); !errors.Is(err, ErrAlreadyMember) {
+		t.Errorf("Expected ErrAlreadyMember, got %v", err)
+	}
+
+	if err := c.RedeemInvite(invite.ID, "carol", now); err != nil {
+		t.Fatalf("Error redeeming invite: %v", err)
+	}
+	if err := c.RedeemInvite(invite.ID, "dave", now); !errors.Is(err, ErrInviteExhausted) {
+		t.Errorf("Expected ErrInviteExhausted, got %v", err)
+	}
+
+	unlimited, err := c.CreateInvite("alice", now.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatalf("Error creating invite: %v", err)
+	}
+	if err := c.RedeemInvite(unlimited.ID, "dave", now); err != nil {
+		t.Fatalf("Error redeeming unlimited invite: %v", err)
+	}
+
+	if err := c.RevokeInvite(unlimited.ID); err != nil {
+		t.Fatalf("Error revoking invite: %v", err)
+	}
+	if err := c.RedeemInvite(unlimited.ID, "erin", now); !errors.Is(err, ErrInviteRevoked) {
+		t.Errorf("Expected ErrInviteRevoked, got %v", err)
+	}
+	if role, _ := c.GetMemberRole("erin"); role != "" {
+		t.Errorf("Expected erin not to join with revoked invite, got %q", role)
+	}
+	if err := c.RevokeInvite("unknown"); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("Expected ErrInviteNotFound, got %v", err)
+	}
+
+	invites, err := c.Invites()
+	if err != nil {
+		t.Fatalf("Error listing invites: %v", err)
+	}
+	uses := make(map[string]Invite)
+	for _, i := range invites {
+		uses[i.ID] = i
+	}
+	if uses[invite.ID].Uses != 2 || uses[unlimited.ID].Uses != 1 || !uses[unlimited.ID].Revoked {
+		t.Errorf("Unexpected invites %v", invites)
+	}
+}
@@ -0,0 +1,218 @@
+package chat
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// SlowModeError is returned when member posts more often than slow mode allows
+type SlowModeError struct {
+	Wait time.Duration
+}
+
+func (e *SlowModeError) Error() string {
+	return fmt.Sprintf("slow mode, next message allowed in %s", e.Wait)
+}
+
+// PostMessage checks membership, post policy and slow mode and stores the message, returns message ID
+func (c *Chat) PostMessage(username, payloadType string, payload []byte, now time.Time) (string, error) {
+	return c.PostExpiringMessage(username, payloadType, payload, now, 0)
+}
+
+// PostExpiringMessage is PostMessage which is purged from the chat after ttl, zero ttl never expires
+func (c *Chat) PostExpiringMessage(username, payloadType string, payload []byte, now time.Time, ttl time.Duration) (string, error) {
+	messageID, err := uuid.NewV7() // should be v7 for binary sort
+	if err != nil {
+		return "", err
+	}
+
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = now.Add(ttl).Unix()
+	}
+
+	err = c.db().Update(func(tx *bbolt.Tx) error {
+		if _, err := writableMetadata(tx); err != nil {
+			return err
+		}
+
+		role := memberRole(tx, username)
+		if role == "" {
+			return ErrNotMember
+		}
+
+		settings, err := readSettings(tx)
+		if err != nil {
+			return err
+		}
+
+		if !settings.PostPolicy.Allows(role) {
+			return ErrForbidden
+		}
+
+		lastPost, err := tx.CreateBucketIfNotExists([]byte("last-post"))
+		if err != nil {
+			return err
+		}
+
+		if settings.SlowMode > 0 && !role.IsAdmin() {
+			if v := lastPost.Get([]byte(username)); v != nil {
+				last, _ := strconv.ParseInt(string(v), 10, 64)
+				wait := time.Unix(last, 0).Add(settings.SlowMode).Sub(now)
+				if wait > 0 {
+					return &SlowModeError{Wait: wait}
+				}
+			}
+		}
+
+		if err := lastPost.Put([]byte(username), []byte(strconv.FormatInt(now.Unix(), 10))); err != nil {
+			return err
+		}
+
+		msg := protocol.ChatMessage{
+			ID:          messageID.String(),
+			From:        username,
+			Time:        now.Unix(),
+			PayloadType: payloadType,
+			Payload:     payload,
+		}
+		if expiresAt != 0 {
+			msg.ExpiresAt = expiresAt
+
+			expiry, err := tx.CreateBucketIfNotExists([]byte("expiry"))
+			if err != nil {
+				return err
+			}
+			if err := expiry.Put(expiryKey(msg.ExpiresAt, msg.ID), nil); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+
+		messages, err := tx.CreateBucketIfNotExists([]byte("messages"))
+		if err != nil {
+			return err
+		}
+
+		return messages.Put([]byte(messageID.String()), data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if expiresAt != 0 {
+		if err := c.store.scheduleExpiry(c.chatID, expiresAt); err != nil {
+			return "", err
+		}
+	}
+
+	return messageID.String(), nil
+}
+
+// ReadMessages after sinceID (exclusive), empty sinceID reads from the beginning
+func (c *Chat) ReadMessages(sinceID string, limit int) ([]protocol.ChatMessage, error) {
+	result := make([]protocol.ChatMessage, 0)
+	err := c.db().View(func(tx *bbolt.Tx) error {
+		messages := tx.Bucket([]byte("messages"))
+		if messages == nil {
+			return nil
+		}
+
+		cur := messages.Cursor()
+		k, v := cur.Seek([]byte(sinceID))
+		if k != nil && string(k) == sinceID {
+			k, v = cur.Next()
+		}
+
+		now := time.Now().Unix()
+		for ; k != nil && len(result) < limit; k, v = cur.Next() {
+			var msg protocol.ChatMessage
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return fmt.Errorf("failed to decode message %s: %w", k, err)
+			}
+			if msg.ExpiresAt != 0 && msg.ExpiresAt <= now {
+				// not purged yet
+				continue
+			}
+			result = append(result, msg)
+		}
+
+		return nil
+	})
+	return result, err
+}
+
+// Purge messages expired at now and unpin them, returns their IDs
+func (c *Chat) Purge(now time.Time) ([]string, error) {
+	var purged []string
+	err := c.db().Update(func(tx *bbolt.Tx) error {
+		expiry := tx.Bucket([]byte("expiry"))
+		messages := tx.Bucket([]byte("messages"))
+		if expiry == nil || messages == nil {
+			return nil
+		}
+
+		var keys [][]byte
+		cur := expiry.Cursor()
+		for k, _ := cur.First(); k != nil && int64(binary.BigEndian.Uint64(k[:8])) <= now.Unix(); k, _ = cur.Next() {
+			keys = append(keys, k)
+		}
+
+		for _, k := range keys {
+			messageID := k[8:]
+			if err := messages.Delete(messageID); err != nil {
+				return err
+			}
+			if err := expiry.Delete(k); err != nil {
+				return err
+			}
+			purged = append(purged, string(messageID))
+		}
+
+		// archived chats are purged as well, so metadata is not checked to be writable
+		metadata := tx.Bucket([]byte("metadata"))
+		if metadata == nil || len(purged) == 0 {
+			return nil
+		}
+		settings, err := readSettings(tx)
+		if err != nil {
+			return err
+		}
+		isPurged := func(id string) bool { return slices.Contains(purged, id) }
+		if !slices.ContainsFunc(settings.Pinned, isPurged) {
+			return nil
+		}
+		return putPinned(metadata, slices.DeleteFunc(settings.Pinned, isPurged))
+	})
+	return purged, err
+}
+
+// nextExpiry returns when the first expiring message of the chat expires, zero if none expires
+func (c *Chat) nextExpiry() (int64, error) {
+	var next int64
+	err := c.db().View(func(tx *bbolt.Tx) error {
+		if expiry := tx.Bucket([]byte("expiry")); expiry != nil {
+			if k, _ := expiry.Cursor().First(); k != nil {
+				next = int64(binary.BigEndian.Uint64(k[:8]))
+			}
+		}
+		return nil
+	})
+	return next, err
+}
+
+// expiryKey sorts by expiration time, so purge stops at the first message which is not expired
+func expiryKey(expiresAt int64, messageID string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(expiresAt)), messageID...)
+}
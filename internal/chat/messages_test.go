@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPostMessageEnforcesSettings(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.AddMember("bob", RoleMember); err != nil {
+		t.Fatalf("Error adding member: %v", err)
+	}
+
+	now := time.Now()
+
+	if _, err := c.PostMessage("carol", "Text", nil, now); !errors.Is(err, ErrNotMember) {
+		t.Errorf("Expected ErrNotMember, got %v", err)
+	}
+
+	if err := c.UpdateSettings(PolicyEveryone, PolicyAdmins, time.Minute); err != nil {
+		t.Fatalf("Error updating settings: %v", err)
+	}
+
+	if _, err := c.PostMessage("bob", "Text", nil, now); err != nil {
+		t.Errorf("Error posting message: %v", err)
+	}
+
+	var slowMode *SlowModeError
+	if _, err := c.PostMessage("bob", "Text", nil, now.Add(time.Second)); !errors.As(err, &slowMode) {
+		t.Errorf("Expected SlowModeError, got %v", err)
+	}
+
+	if _, err := c.PostMessage("alice", "Text", nil, now.Add(time.Second)); err != nil {
+		t.Errorf("Admins should not be limited by slow mode: %v", err)
+	}
+
+	if err := c.UpdateSettings(PolicyAdmins, PolicyAdmins, 0); err != nil {
+		t.Fatalf("Error updating settings: %v", err)
+	}
+
+	if _, err := c.PostMessage("bob", "Text", nil, now.Add(time.Hour)); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+
+	if err := c.SetArchived(true); err != nil {
+		t.Fatalf("Error archiving chat: %v", err)
+	}
+
+	if _, err := c.PostMessage("alice", "Text", nil, now.Add(time.Hour)); !errors.Is(err, ErrArchived) {
+		t.Errorf("Expected ErrArchived, got %v", err)
+	}
+	if err := c.RemoveMember("bob"); !errors.Is(err, ErrArchived) {
+		t.Errorf("Expected ErrArchived when removing member of archived chat, got %v", err)
+	}
+
+	messages, err := c.ReadMessages("", 100)
+	if err != nil {
+		t.Fatalf("Error reading messages: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Errorf("Expected 2 messages, got %d", len(messages))
+	}
+}
+
+func TestPurgeExpiredMessages(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	defer c.Close()
+
+	now := time.Now()
+
+	kept, err := c.PostMessage("alice", "Text", nil, now)
+	if err != nil {
+		t.Fatalf("Error posting message: %v", err)
+	}
+	expiring, err := c.PostExpiringMessage("alice", "Text", nil, now, time.Minute)
+	if err != nil {
+		t.Fatalf("Error posting message: %v", err)
+	}
+	for _, messageID := range []string{kept, expiring} {
+		if err := c.Pin(messageID); err != nil {
+			t.Fatalf("Error pinning message: %v", err)
+		}
+	}
+
+	if purged, _ := s.Purge(now); len(purged) != 0 {
+		t.Errorf("Expected nothing purged before expiry, got %v", purged)
+	}
+	if due, indexed, _ := s.dueChats(now); !indexed || len(due) != 0 {
+		t.Errorf("Expected indexed chats and none due before expiry, got %v %v", indexed, due)
+	}
+	if due, _, _ := s.dueChats(now.Add(2 * time.Minute)); len(due) != 1 || due[0] != c.GetChatID() {
+		t.Errorf("Expected chat to be due after expiry, got %v", due)
+	}
+
+	purged, err := s.Purge(now.Add(2 * time.Minute))
+	if err != nil {
+		t.Fatalf("Error purging: %v", err)
+	}
+	if len(purged) != 1 || purged[0] != expiring {
+		t.Errorf("Expected %s purged, got %v", expiring, purged)
+	}
+	if due, _, _ := s.dueChats(now.Add(time.Hour)); len(due) != 0 {
+		t.Errorf("Expected chat without expiring messages to leave the index, got %v", due)
+	}
+
+	messages, _ := c.ReadMessages("", 100)
+	if len(messages) != 1 || messages[0].ID != kept {
+		t.Errorf("Expected only %s left, got %+v", kept, messages)
+	}
+	if settings, _ := c.GetSettings(); len(settings.Pinned) != 1 || settings.Pinned[0] != kept {
+		t.Errorf("Expected purged message to be unpinned, got %v", settings.Pinned)
+	}
+}
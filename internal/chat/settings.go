@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Policy is who is allowed to do an action in the chat
+type Policy string
+
+const (
+	PolicyEveryone Policy = "everyone"
+	PolicyAdmins   Policy = "admins"
+)
+
+// Settings are stored in the chat metadata bucket
+type Settings struct {
+	PostPolicy   Policy
+	InvitePolicy Policy
+	SlowMode     time.Duration // minimal interval between posts of the same member, admins are not limited
+	Pinned       []string      // message IDs
+}
+
+var defaultSettings = Settings{
+	PostPolicy:   PolicyEveryone,
+	InvitePolicy: PolicyAdmins,
+}
+
+func (p Policy) Valid() bool {
+	return p == PolicyEveryone || p == PolicyAdmins
+}
+
+// Allows checks if member with role passes the policy
+func (p Policy) Allows(role Role) bool {
+	if role == "" {
+		return false
+	}
+	if p == PolicyAdmins {
+		return role.IsAdmin()
+	}
+	return true
+}
+
+func (c *Chat) GetSettings() (Settings, error) {
+	var settings Settings
+	err := c.db().View(func(tx *bbolt.Tx) error {
+		var err error
+		settings, err = readSettings(tx)
+		return err
+	})
+	return settings, err
+}
+
+// UpdateSettings replaces post, invite policies and slow mode, pinned messages are managed by Pin and Unpin
+func (c *Chat) UpdateSettings(postPolicy, invitePolicy Policy, slowMode time.Duration) error {
+	if !postPolicy.Valid() || !invitePolicy.Valid() {
+		return fmt.Errorf("invalid policy")
+	}
+	if slowMode < 0 {
+		return fmt.Errorf("invalid slow mode")
+	}
+
+	return c.db().Update(func(tx *bbolt.Tx) error {
+		metadata, err := writableMetadata(tx)
+		if err != nil {
+			return err
+		}
+
+		if err := metadata.Put([]byte("post_policy"), []byte(postPolicy)); err != nil {
+			return err
+		}
+		if err := metadata.Put([]byte("invite_policy"), []byte(invitePolicy)); err != nil {
+			return err
+		}
+		return metadata.Put([]byte("slow_mode"), []byte(strconv.FormatInt(int64(slowMode/time.Second), 10)))
+	})
+}
+
+func (c *Chat) Pin(messageID string) error {
+	return c.db().Update(func(tx *bbolt.Tx) error {
+		metadata, err := writableMetadata(tx)
+		if err != nil {
+			return err
+		}
+
+		messages := tx.Bucket([]byte("messages"))
+		if messages == nil || messages.Get([]byte(messageID)) == nil {
+			return ErrMessageNotFound
+		}
+
+		settings, err := readSettings(tx)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(settings.Pinned, messageID) {
+			return nil
+		}
+
+		return putPinned(metadata, append(settings.Pinned, messageID))
+	})
+}
+
+func (c *Chat) Unpin(messageID string) error {
+	return c.db().Update(func(tx *bbolt.Tx) error {
+		metadata, err := writableMetadata(tx)
+		if err != nil {
+			return err
+		}
+
+		settings, err := readSettings(tx)
+		if err != nil {
+			return err
+		}
+
+		return putPinned(metadata, slices.DeleteFunc(settings.Pinned, func(id string) bool {
+			return id == messageID
+		}))
+	})
+}
+
+func readSettings(tx *bbolt.Tx) (Settings, error) {
+	settings := defaultSettings
+	metadata := tx.Bucket([]byte("metadata"))
+	if metadata == nil {
+		return settings, nil
+	}
+
+	if v := metadata.Get([]byte("post_policy")); v != nil {
+		settings.PostPolicy = Policy(v)
+	}
+	if v := metadata.Get([]byte("invite_policy")); v != nil {
+		settings.InvitePolicy = Policy(v)
+	}
+	if v := metadata.Get([]byte("slow_mode")); v != nil {
+		seconds, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return settings, fmt.Errorf("failed to parse slow mode: %w", err)
+		}
+		settings.SlowMode = time.Duration(seconds) * time.Second
+	}
+	if v := metadata.Get([]byte("pinned")); v != nil {
+		if err := json.Unmarshal(v, &settings.Pinned); err != nil {
+			return settings, fmt.Errorf("failed to parse pinned messages: %w", err)
+		}
+	}
+
+	return settings, nil
+}
+
+func putPinned(metadata *bbolt.Bucket, pinned []string) error {
+	data, err := json.Marshal(pinned)
+	if err != nil {
+		return err
+	}
+	return metadata.Put([]byte("pinned"), data)
+}
+
+// writableMetadata returns metadata bucket or ErrArchived if chat is read-only
+func writableMetadata(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	metadata, err := tx.CreateBucketIfNotExists([]byte("metadata"))
+	if err != nil {
+		return nil, err
+	}
+
+	if string(metadata.Get([]byte("archived"))) == "true" {
+		return nil, ErrArchived
+	}
+
+	return metadata, nil
+}
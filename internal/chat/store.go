@@ -0,0 +1,587 @@
+package chat
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.etcd.io/bbolt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStoreClosed  = errors.New("chat store is closed")
+	ErrChatNotFound = errors.New("chat not found")
+)
+
+// Store keeps open chat databases in an LRU, so hot chats are not reopened (and relocked) on every request.
+// Handles which are not in use are closed when the LRU is over capacity or when they were idle for too long.
+type Store struct {
+	dir         string
+	maxOpen     int
+	idleTimeout time.Duration
+
+	// index maps username to chats where user is a member and keeps when the first message of chat expires
+	index *bbolt.DB
+	// indexMu is held for reading while members are indexed and for writing while Delete removes index
+	// entries, so a chat being deleted is never indexed again
+	indexMu sync.RWMutex
+
+	mu       sync.Mutex
+	released *sync.Cond // signalled with mu when a handle is released, Delete waits for holders of the chat
+	handles  map[string]*list.Element
+	lru      *list.List      // front is the most recently used handle
+	deleting map[string]bool // chats which are opened as not found until Delete is done
+	closed   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// handle is put into the Store before its database is opened, so concurrent openers of the same chat wait
+// for opened instead of opening (and locking) the file twice
+type handle struct {
+	chatID   string
+	bdb      *bbolt.DB
+	refs     int
+	lastUsed time.Time
+
+	opened  chan struct{} // closed when bdb is opened or openErr is set
+	openErr error
+}
+
+func NewStore(dir string, maxOpen int, idleTimeout time.Duration) (*Store, error) {
+	if maxOpen <= 0 || idleTimeout <= 0 {
+		return nil, fmt.Errorf("max open chats and idle timeout should be positive")
+	}
+
+	index, err := bbolt.Open(filepath.Join(dir, "chats.db"), 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chats index database: %w", err)
+	}
+
+	s := &Store{
+		dir:         dir,
+		maxOpen:     maxOpen,
+		idleTimeout: idleTimeout,
+
+		index: index,
+
+		handles:  make(map[string]*list.Element),
+		lru:      list.New(),
+		deleting: make(map[string]bool),
+
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.released = sync.NewCond(&s.mu)
+
+	go s.evictIdleLoop()
+
+	return s, nil
+}
+
+// Open returns existing chat by ID, the caller must Close it when done
+func (s *Store) Open(chatID string) (*Chat, error) {
+	return s.open(chatID, false)
+}
+
+func (s *Store) open(chatID string, create bool) (*Chat, error) {
+	if err := uuid.Validate(chatID); err != nil {
+		return nil, fmt.Errorf("invalid chat ID: %w", err)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrStoreClosed
+	}
+	if s.deleting[chatID] {
+		s.mu.Unlock()
+		return nil, ErrChatNotFound
+	}
+
+	if el, ok := s.handles[chatID]; ok {
+		h := el.Value.(*handle)
+		h.refs++
+		s.lru.MoveToFront(el)
+		s.mu.Unlock()
+
+		<-h.opened
+		if h.openErr != nil {
+			// placeholder is already removed by the opener, there is nothing to release
+			return nil, h.openErr
+		}
+		return &Chat{store: s, h: h, chatID: chatID}, nil
+	}
+
+	// placeholder has refs, so it is never evicted while the database is being opened without mu
+	h := &handle{
+		chatID: chatID,
+		refs:   1,
+		opened: make(chan struct{}),
+	}
+	el := s.lru.PushFront(h)
+	s.handles[chatID] = el
+	s.mu.Unlock()
+
+	bdb, err := s.openDB(chatID, create)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil && s.closed {
+		_ = bdb.Close()
+		err = ErrStoreClosed
+	}
+	if err != nil {
+		if s.handles[chatID] == el {
+			s.lru.Remove(el)
+			delete(s.handles, chatID)
+		}
+		h.openErr = err
+		close(h.opened)
+		return nil, err
+	}
+
+	h.bdb = bdb
+	close(h.opened)
+	s.evictOverCapacity()
+
+	return &Chat{store: s, h: h, chatID: chatID}, nil
+}
+
+func (s *Store) openDB(chatID string, create bool) (*bbolt.DB, error) {
+	if !create {
+		if _, err := os.Stat(s.chatPath(chatID)); errors.Is(err, os.ErrNotExist) {
+			return nil, ErrChatNotFound
+		}
+	}
+
+	bdb, err := bbolt.Open(s.chatPath(chatID), 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat database: %w", err)
+	}
+	return bdb, nil
+}
+
+// Create new chat with given metadata, the caller must Close it when done
+func (s *Store) Create(creatorUsername, name, publicKey string) (*Chat, error) {
+	chatID := uuid.New().String()
+	chat, err := s.open(chatID, true)
+	if err != nil {
+		return nil, err
+	}
+	err = chat.db().Update(func(tx *bbolt.Tx) error {
+		metadata, err := tx.CreateBucketIfNotExists([]byte("metadata"))
+		if err != nil {
+			return err
+		}
+
+		if metadata.Put([]byte("name"), []byte(name)) != nil {
+			return fmt.Errorf("failed to put name into metadata")
+		}
+
+		if metadata.Put([]byte("creator"), []byte(creatorUsername)) != nil {
+			return fmt.Errorf("failed to put creator into metadata")
+		}
+
+		if metadata.Put([]byte("publicKey"), []byte(publicKey)) != nil {
+			return fmt.Errorf("failed to put publicKey into metadata")
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = chat.AddMember(creatorUsername, RoleOwner)
+	}
+	if err != nil {
+		_ = chat.Close()
+		return nil, err
+	}
+	return chat, nil
+}
+
+// Delete closes chat database, removes its file and member index entries, the caller must not hold the chat.
+// The chat is not found for Open from the start, so nobody reopens it before the file is gone, and the database
+// is closed only after everyone holding it has closed it.
+func (s *Store) Delete(chatID string) error {
+	c, err := s.Open(chatID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.deleting[chatID] {
+		s.mu.Unlock()
+		_ = c.Close()
+		return ErrChatNotFound
+	}
+	s.deleting[chatID] = true
+	for c.h.refs > 1 && !s.closed {
+		s.released.Wait()
+	}
+	closed := s.closed
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.deleting, chatID)
+		s.mu.Unlock()
+	}()
+
+	if closed {
+		_ = c.Close()
+		return ErrStoreClosed
+	}
+
+	// nobody else holds the chat, so members don't change anymore and every added member is indexed already
+	members, err := c.Members()
+	_ = c.Close()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if el, ok := s.handles[chatID]; ok {
+		s.evict(el)
+	}
+	s.mu.Unlock()
+
+	if err := os.Remove(s.chatPath(chatID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove chat database: %w", err)
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	return s.index.Update(func(tx *bbolt.Tx) error {
+		if err := setChatExpiry(tx, chatID, 0, true); err != nil {
+			return err
+		}
+
+		userChats := tx.Bucket([]byte("user-chats"))
+		if userChats == nil {
+			return nil
+		}
+
+		for username := range members {
+			chats := userChats.Bucket([]byte(username))
+			if chats == nil {
+				continue
+			}
+			if err := chats.Delete([]byte(chatID)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Purge expired messages from chats which are due according to the expiry index, returns IDs of purged
+// messages. One broken chat doesn't stop the purge, errors are joined.
+func (s *Store) Purge(now time.Time) ([]string, error) {
+	chatIDs, indexed, err := s.dueChats(now)
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []string
+	var errs []error
+	for _, chatID := range chatIDs {
+		messageIDs, err := s.purgeChat(chatID, now)
+		purged = append(purged, messageIDs...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to purge chat %s: %w", chatID, err))
+		}
+	}
+
+	if !indexed && len(errs) == 0 {
+		// every chat is indexed now, later purges open only chats which are due
+		err := s.index.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte("expiry-indexed"))
+			return err
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return purged, errors.Join(errs...)
+}
+
+// dueChats returns chats with messages expired at now. Chats created before the expiry index existed
+// are not in it, so until the first full purge succeeds every chat is returned and indexed is false.
+func (s *Store) dueChats(now time.Time) ([]string, bool, error) {
+	var chatIDs []string
+	indexed := false
+	err := s.index.View(func(tx *bbolt.Tx) error {
+		indexed = tx.Bucket([]byte("expiry-indexed")) != nil
+		schedule := tx.Bucket([]byte("expiry-schedule"))
+		if !indexed || schedule == nil {
+			return nil
+		}
+
+		cur := schedule.Cursor()
+		for k, _ := cur.First(); k != nil && int64(binary.BigEndian.Uint64(k[:8])) <= now.Unix(); k, _ = cur.Next() {
+			chatIDs = append(chatIDs, string(k[8:]))
+		}
+		return nil
+	})
+	if err != nil || indexed {
+		return chatIDs, indexed, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "chat-*.db"))
+	if err != nil {
+		return nil, false, err
+	}
+	for _, path := range paths {
+		chatIDs = append(chatIDs, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "chat-"), ".db"))
+	}
+	return chatIDs, false, nil
+}
+
+func (s *Store) purgeChat(chatID string, now time.Time) ([]string, error) {
+	c, err := s.Open(chatID)
+	if errors.Is(err, ErrChatNotFound) {
+		// deleted meanwhile
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	messageIDs, err := c.Purge(now)
+	if err != nil {
+		return nil, err
+	}
+
+	// next expiry is read inside the index transaction, so expiry scheduled by a message posted meanwhile
+	// is either seen here or applied after this update
+	err = s.index.Update(func(tx *bbolt.Tx) error {
+		next, err := c.nextExpiry()
+		if err != nil {
+			return err
+		}
+		return setChatExpiry(tx, chatID, next, true)
+	})
+	return messageIDs, err
+}
+
+// scheduleExpiry of chat at expiresAt unless it is scheduled earlier already
+func (s *Store) scheduleExpiry(chatID string, expiresAt int64) error {
+	return s.index.Update(func(tx *bbolt.Tx) error {
+		return setChatExpiry(tx, chatID, expiresAt, false)
+	})
+}
+
+// setChatExpiry keeps "chat-expiry" (chat ID to time) and "expiry-schedule" (time and chat ID, sorted by time)
+// in sync. Earlier expiresAt always wins unless replace is set, zero expiresAt with replace unschedules the chat.
+func setChatExpiry(tx *bbolt.Tx, chatID string, expiresAt int64, replace bool) error {
+	byChat, err := tx.CreateBucketIfNotExists([]byte("chat-expiry"))
+	if err != nil {
+		return err
+	}
+	schedule, err := tx.CreateBucketIfNotExists([]byte("expiry-schedule"))
+	if err != nil {
+		return err
+	}
+
+	if v := byChat.Get([]byte(chatID)); v != nil {
+		current := int64(binary.BigEndian.Uint64(v))
+		if !replace && current <= expiresAt {
+			return nil
+		}
+		if err := schedule.Delete(expiryKey(current, chatID)); err != nil {
+			return err
+		}
+	}
+
+	if expiresAt == 0 {
+		return byChat.Delete([]byte(chatID))
+	}
+
+	if err := byChat.Put([]byte(chatID), binary.BigEndian.AppendUint64(nil, uint64(expiresAt))); err != nil {
+		return err
+	}
+	return schedule.Put(expiryKey(expiresAt, chatID), nil)
+}
+
+// UserChats returns IDs of chats where user is a member
+func (s *Store) UserChats(username string) ([]string, error) {
+	chatIDs := make([]string, 0)
+	err := s.index.View(func(tx *bbolt.Tx) error {
+		userChats := tx.Bucket([]byte("user-chats"))
+		if userChats == nil {
+			return nil
+		}
+		chats := userChats.Bucket([]byte(username))
+		if chats == nil {
+			return nil
+		}
+
+		return chats.ForEach(func(k, _ []byte) error {
+			chatIDs = append(chatIDs, string(k))
+			return nil
+		})
+	})
+	return chatIDs, err
+}
+
+func (s *Store) unindexMember(username, chatID string) error {
+	return s.index.Update(func(tx *bbolt.Tx) error {
+		userChats := tx.Bucket([]byte("user-chats"))
+		if userChats == nil {
+			return nil
+		}
+		chats := userChats.Bucket([]byte(username))
+		if chats == nil {
+			return nil
+		}
+		return chats.Delete([]byte(chatID))
+	})
+}
+
+// indexMember fails with ErrChatNotFound when the chat is being deleted
+func (s *Store) indexMember(username, chatID string) error {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	s.mu.Lock()
+	deleting := s.deleting[chatID]
+	s.mu.Unlock()
+	if deleting {
+		return ErrChatNotFound
+	}
+
+	return s.index.Update(func(tx *bbolt.Tx) error {
+		userChats, err := tx.CreateBucketIfNotExists([]byte("user-chats"))
+		if err != nil {
+			return err
+		}
+		chats, err := userChats.CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+		return chats.Put([]byte(chatID), []byte{})
+	})
+}
+
+// Close stops eviction and closes every open chat database
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.released.Broadcast()
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for el := s.lru.Front(); el != nil; el = el.Next() {
+		h := el.Value.(*handle)
+		if h.bdb == nil {
+			// still opening, the opener closes it when it sees the store is closed
+			continue
+		}
+		if err := h.bdb.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.handles = make(map[string]*list.Element)
+	s.lru.Init()
+
+	if err := s.index.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Store) chatPath(chatID string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("chat-%s.db", chatID))
+}
+
+func (s *Store) release(h *handle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h.refs--
+	h.lastUsed = time.Now()
+	s.released.Broadcast()
+
+	if !s.closed {
+		s.evictOverCapacity()
+	}
+}
+
+// evictOverCapacity closes least recently used handles which are not in use, must be called with mu held
+func (s *Store) evictOverCapacity() {
+	el := s.lru.Back()
+	for s.lru.Len() > s.maxOpen && el != nil {
+		prev := el.Prev()
+		if el.Value.(*handle).refs == 0 {
+			s.evict(el)
+		}
+		el = prev
+	}
+}
+
+// evict must be called with mu held
+func (s *Store) evict(el *list.Element) {
+	h := el.Value.(*handle)
+	s.lru.Remove(el)
+	delete(s.handles, h.chatID)
+
+	if err := h.bdb.Close(); err != nil {
+		// nothing to return it to, handle is dropped anyway
+		log.Println("failed to close chat database", h.chatID, err)
+	}
+}
+
+func (s *Store) evictIdleLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.evictIdle(now)
+		}
+	}
+}
+
+func (s *Store) evictIdle(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el := s.lru.Back()
+	for el != nil {
+		prev := el.Prev()
+		h := el.Value.(*handle)
+		if h.refs == 0 && now.Sub(h.lastUsed) >= s.idleTimeout {
+			s.evict(el)
+		}
+		el = prev
+	}
+}
@@ -0,0 +1,287 @@
+package chat
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreSharesOpenHandle(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+
+	again, err := s.Open(c.GetChatID())
+	if err != nil {
+		t.Fatalf("Error opening chat: %v", err)
+	}
+
+	if c.db() != again.db() {
+		t.Errorf("Expected the same database handle for the same chat")
+	}
+
+	_ = c.Close()
+	_ = again.Close()
+
+	if s.lru.Len() != 1 {
+		t.Errorf("Expected 1 open handle, got %d", s.lru.Len())
+	}
+}
+
+func TestStoreConcurrentOpen(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	chatID := c.GetChatID()
+	_ = c.Close()
+	s.evictIdle(time.Now().Add(2 * time.Minute))
+
+	chats := make([]*Chat, 8)
+	errs := make([]error, len(chats))
+	var wg sync.WaitGroup
+	for i := range chats {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chats[i], errs[i] = s.Open(chatID)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, c := range chats {
+		if errs[i] != nil {
+			t.Fatalf("Error opening chat: %v", errs[i])
+		}
+		if c.db() != chats[0].db() {
+			t.Errorf("Expected concurrent openers to share the database handle")
+		}
+	}
+	for _, c := range chats {
+		_ = c.Close()
+	}
+
+	if s.lru.Len() != 1 || s.lru.Front().Value.(*handle).refs != 0 {
+		t.Errorf("Expected 1 released handle, got %d", s.lru.Len())
+	}
+
+	if _, err := s.Open("00000000-0000-0000-0000-000000000000"); !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("Expected ErrChatNotFound, got %v", err)
+	}
+	if s.lru.Len() != 1 {
+		t.Errorf("Expected placeholder of missing chat to be removed, got %d handles", s.lru.Len())
+	}
+}
+
+func TestStoreEvictsOverCapacity(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 2, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	inUse, err := s.Create("alice", "in use", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		c, err := s.Create("alice", "test", "")
+		if err != nil {
+			t.Fatalf("Error creating chat: %v", err)
+		}
+		_ = c.Close()
+	}
+
+	if s.lru.Len() != 2 {
+		t.Errorf("Expected 2 open handles, got %d", s.lru.Len())
+	}
+
+	if _, ok := s.handles[inUse.GetChatID()]; !ok {
+		t.Errorf("Handle in use should not be evicted")
+	}
+	_ = inUse.Close()
+}
+
+func TestStoreEvictsIdle(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	_ = c.Close()
+
+	s.evictIdle(time.Now().Add(2 * time.Minute))
+
+	if s.lru.Len() != 0 {
+		t.Errorf("Expected idle handle to be evicted, got %d open", s.lru.Len())
+	}
+
+	reopened, err := s.Open(c.GetChatID())
+	if err != nil {
+		t.Fatalf("Error reopening chat: %v", err)
+	}
+	_ = reopened.Close()
+}
+
+func TestStoreClose(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	_ = c.Close()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Error closing store: %v", err)
+	}
+
+	if _, err := s.Open(c.GetChatID()); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("Expected ErrStoreClosed, got %v", err)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	if err := c.AddMember("bob", RoleMember); err != nil {
+		t.Fatalf("Error adding member: %v", err)
+	}
+	_ = c.Close()
+
+	// concurrent Delete and Open either see the chat or don't, the file is never reopened once deletion starts
+	var wg sync.WaitGroup
+	deleteErrs := make([]error, 4)
+	for i := range deleteErrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			deleteErrs[i] = s.Delete(c.GetChatID())
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if opened, err := s.Open(c.GetChatID()); err == nil {
+				_ = opened.Close()
+			} else if !errors.Is(err, ErrChatNotFound) {
+				t.Errorf("Expected ErrChatNotFound during delete, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deleted := 0
+	for _, err := range deleteErrs {
+		if err == nil {
+			deleted++
+		} else if !errors.Is(err, ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound from concurrent delete, got %v", err)
+		}
+	}
+	if deleted != 1 {
+		t.Errorf("Expected chat to be deleted once, got %d", deleted)
+	}
+
+	if _, err := s.Open(c.GetChatID()); !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("Expected ErrChatNotFound, got %v", err)
+	}
+
+	for _, username := range []string{"alice", "bob"} {
+		chats, err := s.UserChats(username)
+		if err != nil {
+			t.Fatalf("Error reading user chats: %v", err)
+		}
+		if len(chats) != 0 {
+			t.Errorf("Expected no chats for %s, got %v", username, chats)
+		}
+	}
+}
+
+func TestStoreDeleteWaitsForHolders(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	chatID := c.GetChatID()
+
+	deleted := make(chan error, 1)
+	go func() {
+		deleted <- s.Delete(chatID)
+	}()
+
+	// wait until deletion has started, the chat is not found for new openers then
+	for {
+		opened, err := s.Open(chatID)
+		if errors.Is(err, ErrChatNotFound) {
+			break
+		} else if err != nil {
+			t.Fatalf("Error opening chat: %v", err)
+		}
+		_ = opened.Close()
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case err := <-deleted:
+		t.Fatalf("Expected Delete to wait for the holder, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// holder can still use the database, but members added now are not indexed
+	if _, err := c.PostMessage("alice", "Text", nil, time.Now()); err != nil {
+		t.Errorf("Error posting message while chat is being deleted: %v", err)
+	}
+	if err := c.AddMember("bob", RoleMember); !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("Expected ErrChatNotFound indexing member of chat being deleted, got %v", err)
+	}
+	_ = c.Close()
+
+	if err := <-deleted; err != nil {
+		t.Fatalf("Error deleting chat: %v", err)
+	}
+
+	for _, username := range []string{"alice", "bob"} {
+		if chats, _ := s.UserChats(username); len(chats) != 0 {
+			t.Errorf("Expected no chats for %s, got %v", username, chats)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package expiry
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/internal/blobs"
+	"github.com/soul-ua/server/internal/chat"
+	"github.com/soul-ua/server/internal/inbox"
+	"log"
+	"sync"
+	"time"
+)
+
+// Sweeper purges expired envelopes from inboxes and expired messages from chats in background,
+// attachments they referenced are released, so the blob collector can delete them
+type Sweeper struct {
+	chats    *chat.Store
+	blobs    *blobs.Store
+	interval time.Duration
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewSweeper(chats *chat.Store, blobStore *blobs.Store, interval time.Duration) *Sweeper {
+	s := &Sweeper{
+		chats:    chats,
+		blobs:    blobStore,
+		interval: interval,
+
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go s.sweepLoop()
+
+	return s
+}
+
+// Sweep everything expired at now, returns number of purged envelopes and messages.
+// One broken inbox or chat doesn't stop the sweep, errors are joined.
+func (s *Sweeper) Sweep(now time.Time) (int, error) {
+	var errs []error
+	purged := 0
+
+	usernames, err := inbox.Usernames()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list inboxes: %w", err)
+	}
+
+	for _, username := range usernames {
+		n, err := s.sweepInbox(username, now)
+		purged += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to sweep inbox of %s: %w", username, err))
+		}
+	}
+
+	messageIDs, err := s.chats.Purge(now)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to sweep chats: %w", err))
+	}
+	purged += len(messageIDs)
+
+	for _, messageID := range messageIDs {
+		if err := s.blobs.Release(messageID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return purged, errors.Join(errs...)
+}
+
+func (s *Sweeper) sweepInbox(username string, now time.Time) (int, error) {
+	inbx, err := inbox.NewInbox(username)
+	if err != nil {
+		return 0, err
+	}
+	defer inbx.Close()
+
+	envelopes, err := inbx.Purge(now)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, envelope := range envelopes {
+		if len(envelope.Attachments) == 0 {
+			continue
+		}
+		if err := s.blobs.Release(envelope.ID); err != nil {
+			return len(envelopes), err
+		}
+	}
+
+	return len(envelopes), nil
+}
+
+// Close stops the background sweep and waits for the running one to finish
+func (s *Sweeper) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *Sweeper) sweepLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			purged, err := s.Sweep(now)
+			if err != nil {
+				log.Println("failed to sweep expired envelopes:", err)
+			}
+			if purged > 0 {
+				log.Printf("* purged %d expired envelopes and messages", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package expiry
+
+import (
+	"errors"
+	"github.com/soul-ua/server/internal/blobs"
+	"github.com/soul-ua/server/internal/chat"
+	"github.com/soul-ua/server/internal/inbox"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSweeper(t *testing.T) (*Sweeper, *chat.Store, *blobs.Store) {
+	dir := t.TempDir()
+
+	inboxDir := inbox.Dir
+	inbox.Dir = dir
+	t.Cleanup(func() { inbox.Dir = inboxDir })
+
+	bdb, err := bbolt.Open(filepath.Join(dir, "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { _ = bdb.Close() })
+
+	storage, err := blobs.NewFilesystem(dir)
+	if err != nil {
+		t.Fatalf("Error creating storage: %v", err)
+	}
+	blobStore, err := blobs.NewStore(bdb, storage, blobs.Policy{MaxAge: time.Hour, UnreferencedGrace: time.Minute})
+	if err != nil {
+		t.Fatalf("Error creating blob store: %v", err)
+	}
+	t.Cleanup(blobStore.Close)
+
+	chats, err := chat.NewStore(dir, 4, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating chat store: %v", err)
+	}
+	t.Cleanup(func() { _ = chats.Close() })
+
+	s := NewSweeper(chats, blobStore, time.Hour)
+	t.Cleanup(s.Close)
+
+	return s, chats, blobStore
+}
+
+func upload(t *testing.T, s *blobs.Store, owner, content string) string {
+	t.Helper()
+
+	u, err := s.CreateUpload(owner, int64(len(content)))
+	if err != nil {
+		t.Fatalf("Error creating upload: %v", err)
+	}
+
+	u, err = s.WriteChunk(u.ID, 0, []byte(content))
+	if err != nil {
+		t.Fatalf("Error writing chunk: %v", err)
+	}
+
+	return u.BlobID
+}
+
+func TestSweep(t *testing.T) {
+	s, chats, blobStore := newTestSweeper(t)
+	now := time.Now()
+
+	envelopeBlob := upload(t, blobStore, "alice", "photo")
+	messageBlob := upload(t, blobStore, "alice", "video")
+
+	inbx, err := inbox.NewInbox("bob")
+	if err != nil {
+		t.Fatalf("Error opening inbox: %v", err)
+	}
+	expiring := &protocol.Envelope{To: "bob", From: "alice", Attachments: []string{envelopeBlob}, ExpiresAt: now.Add(time.Minute).Unix()}
+	if _, err := inbx.Append(expiring); err != nil {
+		t.Fatalf("Error appending envelope: %v", err)
+	}
+	if _, err := inbx.Append(&protocol.Envelope{To: "bob", From: "alice"}); err != nil {
+		t.Fatalf("Error appending envelope: %v", err)
+	}
+	_ = inbx.Close()
+	if err := blobStore.Reference(expiring.ID, "bob", []string{envelopeBlob}); err != nil {
+		t.Fatalf("Error referencing blob: %v", err)
+	}
+
+	c, err := chats.Create("alice", "test", "")
+	if err != nil {
+		t.Fatalf("Error creating chat: %v", err)
+	}
+	messageID, err := c.PostExpiringMessage("alice", "Text", nil, now, time.Minute)
+	if err != nil {
+		t.Fatalf("Error posting message: %v", err)
+	}
+	_ = c.Close()
+	if err := blobStore.Reference(messageID, "", []string{messageBlob}); err != nil {
+		t.Fatalf("Error referencing blob: %v", err)
+	}
+
+	if purged, err := s.Sweep(now); err != nil || purged != 0 {
+		t.Errorf("Expected nothing purged before expiry, got %d %v", purged, err)
+	}
+
+	// broken inboxes fail alone, the rest is swept
+	for _, username := range []string{"broken1", "broken2"} {
+		if err := os.WriteFile(filepath.Join(inbox.Dir, "inbox-"+username+".db"), []byte("not a database"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := s.Sweep(now.Add(2 * time.Minute))
+	if purged != 2 {
+		t.Errorf("Expected envelope and message to be purged, got %d", purged)
+	}
+	if err == nil || !strings.Contains(err.Error(), "broken1") || !strings.Contains(err.Error(), "broken2") {
+		t.Errorf("Expected errors of both broken inboxes to be joined, got %v", err)
+	}
+
+	inbx, err = inbox.NewInbox("bob")
+	if err != nil {
+		t.Fatalf("Error opening inbox: %v", err)
+	}
+	var left []string
+	_ = inbx.Read(nil, func(envelope *protocol.Envelope) error {
+		left = append(left, envelope.ID)
+		return nil
+	})
+	_ = inbx.Close()
+	if len(left) != 1 || left[0] == expiring.ID {
+		t.Errorf("Expected only envelope without expiry left, got %v", left)
+	}
+
+	if again, _ := chats.Purge(now.Add(2 * time.Minute)); len(again) != 0 {
+		t.Errorf("Expected chat message to be purged already, got %v", again)
+	}
+
+	// released attachments are collected like downloaded ones
+	if _, err := blobStore.Collect(now.Add(2 * time.Minute)); err != nil {
+		t.Fatalf("Error collecting blobs: %v", err)
+	}
+	for _, blobID := range []string{envelopeBlob, messageBlob} {
+		if _, err := blobStore.Open(blobID, "bob"); !errors.Is(err, blobs.ErrBlobNotFound) {
+			t.Errorf("Expected released blob %s to be collected, got %v", blobID, err)
+		}
+	}
+}
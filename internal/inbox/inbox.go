@@ -1,21 +1,60 @@
 package inbox
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/soul-ua/server/pkg/protocol"
 )
 
+var ErrInboxFull = errors.New("inbox is full")
+
+// Dir where inbox databases are kept, it should be set before inboxes are opened
+var Dir = ".data"
+
+// Limits of one inbox, zero is unlimited. Sender limits keep one sender from taking the whole inbox,
+// they don't apply to server notifications and sealed sender envelopes, server can't tell sealed senders apart.
+type Limits struct {
+	MaxCount       int64
+	MaxBytes       int64
+	SenderMaxCount int64
+	SenderMaxBytes int64
+	// ServerMaxCount and ServerMaxBytes limit server notices instead of inbox limits,
+	// so notices are delivered to a full inbox while other users can't trigger them without end
+	ServerMaxCount int64
+	ServerMaxBytes int64
+}
+
+// DefaultLimits are enough for a month of heavy use by a client which never acknowledges envelopes
+var DefaultLimits = Limits{
+	MaxCount:       10000,
+	MaxBytes:       256 << 20,
+	SenderMaxCount: 2000,
+	SenderMaxBytes: 64 << 20,
+	ServerMaxCount: 1000,
+	ServerMaxBytes: 16 << 20,
+}
+
+// Usage of inbox, envelopes are counted with the size they are stored with
+type Usage struct {
+	Count int64
+	Bytes int64
+}
+
 type Inbox struct {
 	bdb      *bbolt.DB
 	username string
 }
 
 func NewInbox(username string) (*Inbox, error) {
-	bdb, err := bbolt.Open(fmt.Sprintf(".data/inbox-%s.db", username), 0600, nil)
+	bdb, err := bbolt.Open(inboxPath(username), 0600, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open inbox database: %w", err)
 	}
@@ -32,7 +71,19 @@ func (i *Inbox) Close() error {
 	return i.bdb.Close()
 }
 
+// Append envelope without limits
 func (i *Inbox) Append(envelope *protocol.Envelope) (uuid.UUID, error) {
+	return i.AppendLimited(envelope, Limits{})
+}
+
+// AppendLimited fails with ErrInboxFull if envelope doesn't fit into limits
+func (i *Inbox) AppendLimited(envelope *protocol.Envelope, limits Limits) (uuid.UUID, error) {
+	return i.AppendCharged(envelope, limits, envelope.From)
+}
+
+// AppendCharged is AppendLimited with usage charged to sender instead of envelope.From, so server notices
+// triggered by a user count to limits of that user and not to limits of server notices
+func (i *Inbox) AppendCharged(envelope *protocol.Envelope, limits Limits, sender string) (uuid.UUID, error) {
 	envelopeID, err := uuid.NewV7() // should be v7 for binary sort
 	if err != nil {
 		panic(err)
@@ -45,28 +96,90 @@ func (i *Inbox) Append(envelope *protocol.Envelope) (uuid.UUID, error) {
 		return envelopeID, fmt.Errorf("envelope is not addressed to this inbox")
 	}
 
-	packed, err := envelope.Pack()
+	if envelope.Ephemeral {
+		return envelopeID, fmt.Errorf("ephemeral envelopes are never stored")
+	}
+
+	packed, err := envelope.PackAs(protocol.ContentTypeCBOR)
 	if err != nil {
 		return envelopeID, fmt.Errorf("failed to pack envelope: %w", err)
 	}
 
+	size := int64(len(packed))
 	err = i.bdb.Update(func(tx *bbolt.Tx) error {
 		mailbox, err := tx.CreateBucketIfNotExists([]byte("mailbox"))
 		if err != nil {
 			return fmt.Errorf("failed to create mailbox bucket: %w", err)
 		}
 
-		return mailbox.Put([]byte(envelopeID.String()), packed)
+		total, senders, err := usageBuckets(tx)
+		if err != nil {
+			return err
+		}
+
+		// server notices are counted in total usage, but don't take space of other envelopes
+		notices := getUsage(senders, []byte("server"))
+		if sender == "server" {
+			if exceeds(notices, size, limits.ServerMaxCount, limits.ServerMaxBytes) {
+				return ErrInboxFull
+			}
+		} else {
+			usage := getUsage(total, totalUsageKey)
+			usage.Count -= notices.Count
+			usage.Bytes -= notices.Bytes
+			if exceeds(usage, size, limits.MaxCount, limits.MaxBytes) {
+				return ErrInboxFull
+			}
+			if sender != "" {
+				usage = getUsage(senders, []byte(sender))
+				if exceeds(usage, size, limits.SenderMaxCount, limits.SenderMaxBytes) {
+					return ErrInboxFull
+				}
+			}
+		}
+
+		if err := mailbox.Put([]byte(envelopeID.String()), packed); err != nil {
+			return err
+		}
+
+		if err := addUsage(total, senders, sender, 1, size); err != nil {
+			return err
+		}
+		if sender != envelope.From {
+			charged, err := tx.CreateBucketIfNotExists([]byte("charged-to"))
+			if err != nil {
+				return fmt.Errorf("failed to create charged-to bucket: %w", err)
+			}
+			if err := charged.Put([]byte(envelope.ID), []byte(sender)); err != nil {
+				return err
+			}
+		}
+
+		if envelope.ExpiresAt == 0 {
+			return nil
+		}
+
+		expiry, err := tx.CreateBucketIfNotExists([]byte("expiry"))
+		if err != nil {
+			return fmt.Errorf("failed to create expiry bucket: %w", err)
+		}
+
+		return expiry.Put(expiryKey(envelope.ExpiresAt, envelopeID), nil)
 	})
 
-	if err != nil {
+	if errors.Is(err, ErrInboxFull) {
+		return envelopeID, err
+	} else if err != nil {
 		return envelopeID, fmt.Errorf("failed to write envelope to mailbox: %w", err)
 	}
 
 	return envelopeID, nil
 }
 
-func (i *Inbox) Read(since []byte, cb func(payload []byte) error) error {
+// Read envelopes starting from since, envelopes stored with gob before cbor migration are decoded as well.
+// Expired envelopes which are not purged yet are skipped.
+func (i *Inbox) Read(since []byte, cb func(envelope *protocol.Envelope) error) error {
+	now := time.Now()
 	return i.bdb.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte("mailbox"))
 		if bucket == nil {
@@ -84,7 +197,17 @@ func (i *Inbox) Read(since []byte, cb func(payload []byte) error) error {
 				break
 			}
 
-			if err := cb(v); err != nil {
+			envelope, err := protocol.UnpackEnvelope(v)
+			if err != nil {
+				return fmt.Errorf("failed to unpack envelope %s: %w", k, err)
+			}
+
+			if envelope.Expired(now) {
+				k, v = c.Next()
+				continue
+			}
+
+			if err := cb(envelope); err != nil {
 				return err
 			}
 
@@ -97,3 +220,306 @@ func (i *Inbox) Read(since []byte, cb func(payload []byte) error) error {
 		return nil
 	})
 }
+
+// Get envelope by ID, nil if it is not in the inbox
+func (i *Inbox) Get(envelopeID string) (*protocol.Envelope, error) {
+	var envelope *protocol.Envelope
+	err := i.bdb.View(func(tx *bbolt.Tx) error {
+		mailbox := tx.Bucket([]byte("mailbox"))
+		if mailbox == nil {
+			return nil
+		}
+
+		v := mailbox.Get([]byte(envelopeID))
+		if v == nil {
+			return nil
+		}
+
+		var err error
+		envelope, err = protocol.UnpackEnvelope(v)
+		return err
+	})
+	return envelope, err
+}
+
+// Purge envelopes expired at now, returns them so references to their attachments can be released
+func (i *Inbox) Purge(now time.Time) ([]*protocol.Envelope, error) {
+	var purged []*protocol.Envelope
+	err := i.bdb.Update(func(tx *bbolt.Tx) error {
+		expiry := tx.Bucket([]byte("expiry"))
+		mailbox := tx.Bucket([]byte("mailbox"))
+		if expiry == nil || mailbox == nil {
+			return nil
+		}
+
+		total, senders, err := usageBuckets(tx)
+		if err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		c := expiry.Cursor()
+		for k, _ := c.First(); k != nil && int64(binary.BigEndian.Uint64(k[:8])) <= now.Unix(); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+
+		for _, k := range keys {
+			envelopeID := k[8:]
+			if v := mailbox.Get(envelopeID); v != nil {
+				envelope, err := protocol.UnpackEnvelope(v)
+				if err != nil {
+					return fmt.Errorf("failed to unpack envelope %s: %w", envelopeID, err)
+				}
+				purged = append(purged, envelope)
+
+				if err := mailbox.Delete(envelopeID); err != nil {
+					return err
+				}
+				sender, err := uncharge(tx, envelope)
+				if err != nil {
+					return err
+				}
+				if err := addUsage(total, senders, sender, -1, -int64(len(v))); err != nil {
+					return err
+				}
+			}
+
+			if err := expiry.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	return purged, err
+}
+
+// Delete envelopes acknowledged by inbox owner, unknown IDs are skipped. References to attachments are kept,
+// recipient may download them later. Returns number of deleted envelopes.
+func (i *Inbox) Delete(envelopeIDs []string) (int, error) {
+	deleted := 0
+	err := i.bdb.Update(func(tx *bbolt.Tx) error {
+		mailbox := tx.Bucket([]byte("mailbox"))
+		if mailbox == nil {
+			return nil
+		}
+
+		total, senders, err := usageBuckets(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, envelopeID := range envelopeIDs {
+			v := mailbox.Get([]byte(envelopeID))
+			if v == nil {
+				continue
+			}
+
+			envelope, err := protocol.UnpackEnvelope(v)
+			if err != nil {
+				return fmt.Errorf("failed to unpack envelope %s: %w", envelopeID, err)
+			}
+			deleted++
+
+			sender, err := uncharge(tx, envelope)
+			if err != nil {
+				return err
+			}
+			if err := addUsage(total, senders, sender, -1, -int64(len(v))); err != nil {
+				return err
+			}
+			if err := mailbox.Delete([]byte(envelopeID)); err != nil {
+				return err
+			}
+
+			if envelope.ExpiresAt == 0 {
+				continue
+			}
+			if expiry := tx.Bucket([]byte("expiry")); expiry != nil {
+				id, err := uuid.Parse(envelopeID)
+				if err != nil {
+					return err
+				}
+				if err := expiry.Delete(expiryKey(envelope.ExpiresAt, id)); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	return deleted, err
+}
+
+// Usage of the inbox by all senders
+func (i *Inbox) Usage() (Usage, error) {
+	var usage Usage
+	err := i.bdb.Update(func(tx *bbolt.Tx) error {
+		total, _, err := usageBuckets(tx)
+		if err != nil {
+			return err
+		}
+		usage = getUsage(total, totalUsageKey)
+		return nil
+	})
+	return usage, err
+}
+
+var totalUsageKey = []byte("total")
+
+// usageBuckets with total usage and usage by sender, counters of inboxes written before usage
+// was tracked are rebuilt from mailbox
+func usageBuckets(tx *bbolt.Tx) (*bbolt.Bucket, *bbolt.Bucket, error) {
+	total, senders := tx.Bucket([]byte("usage")), tx.Bucket([]byte("sender-usage"))
+	if total != nil && senders != nil {
+		return total, senders, nil
+	}
+
+	total, err := tx.CreateBucketIfNotExists([]byte("usage"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create usage bucket: %w", err)
+	}
+	senders, err = tx.CreateBucketIfNotExists([]byte("sender-usage"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create sender usage bucket: %w", err)
+	}
+
+	mailbox := tx.Bucket([]byte("mailbox"))
+	if mailbox == nil {
+		return total, senders, nil
+	}
+
+	err = mailbox.ForEach(func(k, v []byte) error {
+		envelope, err := protocol.UnpackEnvelope(v)
+		if err != nil {
+			return fmt.Errorf("failed to unpack envelope %s: %w", k, err)
+		}
+		return addUsage(total, senders, chargedTo(tx, envelope), 1, int64(len(v)))
+	})
+	return total, senders, err
+}
+
+// chargedTo returns sender whose usage envelope counts to, it is envelope.From unless set by AppendCharged
+func chargedTo(tx *bbolt.Tx, envelope *protocol.Envelope) string {
+	if charged := tx.Bucket([]byte("charged-to")); charged != nil {
+		if sender := charged.Get([]byte(envelope.ID)); sender != nil {
+			return string(sender)
+		}
+	}
+	return envelope.From
+}
+
+// uncharge removes sender of envelope being deleted from the mailbox and returns it
+func uncharge(tx *bbolt.Tx, envelope *protocol.Envelope) (string, error) {
+	sender := chargedTo(tx, envelope)
+	if charged := tx.Bucket([]byte("charged-to")); charged != nil {
+		if err := charged.Delete([]byte(envelope.ID)); err != nil {
+			return "", err
+		}
+	}
+	return sender, nil
+}
+
+// exceeds is true if one more envelope of size doesn't fit next to usage
+func exceeds(usage Usage, size, maxCount, maxBytes int64) bool {
+	return (maxCount > 0 && usage.Count+1 > maxCount) || (maxBytes > 0 && usage.Bytes+size > maxBytes)
+}
+
+func addUsage(total, senders *bbolt.Bucket, from string, count, bytes int64) error {
+	if err := putUsage(total, totalUsageKey, count, bytes); err != nil {
+		return err
+	}
+	if from == "" {
+		return nil
+	}
+	return putUsage(senders, []byte(from), count, bytes)
+}
+
+// putUsage adds count and bytes to usage under key, counters never go below zero and empty usage is deleted
+func putUsage(bucket *bbolt.Bucket, key []byte, count, bytes int64) error {
+	usage := getUsage(bucket, key)
+	usage.Count = max(usage.Count+count, 0)
+	usage.Bytes = max(usage.Bytes+bytes, 0)
+
+	if usage.Count == 0 {
+		return bucket.Delete(key)
+	}
+
+	v := binary.BigEndian.AppendUint64(nil, uint64(usage.Count))
+	return bucket.Put(key, binary.BigEndian.AppendUint64(v, uint64(usage.Bytes)))
+}
+
+func getUsage(bucket *bbolt.Bucket, key []byte) Usage {
+	v := bucket.Get(key)
+	if len(v) != 16 {
+		return Usage{}
+	}
+	return Usage{
+		Count: int64(binary.BigEndian.Uint64(v[:8])),
+		Bytes: int64(binary.BigEndian.Uint64(v[8:])),
+	}
+}
+
+// expiryKey sorts by expiration time, so purge stops at the first envelope which is not expired
+func expiryKey(expiresAt int64, envelopeID uuid.UUID) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(expiresAt)), envelopeID.String()...)
+}
+
+// Remove inbox of username with every envelope in it, returns IDs of removed envelopes with attachments,
+// so their blob references can be released. Missing inbox is not an error.
+func Remove(username string) ([]string, error) {
+	path := inboxPath(username)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	inbx, err := NewInbox(username)
+	if err != nil {
+		return nil, err
+	}
+
+	var withAttachments []string
+	err = inbx.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("mailbox"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			envelope, err := protocol.UnpackEnvelope(v)
+			if err != nil {
+				return fmt.Errorf("failed to unpack envelope %s: %w", k, err)
+			}
+			if len(envelope.Attachments) > 0 {
+				withAttachments = append(withAttachments, envelope.ID)
+			}
+			return nil
+		})
+	})
+	if closeErr := inbx.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return withAttachments, os.Remove(path)
+}
+
+// Usernames of users who have inbox
+func Usernames() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(Dir, "inbox-*.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, len(paths))
+	for _, path := range paths {
+		usernames = append(usernames, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "inbox-"), ".db"))
+	}
+	return usernames, nil
+}
+
+func inboxPath(username string) string {
+	return filepath.Join(Dir, fmt.Sprintf("inbox-%s.db", username))
+}
@@ -0,0 +1,120 @@
+package inbox
+
+import (
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+	"testing"
+)
+
+// useTempDir keeps inboxes of the test in its own directory
+func useTempDir(t *testing.T) {
+	dir := Dir
+	Dir = t.TempDir()
+	t.Cleanup(func() { Dir = dir })
+}
+
+func TestAppendLimited(t *testing.T) {
+	useTempDir(t)
+
+	inbx, err := NewInbox("bob")
+	if err != nil {
+		t.Fatalf("Error opening inbox: %v", err)
+	}
+	defer inbx.Close()
+
+	limits := Limits{MaxCount: 3, SenderMaxCount: 2, ServerMaxCount: 2}
+	envelope := func(from string) *protocol.Envelope {
+		return &protocol.Envelope{From: from, To: "bob", Payload: []byte("payload")}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := inbx.AppendLimited(envelope("alice"), limits); err != nil {
+			t.Fatalf("Error appending envelope %d: %v", i, err)
+		}
+	}
+	if _, err := inbx.AppendLimited(envelope("alice"), limits); !errors.Is(err, ErrInboxFull) {
+		t.Errorf("Expected sender limit to fail with ErrInboxFull, got %v", err)
+	}
+
+	carolID, err := inbx.AppendLimited(envelope("carol"), limits)
+	if err != nil {
+		t.Fatalf("Error appending envelope of another sender: %v", err)
+	}
+	if _, err := inbx.AppendLimited(envelope("dave"), limits); !errors.Is(err, ErrInboxFull) {
+		t.Errorf("Expected inbox limit to fail with ErrInboxFull, got %v", err)
+	}
+
+	// server notices have their own limit on top of the full inbox
+	for i := 0; i < 2; i++ {
+		if _, err := inbx.AppendLimited(envelope("server"), limits); err != nil {
+			t.Fatalf("Error appending server notice %d to full inbox: %v", i, err)
+		}
+	}
+	if _, err := inbx.AppendLimited(envelope("server"), limits); !errors.Is(err, ErrInboxFull) {
+		t.Errorf("Expected server notice limit to fail with ErrInboxFull, got %v", err)
+	}
+
+	usage, err := inbx.Usage()
+	if err != nil {
+		t.Fatalf("Error getting usage: %v", err)
+	}
+	if usage.Count != 5 || usage.Bytes == 0 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	deleted, err := inbx.Delete([]string{carolID.String(), "unknown"})
+	if err != nil || deleted != 1 {
+		t.Fatalf("Expected 1 deleted envelope, got %d %v", deleted, err)
+	}
+	if _, err := inbx.AppendLimited(envelope("dave"), limits); err != nil {
+		t.Errorf("Error appending envelope after acknowledge: %v", err)
+	}
+}
+
+func TestAppendCharged(t *testing.T) {
+	useTempDir(t)
+
+	inbx, err := NewInbox("bob")
+	if err != nil {
+		t.Fatalf("Error opening inbox: %v", err)
+	}
+	defer inbx.Close()
+
+	limits := Limits{MaxCount: 10, SenderMaxCount: 3, ServerMaxCount: 1}
+	notice := func() *protocol.Envelope {
+		return &protocol.Envelope{From: "server", To: "bob", PayloadType: "ContactRequested", Payload: []byte("payload")}
+	}
+
+	// contact requests are server notices charged to the requester
+	var requestIDs []string
+	for {
+		envelopeID, err := inbx.AppendCharged(notice(), limits, "mallory")
+		if errors.Is(err, ErrInboxFull) {
+			break
+		} else if err != nil {
+			t.Fatalf("Error appending contact request: %v", err)
+		}
+		requestIDs = append(requestIDs, envelopeID.String())
+	}
+	if len(requestIDs) != 3 {
+		t.Errorf("Expected contact requests to stop at sender limit, got %d", len(requestIDs))
+	}
+
+	if _, err := inbx.AppendLimited(notice(), limits); err != nil {
+		t.Fatalf("Error appending server notice after contact requests: %v", err)
+	}
+	if _, err := inbx.AppendLimited(notice(), limits); !errors.Is(err, ErrInboxFull) {
+		t.Errorf("Expected server notice limit to fail with ErrInboxFull, got %v", err)
+	}
+
+	// deleted requests are taken off the requester usage, not off server notices
+	if deleted, err := inbx.Delete(requestIDs[:1]); err != nil || deleted != 1 {
+		t.Fatalf("Expected 1 deleted envelope, got %d %v", deleted, err)
+	}
+	if _, err := inbx.AppendCharged(notice(), limits, "mallory"); err != nil {
+		t.Errorf("Error appending contact request after acknowledge: %v", err)
+	}
+	if _, err := inbx.AppendLimited(notice(), limits); !errors.Is(err, ErrInboxFull) {
+		t.Errorf("Expected server notice to stay at its limit, got %v", err)
+	}
+}
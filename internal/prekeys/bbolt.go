@@ -0,0 +1,169 @@
+package prekeys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+)
+
+type prekeysBBolt struct {
+	bdb *bbolt.DB
+}
+
+var _ Prekeys = &prekeysBBolt{}
+
+// signedPrekey is stored as JSON in "signed-prekeys" bucket by username
+type signedPrekey struct {
+	IdentityKey    []byte `json:"identity_key"`
+	SignedPrekeyID uint32 `json:"signed_prekey_id"`
+	SignedPrekey   []byte `json:"signed_prekey"`
+	Signature      string `json:"signature"`
+}
+
+func NewPrekeysBBolt(bdb *bbolt.DB) Prekeys {
+	return &prekeysBBolt{
+		bdb: bdb,
+	}
+}
+
+func (p *prekeysBBolt) Upload(username string, req protocol.UploadPrekeysRequest) (int, error) {
+	var count int
+	err := p.bdb.Update(func(tx *bbolt.Tx) error {
+		signed, err := tx.CreateBucketIfNotExists([]byte("signed-prekeys"))
+		if err != nil {
+			return err
+		}
+
+		oneTime, err := tx.CreateBucketIfNotExists([]byte("one-time-prekeys"))
+		if err != nil {
+			return err
+		}
+
+		// one-time prekeys of the old identity can't be used with the new one
+		if previous := signed.Get([]byte(username)); previous != nil {
+			var old signedPrekey
+			if err := json.Unmarshal(previous, &old); err != nil {
+				return err
+			}
+			if !bytes.Equal(old.IdentityKey, req.IdentityKey) && oneTime.Bucket([]byte(username)) != nil {
+				if err := oneTime.DeleteBucket([]byte(username)); err != nil {
+					return err
+				}
+			}
+		}
+
+		data, err := json.Marshal(signedPrekey{
+			IdentityKey:    req.IdentityKey,
+			SignedPrekeyID: req.SignedPrekeyID,
+			SignedPrekey:   req.SignedPrekey,
+			Signature:      req.Signature,
+		})
+		if err != nil {
+			return err
+		}
+		if err := signed.Put([]byte(username), data); err != nil {
+			return err
+		}
+
+		userKeys, err := oneTime.CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+
+		for _, prekey := range req.OneTimePrekeys {
+			if err := userKeys.Put(binary.BigEndian.AppendUint32(nil, prekey.ID), prekey.Key); err != nil {
+				return err
+			}
+		}
+
+		count = countKeys(userKeys)
+		if count > MaxStoredOneTimePrekeys {
+			return ErrTooManyPrekeys
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+func (p *prekeysBBolt) Fetch(username string) (protocol.PrekeyBundle, int, error) {
+	bundle := protocol.PrekeyBundle{
+		Username: username,
+	}
+	var remaining int
+
+	err := p.bdb.Update(func(tx *bbolt.Tx) error {
+		signed := tx.Bucket([]byte("signed-prekeys"))
+		if signed == nil {
+			return ErrNoPrekeys
+		}
+
+		data := signed.Get([]byte(username))
+		if data == nil {
+			return ErrNoPrekeys
+		}
+
+		var stored signedPrekey
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		bundle.IdentityKey = stored.IdentityKey
+		bundle.SignedPrekeyID = stored.SignedPrekeyID
+		bundle.SignedPrekey = stored.SignedPrekey
+		bundle.Signature = stored.Signature
+
+		oneTime := tx.Bucket([]byte("one-time-prekeys"))
+		if oneTime == nil {
+			return nil
+		}
+		userKeys := oneTime.Bucket([]byte(username))
+		if userKeys == nil {
+			return nil
+		}
+
+		c := userKeys.Cursor()
+		id, key := c.First()
+		if id == nil {
+			return nil
+		}
+
+		bundle.OneTimePrekeyID = binary.BigEndian.Uint32(id)
+		bundle.OneTimePrekey = append([]byte(nil), key...)
+		if err := c.Delete(); err != nil {
+			return err
+		}
+
+		remaining = countKeys(userKeys)
+		return nil
+	})
+
+	return bundle, remaining, err
+}
+
+func (p *prekeysBBolt) Delete(username string) error {
+	return p.bdb.Update(func(tx *bbolt.Tx) error {
+		if signed := tx.Bucket([]byte("signed-prekeys")); signed != nil {
+			if err := signed.Delete([]byte(username)); err != nil {
+				return err
+			}
+		}
+
+		oneTime := tx.Bucket([]byte("one-time-prekeys"))
+		if oneTime == nil || oneTime.Bucket([]byte(username)) == nil {
+			return nil
+		}
+		return oneTime.DeleteBucket([]byte(username))
+	})
+}
+
+// countKeys walks the bucket, Stats doesn't see changes of the current transaction
+func countKeys(b *bbolt.Bucket) int {
+	n := 0
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	return n
+}
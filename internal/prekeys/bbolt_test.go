@@ -0,0 +1,96 @@
+package prekeys
+
+import (
+	"bytes"
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func newTestPrekeys(t *testing.T) Prekeys {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { _ = bdb.Close() })
+
+	return NewPrekeysBBolt(bdb)
+}
+
+func TestFetchPopsOneTimePrekeys(t *testing.T) {
+	p := newTestPrekeys(t)
+
+	if _, _, err := p.Fetch("bob"); !errors.Is(err, ErrNoPrekeys) {
+		t.Fatalf("Expected ErrNoPrekeys, got %v", err)
+	}
+
+	count, err := p.Upload("bob", protocol.UploadPrekeysRequest{
+		IdentityKey:    []byte("identity"),
+		SignedPrekeyID: 1,
+		SignedPrekey:   []byte("signed"),
+		OneTimePrekeys: []protocol.OneTimePrekey{{ID: 2, Key: []byte("two")}, {ID: 3, Key: []byte("three")}},
+	})
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 stored one-time prekeys, got %d, %v", count, err)
+	}
+
+	for _, want := range []uint32{2, 3, 0} {
+		bundle, remaining, err := p.Fetch("bob")
+		if err != nil {
+			t.Fatalf("Error fetching bundle: %v", err)
+		}
+		if bundle.OneTimePrekeyID != want || !bytes.Equal(bundle.SignedPrekey, []byte("signed")) {
+			t.Errorf("Expected one-time prekey %d, got %+v", want, bundle)
+		}
+		if want == 2 && remaining != 1 {
+			t.Errorf("Expected 1 remaining one-time prekey, got %d", remaining)
+		}
+	}
+}
+
+func TestUploadNewIdentityDropsOneTimePrekeys(t *testing.T) {
+	p := newTestPrekeys(t)
+
+	_, _ = p.Upload("bob", protocol.UploadPrekeysRequest{
+		IdentityKey:    []byte("old"),
+		SignedPrekeyID: 1,
+		OneTimePrekeys: []protocol.OneTimePrekey{{ID: 2, Key: []byte("two")}},
+	})
+
+	count, err := p.Upload("bob", protocol.UploadPrekeysRequest{
+		IdentityKey:    []byte("new"),
+		SignedPrekeyID: 1,
+	})
+	if err != nil || count != 0 {
+		t.Errorf("Expected one-time prekeys of old identity to be dropped, got %d, %v", count, err)
+	}
+}
+
+func TestUploadLimitsStoredOneTimePrekeys(t *testing.T) {
+	p := newTestPrekeys(t)
+
+	upload := func(from, n int) (int, error) {
+		req := protocol.UploadPrekeysRequest{
+			IdentityKey:    []byte("identity"),
+			SignedPrekeyID: 1,
+		}
+		for id := from; id < from+n; id++ {
+			req.OneTimePrekeys = append(req.OneTimePrekeys, protocol.OneTimePrekey{ID: uint32(id), Key: []byte("key")})
+		}
+		return p.Upload("bob", req)
+	}
+
+	if count, err := upload(1, MaxStoredOneTimePrekeys); err != nil || count != MaxStoredOneTimePrekeys {
+		t.Fatalf("Expected %d stored one-time prekeys, got %d, %v", MaxStoredOneTimePrekeys, count, err)
+	}
+	if _, err := upload(MaxStoredOneTimePrekeys+1, 1); !errors.Is(err, ErrTooManyPrekeys) {
+		t.Errorf("Expected ErrTooManyPrekeys, got %v", err)
+	}
+
+	// uploading existing IDs again replaces them
+	if count, err := upload(1, 10); err != nil || count != MaxStoredOneTimePrekeys {
+		t.Errorf("Expected re-upload of stored IDs to pass, got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,28 @@
+package prekeys
+
+import (
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+)
+
+var (
+	ErrNoPrekeys      = errors.New("user has not uploaded prekeys")
+	ErrTooManyPrekeys = errors.New("too many one-time prekeys")
+)
+
+// MaxStoredOneTimePrekeys of one user, uploads which would store more fail with ErrTooManyPrekeys
+const MaxStoredOneTimePrekeys = 1000
+
+// Prekeys keeps public prekeys of users, so others can start double ratchet sessions while they are offline
+type Prekeys interface {
+	// Upload replaces signed prekey and adds one-time prekeys, returns number of stored one-time prekeys.
+	// Signature should be verified by the caller. Nothing is stored if there would be more than
+	// MaxStoredOneTimePrekeys one-time prekeys.
+	Upload(username string, req protocol.UploadPrekeysRequest) (int, error)
+
+	// Fetch returns bundle with one of one-time prekeys removed from the store and number of remaining ones
+	Fetch(username string) (protocol.PrekeyBundle, int, error)
+
+	// Delete signed and one-time prekeys of username
+	Delete(username string) error
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// minPruneSize of buckets map, below it full buckets are not worth scanning for
+const minPruneSize = 1024
+
+type limiterMemory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	pruneSize int
+}
+
+var _ Limiter = &limiterMemory{}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when bucket is full again and can be forgotten
+}
+
+// NewLimiterMemory keeps buckets in memory of this process, full buckets are forgotten as the map grows
+func NewLimiterMemory() Limiter {
+	return &limiterMemory{
+		buckets:   make(map[string]*bucket),
+		pruneSize: minPruneSize,
+	}
+}
+
+func (l *limiterMemory) Allow(key string, policy Policy, now time.Time) (bool, time.Duration) {
+	if policy.Unlimited() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		l.prune(now)
+		b = &bucket{tokens: float64(policy.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(float64(policy.Burst), b.tokens+float64(elapsed)/float64(policy.Every))
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(policy.Every))
+	}
+
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(policy.Burst) - b.tokens) * float64(policy.Every)))
+	return true, 0
+}
+
+// prune must be called with mu held, it forgets full buckets once the map doubles since the last prune
+func (l *limiterMemory) prune(now time.Time) {
+	if len(l.buckets) < l.pruneSize {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+	l.pruneSize = max(minPruneSize, 2*len(l.buckets))
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLimiterMemoryAllow(t *testing.T) {
+	l := NewLimiterMemory()
+	policy := Policy{Burst: 2, Every: time.Minute}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("alice", policy, now); !ok {
+			t.Fatalf("Request %d should be allowed by burst", i)
+		}
+	}
+
+	ok, retryAfter := l.Allow("alice", policy, now)
+	if ok || retryAfter != time.Minute {
+		t.Errorf("Expected denial with retry after a minute, got %v %s", ok, retryAfter)
+	}
+
+	if ok, _ := l.Allow("bob", policy, now); !ok {
+		t.Error("Buckets should be separate by key")
+	}
+
+	if ok, _ := l.Allow("alice", policy, now.Add(30*time.Second)); ok {
+		t.Error("Half of token should not be enough")
+	}
+	if ok, _ := l.Allow("alice", policy, now.Add(time.Minute)); !ok {
+		t.Error("Token should be refilled after a minute")
+	}
+
+	if ok, _ := l.Allow("alice", Policy{}, now); !ok {
+		t.Error("Zero policy should be unlimited")
+	}
+}
+
+func TestLimiterMemoryPrune(t *testing.T) {
+	l := NewLimiterMemory().(*limiterMemory)
+	policy := Policy{Burst: 1, Every: time.Second}
+	now := time.Now()
+
+	for i := 0; i < minPruneSize; i++ {
+		l.Allow(fmt.Sprint("user", i), policy, now)
+	}
+
+	l.Allow("late", policy, now.Add(time.Second))
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected full buckets to be forgotten, %d left", len(l.buckets))
+	}
+}
@@ -0,0 +1,24 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Policy of token bucket, bucket holds up to Burst tokens and gets one token back Every period.
+// Zero Burst or Every is unlimited.
+type Policy struct {
+	Burst int
+	Every time.Duration
+}
+
+// Unlimited is true if policy never denies
+func (p Policy) Unlimited() bool {
+	return p.Burst <= 0 || p.Every <= 0
+}
+
+// Limiter keeps token buckets by key, so state can live in memory of one server or be shared by many
+type Limiter interface {
+	// Allow takes one token from bucket of key filled under policy. If bucket is empty, returns false
+	// and how long to wait for the next token. Buckets which were never used are full.
+	Allow(key string, policy Policy, now time.Time) (bool, time.Duration)
+}
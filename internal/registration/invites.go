@@ -0,0 +1,151 @@
+package registration
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// inviteCodeSize in random bytes, codes are base32 encoded without padding
+const inviteCodeSize = 15
+
+var inviteEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Invite is stored as JSON in "invites" bucket by code
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedAt time.Time `json:"created_at"`
+	UsedBy    string    `json:"used_by,omitempty"`
+	UsedAt    time.Time `json:"used_at,omitempty"`
+}
+
+// Invites admits registrations with single use invite codes issued by server admin
+type Invites struct {
+	bdb *bbolt.DB
+}
+
+var _ Policy = &Invites{}
+
+func NewInvitesBBolt(bdb *bbolt.DB) *Invites {
+	return &Invites{
+		bdb: bdb,
+	}
+}
+
+func (i *Invites) Info() protocol.RegistrationPolicy {
+	return protocol.RegistrationPolicy{Mode: protocol.RegistrationInvite}
+}
+
+// Issue new invite codes
+func (i *Invites) Issue(count int) ([]string, error) {
+	codes := make([]string, 0, count)
+	err := i.bdb.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("invites"))
+		if err != nil {
+			return err
+		}
+
+		for len(codes) < count {
+			code := make([]byte, inviteCodeSize)
+			if _, err := rand.Read(code); err != nil {
+				return fmt.Errorf("failed to generate invite code: %w", err)
+			}
+
+			invite := Invite{
+				Code:      inviteEncoding.EncodeToString(code),
+				CreatedAt: time.Now(),
+			}
+			data, _ := json.Marshal(invite)
+			if err := bucket.Put([]byte(invite.Code), data); err != nil {
+				return err
+			}
+			codes = append(codes, invite.Code)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// List issued invites, used ones included
+func (i *Invites) List() ([]Invite, error) {
+	var invites []Invite
+	err := i.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("invites"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var invite Invite
+			if err := json.Unmarshal(v, &invite); err != nil {
+				return fmt.Errorf("failed to decode invite %s: %w", k, err)
+			}
+			invites = append(invites, invite)
+			return nil
+		})
+	})
+	return invites, err
+}
+
+// Admit consumes invite code of the request
+func (i *Invites) Admit(req protocol.RegisterRequest) error {
+	return i.bdb.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("invites"))
+		if bucket == nil || req.InviteCode == "" {
+			return ErrInvalidInvite
+		}
+
+		data := bucket.Get([]byte(req.InviteCode))
+		if data == nil {
+			return ErrInvalidInvite
+		}
+
+		var invite Invite
+		if err := json.Unmarshal(data, &invite); err != nil {
+			return fmt.Errorf("failed to decode invite: %w", err)
+		}
+		if invite.UsedBy != "" {
+			return ErrInvalidInvite
+		}
+
+		invite.UsedBy = req.Username
+		invite.UsedAt = time.Now()
+		data, _ = json.Marshal(invite)
+		return bucket.Put([]byte(req.InviteCode), data)
+	})
+}
+
+// Release invite code consumed by Admit of the same request, codes used by other registrations are kept
+func (i *Invites) Release(req protocol.RegisterRequest) error {
+	return i.bdb.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("invites"))
+		if bucket == nil || req.InviteCode == "" {
+			return nil
+		}
+
+		data := bucket.Get([]byte(req.InviteCode))
+		if data == nil {
+			return nil
+		}
+
+		var invite Invite
+		if err := json.Unmarshal(data, &invite); err != nil {
+			return fmt.Errorf("failed to decode invite: %w", err)
+		}
+		if invite.UsedBy != req.Username {
+			return nil
+		}
+
+		invite.UsedBy = ""
+		invite.UsedAt = time.Time{}
+		data, _ = json.Marshal(invite)
+		return bucket.Put([]byte(req.InviteCode), data)
+	})
+}
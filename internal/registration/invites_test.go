@@ -0,0 +1,86 @@
+package registration
+
+import (
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func TestInvitesAdmit(t *testing.T) {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer bdb.Close()
+
+	invites := NewInvitesBBolt(bdb)
+
+	if err := invites.Admit(protocol.RegisterRequest{Username: "alice"}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("Expected ErrInvalidInvite without code, got %v", err)
+	}
+
+	codes, err := invites.Issue(2)
+	if err != nil || len(codes) != 2 || codes[0] == codes[1] {
+		t.Fatalf("Expected 2 distinct codes, got %v %v", codes, err)
+	}
+
+	if err := invites.Admit(protocol.RegisterRequest{Username: "alice", InviteCode: codes[0]}); err != nil {
+		t.Fatalf("Error admitting with invite: %v", err)
+	}
+	if err := invites.Admit(protocol.RegisterRequest{Username: "bob", InviteCode: codes[0]}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("Expected used invite to fail with ErrInvalidInvite, got %v", err)
+	}
+	if err := invites.Admit(protocol.RegisterRequest{Username: "bob", InviteCode: "unknown"}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("Expected unknown invite to fail with ErrInvalidInvite, got %v", err)
+	}
+
+	list, err := invites.List()
+	if err != nil {
+		t.Fatalf("Error listing invites: %v", err)
+	}
+	used := 0
+	for _, invite := range list {
+		if invite.UsedBy != "" {
+			used++
+		}
+	}
+	if len(list) != 2 || used != 1 {
+		t.Errorf("Expected 2 invites with 1 used, got %+v", list)
+	}
+}
+
+func TestInvitesRelease(t *testing.T) {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer bdb.Close()
+
+	invites := NewInvitesBBolt(bdb)
+	codes, err := invites.Issue(1)
+	if err != nil {
+		t.Fatalf("Error issuing invite: %v", err)
+	}
+
+	alice := protocol.RegisterRequest{Username: "alice", InviteCode: codes[0]}
+	if err := invites.Admit(alice); err != nil {
+		t.Fatalf("Error admitting with invite: %v", err)
+	}
+
+	// only the registration which used the code releases it
+	if err := invites.Release(protocol.RegisterRequest{Username: "bob", InviteCode: codes[0]}); err != nil {
+		t.Fatalf("Error releasing invite: %v", err)
+	}
+	if err := invites.Admit(protocol.RegisterRequest{Username: "bob", InviteCode: codes[0]}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("Expected invite used by alice to stay used, got %v", err)
+	}
+
+	if err := invites.Release(alice); err != nil {
+		t.Fatalf("Error releasing invite: %v", err)
+	}
+	if err := invites.Admit(protocol.RegisterRequest{Username: "bob", InviteCode: codes[0]}); err != nil {
+		t.Errorf("Expected released invite to be usable again, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package registration
+
+import (
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+)
+
+var (
+	ErrInvalidInvite      = errors.New("invite code is invalid or already used")
+	ErrInvalidProofOfWork = errors.New("proof of work is invalid")
+)
+
+// Policy decides which registrations are accepted
+type Policy interface {
+	// Info is advertised in ServerInfo, so clients know what to send with registration request
+	Info() protocol.RegistrationPolicy
+
+	// Admit registration request with verified signature of new account, username should be checked
+	// to be free by the caller, because admission may consume an invite code
+	Admit(req protocol.RegisterRequest) error
+	// Release admission of request whose account was not created, e.g. invite code is usable again
+	Release(req protocol.RegisterRequest) error
+}
+
+type open struct{}
+
+// NewOpen admits every registration, it should be combined with rate limiting of /register
+func NewOpen() Policy {
+	return open{}
+}
+
+func (open) Info() protocol.RegistrationPolicy {
+	return protocol.RegistrationPolicy{Mode: protocol.RegistrationOpen}
+}
+
+func (open) Admit(protocol.RegisterRequest) error {
+	return nil
+}
+
+func (open) Release(protocol.RegisterRequest) error {
+	return nil
+}
+
+type proofOfWork struct {
+	difficulty int
+}
+
+// NewProofOfWork admits registrations with hashcash style proof of work of difficulty leading zero bits,
+// every extra bit doubles the work. Proofs are bound to username, so they can't be reused.
+func NewProofOfWork(difficulty int) Policy {
+	return proofOfWork{difficulty: difficulty}
+}
+
+func (p proofOfWork) Info() protocol.RegistrationPolicy {
+	return protocol.RegistrationPolicy{
+		Mode:       protocol.RegistrationProofOfWork,
+		Difficulty: p.difficulty,
+	}
+}
+
+func (p proofOfWork) Admit(req protocol.RegisterRequest) error {
+	if !protocol.CheckProofOfWork(req.Username, req.PublicKey, req.ProofOfWork, p.difficulty) {
+		return ErrInvalidProofOfWork
+	}
+	return nil
+}
+
+// Release does nothing, proof is bound to username and may be sent again
+func (p proofOfWork) Release(protocol.RegisterRequest) error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+type reportsBBolt struct {
+	bdb *bbolt.DB
+}
+
+var _ Reports = &reportsBBolt{}
+
+// NewReportsBBolt stores reports as JSON in "reports" bucket by uuid v7, so they are listed oldest first
+func NewReportsBBolt(bdb *bbolt.DB) Reports {
+	return &reportsBBolt{
+		bdb: bdb,
+	}
+}
+
+func (r *reportsBBolt) Add(report protocol.Report) (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		panic(err)
+	}
+
+	report.ID = id.String()
+	report.CreatedAt = time.Now().Unix()
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+
+	err = r.bdb.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("reports"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(report.ID), data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return report.ID, nil
+}
+
+func (r *reportsBBolt) Get(id string) (protocol.Report, error) {
+	var report protocol.Report
+	err := r.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("reports"))
+		if bucket == nil {
+			return ErrReportNotFound
+		}
+
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrReportNotFound
+		}
+
+		return json.Unmarshal(data, &report)
+	})
+	return report, err
+}
+
+func (r *reportsBBolt) List(includeResolved bool) ([]protocol.Report, error) {
+	reports := make([]protocol.Report, 0)
+	err := r.bdb.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("reports"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var report protocol.Report
+			if err := json.Unmarshal(v, &report); err != nil {
+				return fmt.Errorf("failed to decode report %s: %w", k, err)
+			}
+			if report.ResolvedAt == 0 || includeResolved {
+				reports = append(reports, report)
+			}
+			return nil
+		})
+	})
+	return reports, err
+}
+
+func (r *reportsBBolt) Resolve(id, resolution string) error {
+	return r.bdb.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("reports"))
+		if bucket == nil {
+			return ErrReportNotFound
+		}
+
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrReportNotFound
+		}
+
+		var report protocol.Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			return err
+		}
+
+		report.ResolvedAt = time.Now().Unix()
+		report.Resolution = resolution
+
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+}
@@ -0,0 +1,45 @@
+package reports
+
+import (
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func TestReportsQueue(t *testing.T) {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "storage.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer bdb.Close()
+
+	r := NewReportsBBolt(bdb)
+
+	first, err := r.Add(protocol.Report{Reporter: "bob", Reported: "alice", Reason: "spam"})
+	if err != nil {
+		t.Fatalf("Error adding report: %v", err)
+	}
+	second, err := r.Add(protocol.Report{Reporter: "carol", Reported: "alice", Reason: "abuse"})
+	if err != nil {
+		t.Fatalf("Error adding report: %v", err)
+	}
+
+	if err := r.Resolve(first, "suspended alice"); err != nil {
+		t.Fatalf("Error resolving report: %v", err)
+	}
+	if err := r.Resolve("unknown", ""); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("Expected ErrReportNotFound, got %v", err)
+	}
+
+	open, err := r.List(false)
+	if err != nil || len(open) != 1 || open[0].ID != second {
+		t.Errorf("Expected only the second report open, got %+v %v", open, err)
+	}
+
+	all, err := r.List(true)
+	if err != nil || len(all) != 2 || all[0].ID != first || all[0].Resolution != "suspended alice" {
+		t.Errorf("Expected both reports oldest first, got %+v %v", all, err)
+	}
+}
@@ -0,0 +1,22 @@
+package reports
+
+import (
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+)
+
+var ErrReportNotFound = errors.New("report not found")
+
+// Reports is moderation queue of abuse reports, reports are kept after they are resolved
+type Reports interface {
+	// Add report, ID and CreatedAt are assigned by the store
+	Add(report protocol.Report) (string, error)
+
+	Get(id string) (protocol.Report, error)
+
+	// List reports oldest first, resolved ones only if includeResolved
+	List(includeResolved bool) ([]protocol.Report, error)
+
+	// Resolve report with admin note about the action taken
+	Resolve(id, resolution string) error
+}
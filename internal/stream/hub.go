@@ -0,0 +1,196 @@
+package stream
+
+import (
+	"github.com/soul-ua/server/pkg/protocol"
+	"sync"
+	"time"
+)
+
+// bufferSize of every subscription, envelopes are dropped when a slow client falls this far behind
+const bufferSize = 32
+
+// Hub routes ephemeral envelopes to open streams, nothing is stored, so envelopes for users
+// without open streams are dropped. Hub also remembers who is online and when they were last seen.
+type Hub struct {
+	mu       sync.Mutex
+	subs     map[string]map[*Subscription]struct{} // by username
+	watchers map[string]map[*Subscription]struct{} // by watched username
+	lastSeen map[string]time.Time
+	closed   bool
+}
+
+// Subscription is one open stream, C is closed when subscription is closed or hub shuts down
+type Subscription struct {
+	Username string
+	C        chan *protocol.Envelope
+
+	hub   *Hub
+	watch []string
+	done  bool // guarded by hub.mu
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs:     make(map[string]map[*Subscription]struct{}),
+		watchers: make(map[string]map[*Subscription]struct{}),
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// Subscribe opens stream of username watching presence of watch, online is true if it is the first stream of the user
+func (h *Hub) Subscribe(username string, watch []string) (sub *Subscription, online bool) {
+	sub = &Subscription{
+		Username: username,
+		C:        make(chan *protocol.Envelope, bufferSize),
+		hub:      h,
+		watch:    watch,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		sub.done = true
+		close(sub.C)
+		return sub, false
+	}
+
+	if h.subs[username] == nil {
+		h.subs[username] = make(map[*Subscription]struct{})
+		online = true
+	}
+	h.subs[username][sub] = struct{}{}
+
+	for _, watched := range watch {
+		if h.watchers[watched] == nil {
+			h.watchers[watched] = make(map[*Subscription]struct{})
+		}
+		h.watchers[watched][sub] = struct{}{}
+	}
+
+	return sub, online
+}
+
+// Close subscription, offline is true if it was the last stream of the user
+func (s *Subscription) Close() (offline bool) {
+	h := s.hub
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s.done {
+		return false
+	}
+	h.remove(s)
+
+	if len(h.subs[s.Username]) > 0 {
+		return false
+	}
+	delete(h.subs, s.Username)
+	h.lastSeen[s.Username] = time.Now()
+
+	return true
+}
+
+// remove must be called with mu held
+func (h *Hub) remove(s *Subscription) {
+	s.done = true
+	close(s.C)
+
+	delete(h.subs[s.Username], s)
+	for _, watched := range s.watch {
+		delete(h.watchers[watched], s)
+		if len(h.watchers[watched]) == 0 {
+			delete(h.watchers, watched)
+		}
+	}
+}
+
+// Publish envelope to every open stream of username, returns false if it was delivered to none
+func (h *Hub) Publish(username string, envelope *protocol.Envelope) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delivered := false
+	for sub := range h.subs[username] {
+		if h.send(sub, envelope) {
+			delivered = true
+		}
+	}
+	return delivered
+}
+
+// PublishTo one stream, e.g. presence encrypted for the watcher
+func (h *Hub) PublishTo(sub *Subscription, envelope *protocol.Envelope) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.send(sub, envelope)
+}
+
+// send must be called with mu held, it never blocks
+func (h *Hub) send(sub *Subscription, envelope *protocol.Envelope) bool {
+	if sub.done {
+		return false
+	}
+
+	select {
+	case sub.C <- envelope:
+		return true
+	default:
+		return false
+	}
+}
+
+// Watchers of username presence
+func (h *Hub) Watchers(username string) []*Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	watchers := make([]*Subscription, 0, len(h.watchers[username]))
+	for sub := range h.watchers[username] {
+		watchers = append(watchers, sub)
+	}
+	return watchers
+}
+
+// Presence of username as known to this hub, last seen is zero if user was not seen since start
+func (h *Hub) Presence(username string) (online bool, lastSeen time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subs[username]) > 0, h.lastSeen[username]
+}
+
+// Disconnect closes every open stream of username, e.g. when account is suspended
+func (h *Hub) Disconnect(username string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs[username]) == 0 {
+		return
+	}
+	for sub := range h.subs[username] {
+		h.remove(sub)
+	}
+	delete(h.subs, username)
+	h.lastSeen[username] = time.Now()
+}
+
+// Close every open stream, new subscriptions are closed right away
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+	h.subs = make(map[string]map[*Subscription]struct{})
+}
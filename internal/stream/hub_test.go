@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"github.com/soul-ua/server/pkg/protocol"
+	"testing"
+)
+
+func TestHubPublish(t *testing.T) {
+	h := NewHub()
+
+	if h.Publish("bob", &protocol.Envelope{}) {
+		t.Errorf("Envelope should not be delivered without open streams")
+	}
+
+	first, online := h.Subscribe("bob", nil)
+	if !online {
+		t.Errorf("First stream should bring user online")
+	}
+	second, online := h.Subscribe("bob", nil)
+	if online {
+		t.Errorf("Second stream should not bring user online again")
+	}
+
+	if !h.Publish("bob", &protocol.Envelope{ID: "1"}) {
+		t.Errorf("Envelope should be delivered to open streams")
+	}
+	for _, sub := range []*Subscription{first, second} {
+		if e := <-sub.C; e.ID != "1" {
+			t.Errorf("Unexpected envelope %+v", e)
+		}
+	}
+
+	if first.Close() {
+		t.Errorf("User with open stream should not go offline")
+	}
+	if !second.Close() {
+		t.Errorf("Closing the last stream should bring user offline")
+	}
+
+	online, lastSeen := h.Presence("bob")
+	if online || lastSeen.IsZero() {
+		t.Errorf("Expected offline with last seen, got %v, %v", online, lastSeen)
+	}
+}
+
+func TestHubDropsWhenFull(t *testing.T) {
+	h := NewHub()
+	sub, _ := h.Subscribe("bob", nil)
+
+	for i := 0; i < bufferSize; i++ {
+		h.Publish("bob", &protocol.Envelope{})
+	}
+	if h.Publish("bob", &protocol.Envelope{}) {
+		t.Errorf("Envelope should be dropped when stream buffer is full")
+	}
+
+	h.Close()
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != bufferSize {
+		t.Errorf("Expected %d buffered envelopes, got %d", bufferSize, n)
+	}
+}
+
+func TestHubWatchers(t *testing.T) {
+	h := NewHub()
+	watcher, _ := h.Subscribe("alice", []string{"bob"})
+
+	if watchers := h.Watchers("bob"); len(watchers) != 1 || watchers[0] != watcher {
+		t.Errorf("Expected alice to watch bob, got %v", watchers)
+	}
+
+	watcher.Close()
+	if watchers := h.Watchers("bob"); len(watchers) != 0 {
+		t.Errorf("Expected no watchers after close, got %v", watchers)
+	}
+}
+
+func TestHubDisconnect(t *testing.T) {
+	h := NewHub()
+	first, _ := h.Subscribe("alice", nil)
+	second, _ := h.Subscribe("alice", nil)
+	other, _ := h.Subscribe("bob", nil)
+
+	h.Disconnect("alice")
+
+	for _, sub := range []*Subscription{first, second} {
+		if _, ok := <-sub.C; ok {
+			t.Error("Expected stream of disconnected user to be closed")
+		}
+	}
+	if online, lastSeen := h.Presence("alice"); online || lastSeen.IsZero() {
+		t.Errorf("Expected alice to be offline and seen, got %v %v", online, lastSeen)
+	}
+	if !h.Publish("bob", &protocol.Envelope{}) || !other.Close() {
+		t.Error("Expected other users to stay connected")
+	}
+}
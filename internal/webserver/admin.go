@@ -0,0 +1,244 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/internal/accounts"
+	"github.com/soul-ua/server/internal/inbox"
+	"github.com/soul-ua/server/internal/reports"
+	"github.com/soul-ua/server/pkg/protocol"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxAdminAccounts in one page of POST /admin/accounts
+const maxAdminAccounts = 1000
+
+// adminRequestWindow is how far Time of admin request may be from server clock, used times are remembered for it
+const adminRequestWindow = 5 * time.Minute
+
+// decodeVerifyAdminRequest checks request is signed with admin key, unlike user requests failures are
+// answered here with ErrorCodeForbidden, false is returned if request should not be handled
+func (w *Webserver) decodeVerifyAdminRequest(wr http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if w.adminKey == "" {
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeForbidden, "admin key is not configured")
+		return false
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := protocol.VerifySignArmor(data, r.Header.Get("PGP-Signature"), w.adminKey); err != nil {
+		log.Println("admin request with invalid signature:", err)
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeForbidden, "request is not signed with admin key")
+		return false
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, err.Error())
+		return false
+	}
+
+	var signed struct {
+		Time int64 `json:"time"`
+	}
+	_ = json.Unmarshal(data, &signed)
+	if !w.useAdminTime(signed.Time, time.Now()) {
+		log.Println("admin request is stale or replayed:", signed.Time)
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeForbidden, "request time is stale or already used")
+		return false
+	}
+
+	return true
+}
+
+// useAdminTime accepts unix milliseconds t once if it is within adminRequestWindow of now
+func (w *Webserver) useAdminTime(t int64, now time.Time) bool {
+	requestTime := time.UnixMilli(t)
+	if requestTime.Before(now.Add(-adminRequestWindow)) || requestTime.After(now.Add(adminRequestWindow)) {
+		return false
+	}
+
+	w.adminMu.Lock()
+	defer w.adminMu.Unlock()
+
+	for used := range w.adminTimes {
+		if time.UnixMilli(used).Before(now.Add(-adminRequestWindow)) {
+			delete(w.adminTimes, used)
+		}
+	}
+
+	if _, ok := w.adminTimes[t]; ok {
+		return false
+	}
+	w.adminTimes[t] = struct{}{}
+	return true
+}
+
+// checkRecipient answers with ErrorCodeNotFound if username is not registered or not active,
+// suspended and deleted accounts look the same to other users
+func (w *Webserver) checkRecipient(wr http.ResponseWriter, username string) bool {
+	state, err := w.accounts.GetAccountState(username)
+	if errors.Is(err, accounts.ErrorAccountNotFound) || (err == nil && state != protocol.AccountActive) {
+		w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, "account not found")
+		return false
+	} else if err != nil {
+		panic(err)
+	}
+	return true
+}
+
+// checkAdminAccount answers with ErrorCodeNotFound if account can't be moderated, it returns account state
+func (w *Webserver) checkAdminAccount(wr http.ResponseWriter, username string) (string, bool) {
+	if username == "server" {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "server account can't be moderated")
+		return "", false
+	}
+
+	state, err := w.accounts.GetAccountState(username)
+	if errors.Is(err, accounts.ErrorAccountNotFound) {
+		w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, "account not found")
+		return "", false
+	} else if err != nil {
+		panic(err)
+	}
+	return state, true
+}
+
+func (w *Webserver) handleAdminListAccounts(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.AdminListAccountsRequest
+	if !w.decodeVerifyAdminRequest(wr, r, &req) {
+		return
+	}
+
+	if req.Limit <= 0 || req.Limit > maxAdminAccounts {
+		req.Limit = maxAdminAccounts
+	}
+
+	list, err := w.accounts.ListAccounts(req.After, req.Limit)
+	if err != nil {
+		panic(err)
+	}
+
+	res, _ := json.Marshal(protocol.AdminListAccountsResponse{
+		Accounts: list,
+	})
+	_ = w.sendSign(res, wr)
+}
+
+// handleAdminSuspend rejects further requests of the account and closes its streams,
+// envelopes keep arriving to its inbox until it is reinstated or deleted
+func (w *Webserver) handleAdminSuspend(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.AdminAccountRequest
+	if !w.decodeVerifyAdminRequest(wr, r, &req) {
+		return
+	}
+
+	state, ok := w.checkAdminAccount(wr, req.Username)
+	if !ok {
+		return
+	}
+	if state != protocol.AccountActive {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "account is "+state)
+		return
+	}
+
+	log.Printf("[admin] suspend %s", req.Username)
+	if err := w.accounts.SetAccountState(req.Username, protocol.AccountSuspended); err != nil {
+		panic(err)
+	}
+	w.hub.Disconnect(req.Username)
+
+	w.resolveReport(wr, req.Report, "suspended "+req.Username)
+}
+
+func (w *Webserver) handleAdminReinstate(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.AdminAccountRequest
+	if !w.decodeVerifyAdminRequest(wr, r, &req) {
+		return
+	}
+
+	state, ok := w.checkAdminAccount(wr, req.Username)
+	if !ok {
+		return
+	}
+	if state != protocol.AccountSuspended {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "account is "+state)
+		return
+	}
+
+	log.Printf("[admin] reinstate %s", req.Username)
+	if err := w.accounts.SetAccountState(req.Username, protocol.AccountActive); err != nil {
+		panic(err)
+	}
+
+	w.resolveReport(wr, req.Report, "reinstated "+req.Username)
+}
+
+// handleAdminDelete removes account data, inbox and prekeys, username stays taken.
+// Chat memberships and messages posted to chats are kept, deleted account can't read them anymore.
+func (w *Webserver) handleAdminDelete(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.AdminAccountRequest
+	if !w.decodeVerifyAdminRequest(wr, r, &req) {
+		return
+	}
+
+	state, ok := w.checkAdminAccount(wr, req.Username)
+	if !ok {
+		return
+	}
+	if state == protocol.AccountDeleted {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "account is already deleted")
+		return
+	}
+
+	log.Printf("[admin] delete %s", req.Username)
+	if err := w.deleteAccount(req.Username); err != nil {
+		panic(err)
+	}
+
+	w.resolveReport(wr, req.Report, "deleted "+req.Username)
+}
+
+func (w *Webserver) deleteAccount(username string) error {
+	if err := w.accounts.DeleteAccount(username); err != nil {
+		return fmt.Errorf("failed to delete account: %w", err)
+	}
+	w.hub.Disconnect(username)
+
+	if err := w.prekeys.Delete(username); err != nil {
+		return fmt.Errorf("failed to delete prekeys: %w", err)
+	}
+
+	withAttachments, err := inbox.Remove(username)
+	if err != nil {
+		return fmt.Errorf("failed to remove inbox: %w", err)
+	}
+	for _, envelopeID := range withAttachments {
+		if err := w.blobs.Release(envelopeID); err != nil {
+			return fmt.Errorf("failed to release attachments of %s: %w", envelopeID, err)
+		}
+	}
+
+	return nil
+}
+
+// resolveReport if reportID is given and answers admin request
+func (w *Webserver) resolveReport(wr http.ResponseWriter, reportID, resolution string) {
+	if reportID != "" {
+		err := w.reports.Resolve(reportID, resolution)
+		if errors.Is(err, reports.ErrReportNotFound) {
+			w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, "action is done, but report is not found")
+			return
+		} else if err != nil {
+			panic(err)
+		}
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
@@ -0,0 +1,228 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/internal/blobs"
+	"github.com/soul-ua/server/internal/inbox"
+	"github.com/soul-ua/server/pkg/protocol"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxAttachmentSize is blob size limit advertised in ServerInfo
+	DefaultMaxAttachmentSize = 100 << 20
+	// blobChunkSize is the largest chunk accepted by PUT /blobs/upload/{id}, it leaves room
+	// under maxPayloadSize so chunks are never rejected by limitRequest
+	blobChunkSize = 512 << 10
+	// maxAttachments in one envelope or chat message
+	maxAttachments = 32
+)
+
+func (w *Webserver) handleCreateUpload(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.CreateUploadRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if req.Size <= 0 || req.Size > w.maxAttachmentSize {
+		w.sendSignError(wr, http.StatusRequestEntityTooLarge, protocol.ErrorCodePayloadTooLarge,
+			fmt.Sprintf("attachment size should be between 1 and %d bytes", w.maxAttachmentSize))
+		return
+	}
+
+	upload, err := w.blobs.CreateUpload(username, req.Size)
+	if errors.Is(err, blobs.ErrQuotaExceeded) {
+		w.sendSignError(wr, http.StatusInsufficientStorage, protocol.ErrorCodeQuotaExceeded, "attachment storage quota exceeded")
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("[%s] upload %s of %d bytes created", username, upload.ID, upload.Size)
+
+	w.sendUpload(wr, upload)
+}
+
+// handleUploadChunk accepts signed chunk as the request body, offset query parameter should match upload offset
+func (w *Webserver) handleUploadChunk(wr http.ResponseWriter, r *http.Request) {
+	username, chunk, err := w.verifyUserRequest(r)
+	if err != nil {
+		panic(err)
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "offset should be a number")
+		return
+	}
+
+	if len(chunk) == 0 || len(chunk) > blobChunkSize {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest,
+			fmt.Sprintf("chunk should be between 1 and %d bytes", blobChunkSize))
+		return
+	}
+
+	upload, ok := w.ownUpload(wr, username, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	upload, err = w.blobs.WriteChunk(upload.ID, offset, chunk)
+	switch {
+	case errors.Is(err, blobs.ErrOffsetMismatch):
+		w.sendSignError(wr, http.StatusConflict, protocol.ErrorCodeUploadOffset,
+			fmt.Sprintf("upload is at offset %d", upload.Offset))
+		return
+	case errors.Is(err, blobs.ErrChunkTooLarge):
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, err.Error())
+		return
+	case err != nil:
+		panic(err)
+	}
+
+	if upload.BlobID != "" {
+		log.Printf("[%s] upload %s completed as blob %s", username, upload.ID, upload.BlobID)
+	}
+
+	w.sendUpload(wr, upload)
+}
+
+func (w *Webserver) handleUploadStatus(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.UploadStatusRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	upload, ok := w.ownUpload(wr, username, req.UploadID)
+	if !ok {
+		return
+	}
+
+	w.sendUpload(wr, upload)
+}
+
+// handleDownloadBlob streams raw blob, it is not signed by the server as clients check it against blob ID
+func (w *Webserver) handleDownloadBlob(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.DownloadBlobRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	blob, err := w.blobs.Open(req.ID, username)
+	if errors.Is(err, blobs.ErrBlobNotFound) {
+		w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, "blob not found")
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer blob.Close()
+
+	log.Printf("[%s] download blob %s", username, req.ID)
+
+	wr.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(wr, r, "", time.Time{}, blob)
+}
+
+// ownUpload sends an error and returns false if upload doesn't exist or belongs to another user
+func (w *Webserver) ownUpload(wr http.ResponseWriter, username, uploadID string) (blobs.Upload, bool) {
+	upload, err := w.blobs.GetUpload(uploadID)
+	if errors.Is(err, blobs.ErrUploadNotFound) {
+		w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, "upload not found")
+		return blobs.Upload{}, false
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	// same response as for unknown upload, upload IDs of other users are not confirmed
+	if upload.Owner != username {
+		w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, "upload not found")
+		return blobs.Upload{}, false
+	}
+
+	return upload, true
+}
+
+func (w *Webserver) sendUpload(wr http.ResponseWriter, upload blobs.Upload) {
+	res, _ := json.Marshal(protocol.UploadResponse{
+		UploadID:  upload.ID,
+		Size:      upload.Size,
+		Offset:    upload.Offset,
+		ChunkSize: blobChunkSize,
+		BlobID:    upload.BlobID,
+	})
+	_ = w.sendSign(res, wr)
+}
+
+// checkAttachments sends an error and returns false if blobs referenced by envelope or chat message don't exist
+func (w *Webserver) checkAttachments(wr http.ResponseWriter, blobIDs []string) bool {
+	if len(blobIDs) > 0 && !w.hasFeature(protocol.FeatureAttachments) {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "attachments feature is disabled")
+		return false
+	}
+
+	if len(blobIDs) > maxAttachments {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest,
+			fmt.Sprintf("at most %d attachments can be sent at once", maxAttachments))
+		return false
+	}
+
+	err := w.blobs.Check(blobIDs)
+	if errors.Is(err, blobs.ErrBlobNotFound) {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeNotFound, err.Error())
+		return false
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	return true
+}
+
+func (w *Webserver) handleAccountInfo(wr http.ResponseWriter, r *http.Request) {
+	username, _, err := w.verifyUserRequest(r)
+	if err != nil {
+		panic(err)
+	}
+
+	used, quota, err := w.blobs.Usage(username)
+	if err != nil {
+		panic(err)
+	}
+
+	inbx, err := inbox.NewInbox(username)
+	if err != nil {
+		panic(err)
+	}
+	defer inbx.Close()
+
+	inboxUsage, err := inbx.Usage()
+	if err != nil {
+		panic(err)
+	}
+
+	res, _ := json.Marshal(protocol.AccountInfo{
+		Username: username,
+		Storage: protocol.StorageUsage{
+			Used:  used,
+			Quota: quota,
+		},
+		Inbox: protocol.InboxUsage{
+			Count:    inboxUsage.Count,
+			Bytes:    inboxUsage.Bytes,
+			MaxCount: w.inboxLimits.MaxCount,
+			MaxBytes: w.inboxLimits.MaxBytes,
+		},
+	})
+	_ = w.sendSign(res, wr)
+}
@@ -0,0 +1,121 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/soul-ua/server/internal/accounts"
+	"github.com/soul-ua/server/pkg/protocol"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxBlocklistSize keeps blocklist lookups and responses small
+const maxBlocklistSize = 10000
+
+func (w *Webserver) handleBlock(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.BlockRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if req.Username == username || req.Username == "server" {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "this user can't be blocked")
+		return
+	}
+
+	if _, err := w.accounts.GetUserPublicKeyArmor(req.Username); errors.Is(err, accounts.ErrorAccountNotFound) {
+		w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, "account not found")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	blocklist, err := w.accounts.GetBlocklist(username)
+	if err != nil {
+		panic(err)
+	}
+	if len(blocklist) >= maxBlocklistSize {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "blocklist is full")
+		return
+	}
+
+	log.Printf("[%s] block %s mute=%v", username, req.Username, req.Mute)
+	err = w.accounts.Block(username, protocol.BlockedUser{
+		Username: req.Username,
+		Mute:     req.Mute,
+		Since:    time.Now().Unix(),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleUnblock(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.UnblockRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("[%s] unblock %s", username, req.Username)
+	if err := w.accounts.Unblock(username, req.Username); err != nil {
+		panic(err)
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleGetBlocklist(wr http.ResponseWriter, r *http.Request) {
+	username, _, err := w.verifyUserRequest(r)
+	if err != nil {
+		panic(err)
+	}
+
+	blocklist, err := w.accounts.GetBlocklist(username)
+	if err != nil {
+		panic(err)
+	}
+
+	res, _ := json.Marshal(protocol.BlocklistResponse{
+		Blocked: blocklist,
+	})
+	_ = w.sendSign(res, wr)
+}
+
+// checkBlocked returns false if recipient blocked or muted sender, blocked senders get ErrorCodeBlocked,
+// muted ones get what drop sends, so they see the usual response
+func (w *Webserver) checkBlocked(wr http.ResponseWriter, recipient, sender string, drop func()) bool {
+	blocked, ok, err := w.accounts.GetBlock(recipient, sender)
+	if err != nil {
+		panic(err)
+	}
+	if !ok {
+		return true
+	}
+
+	if blocked.Mute {
+		drop()
+	} else {
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeBlocked, "recipient doesn't accept this from you")
+	}
+	return false
+}
+
+// dropEnvelope responds like the envelope was stored, ephemeral ones are reported as not delivered
+func (w *Webserver) dropEnvelope(wr http.ResponseWriter) {
+	envelopeID, err := uuid.NewV7()
+	if err != nil {
+		panic(err)
+	}
+
+	res, _ := json.Marshal(protocol.SendResponse{
+		Success: true,
+		ID:      envelopeID.String(),
+	})
+	_ = w.sendSign(res, wr)
+}
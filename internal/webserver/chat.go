@@ -0,0 +1,547 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/soul-ua/server/internal/chat"
+	"github.com/soul-ua/server/pkg/protocol"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (w *Webserver) handleCreateChat(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.CreateChatRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	log.Println(username, "create chat", req)
+	c, err := w.chats.Create(username, req.Name, req.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+
+	res, _ := json.Marshal(protocol.CreateChatResponse{
+		ChatID: c.GetChatID(),
+	})
+	_ = w.sendSign(res, wr)
+}
+
+func (w *Webserver) handleArchiveChat(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.ArchiveChatRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, ok := w.openChatAsOwner(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	if err := c.SetArchived(true); err != nil {
+		panic(err)
+	}
+
+	name, err := c.GetName()
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("[%s] archive chat %s", username, req.ChatID)
+	w.notifyChatMembers(c, "ChatArchived", protocol.ChatArchived{
+		ChatID: req.ChatID,
+		Name:   name,
+		By:     username,
+	})
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleUnarchiveChat(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.UnarchiveChatRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, ok := w.openChatAsOwner(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	if err := c.SetArchived(false); err != nil {
+		panic(err)
+	}
+
+	name, err := c.GetName()
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("[%s] unarchive chat %s", username, req.ChatID)
+	w.notifyChatMembers(c, "ChatUnarchived", protocol.ChatUnarchived{
+		ChatID: req.ChatID,
+		Name:   name,
+		By:     username,
+	})
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleDeleteChat(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.DeleteChatRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, ok := w.openChatAsOwner(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+
+	name, err := c.GetName()
+	if err != nil {
+		_ = c.Close()
+		panic(err)
+	}
+
+	members, err := c.Members()
+	_ = c.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("[%s] delete chat %s", username, req.ChatID)
+	if err := w.chats.Delete(req.ChatID); err != nil {
+		panic(err)
+	}
+
+	for member := range members {
+		err := w.notifyUser(member, "ChatDeleted", protocol.ChatDeleted{
+			ChatID: req.ChatID,
+			Name:   name,
+			By:     username,
+		})
+		if err != nil {
+			log.Printf("failed to notify %s about deleted chat %s: %s", member, req.ChatID, err)
+		}
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleGetChatInfo(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.GetChatInfoRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, _, ok := w.openChatAsMember(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	name, err := c.GetName()
+	if err != nil {
+		panic(err)
+	}
+
+	archived, err := c.IsArchived()
+	if err != nil {
+		panic(err)
+	}
+
+	settings, err := c.GetSettings()
+	if err != nil {
+		panic(err)
+	}
+
+	members, err := c.Members()
+	if err != nil {
+		panic(err)
+	}
+
+	res := protocol.GetChatInfoResponse{
+		ChatID:   req.ChatID,
+		Name:     name,
+		Archived: archived,
+		Settings: protocol.ChatSettings{
+			PostPolicy:   string(settings.PostPolicy),
+			InvitePolicy: string(settings.InvitePolicy),
+			SlowMode:     int64(settings.SlowMode / time.Second),
+			Pinned:       settings.Pinned,
+		},
+		Members: make(map[string]string, len(members)),
+	}
+	for member, role := range members {
+		res.Members[member] = string(role)
+	}
+
+	data, _ := json.Marshal(res)
+	_ = w.sendSign(data, wr)
+}
+
+func (w *Webserver) handleUpdateChatSettings(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.UpdateChatSettingsRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	postPolicy, invitePolicy := chat.Policy(req.PostPolicy), chat.Policy(req.InvitePolicy)
+	if !postPolicy.Valid() || !invitePolicy.Valid() || req.SlowMode < 0 {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "invalid chat settings")
+		return
+	}
+
+	c, _, ok := w.openChatAsAdmin(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	log.Printf("[%s] update chat %s settings: %+v", username, req.ChatID, req)
+	if err := c.UpdateSettings(postPolicy, invitePolicy, time.Duration(req.SlowMode)*time.Second); err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handlePinChatMessage(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.PinChatMessageRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, _, ok := w.openChatAsAdmin(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	if err := c.Pin(req.MessageID); err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleUnpinChatMessage(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.UnpinChatMessageRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, _, ok := w.openChatAsAdmin(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	if err := c.Unpin(req.MessageID); err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleAddChatMember(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.AddChatMemberRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if !w.checkRecipient(wr, req.Username) {
+		return
+	}
+
+	// chat membership is visible, so muted users can't be added silently and are rejected too
+	if blocked, ok, err := w.accounts.GetBlock(req.Username, username); err != nil {
+		panic(err)
+	} else if ok {
+		log.Printf("[%s] add %s to chat %s rejected, mute=%v", username, req.Username, req.ChatID, blocked.Mute)
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeBlocked, "user doesn't accept chat invites from you")
+		return
+	}
+
+	c, _, ok := w.openChatAsMember(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	if err := c.Invite(username, req.Username); err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	name, err := c.GetName()
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("[%s] add %s to chat %s", username, req.Username, req.ChatID)
+	err = w.notifyUserFor(req.Username, username, "ChatMemberAdded", protocol.ChatMemberAdded{
+		ChatID: req.ChatID,
+		Name:   name,
+		By:     username,
+	})
+	if err != nil {
+		log.Printf("failed to notify %s about chat %s: %s", req.Username, req.ChatID, err)
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleRemoveChatMember(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.RemoveChatMemberRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, role, ok := w.openChatAsMember(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	if req.Username != username {
+		targetRole, err := c.GetMemberRole(req.Username)
+		if err != nil {
+			panic(err)
+		}
+
+		// admins can remove members, only owner can remove admins
+		if !role.IsAdmin() || (targetRole.IsAdmin() && role != chat.RoleOwner) {
+			w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeForbidden, "not allowed to remove this member")
+			return
+		}
+	}
+
+	log.Printf("[%s] remove %s from chat %s", username, req.Username, req.ChatID)
+	if err := c.RemoveMember(req.Username); err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleSetChatMemberRole(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.SetChatMemberRoleRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	role := chat.Role(req.Role)
+	if role != chat.RoleAdmin && role != chat.RoleMember {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "role should be admin or member")
+		return
+	}
+
+	c, ok := w.openChatAsOwner(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	log.Printf("[%s] set %s role in chat %s to %s", username, req.Username, req.ChatID, role)
+	if err := c.SetMemberRole(req.Username, role); err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleChatSend(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.ChatSendRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if !w.checkPadded(wr, req.Payload) || !w.checkTTL(wr, req.TTL) || !w.checkAttachments(wr, req.Attachments) {
+		return
+	}
+
+	c, err := w.chats.Open(req.ChatID)
+	if err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+	defer c.Close()
+
+	messageID, err := c.PostExpiringMessage(username, req.PayloadType, req.Payload, time.Now(), w.messageTTL(req.TTL))
+	if err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	// chat history is read by members at any time, so blobs are kept until they expire
+	if len(req.Attachments) > 0 {
+		if err := w.blobs.Reference(messageID, "", req.Attachments); err != nil {
+			panic(err)
+		}
+	}
+
+	res, _ := json.Marshal(protocol.ChatSendResponse{
+		MessageID: messageID,
+	})
+	_ = w.sendSign(res, wr)
+}
+
+func (w *Webserver) handleGetChatMessages(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.GetChatMessagesRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, _, ok := w.openChatAsMember(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	messages, err := c.ReadMessages(req.SinceID, 100)
+	if err != nil {
+		panic(err)
+	}
+
+	res, _ := json.Marshal(protocol.GetChatMessagesResponse{
+		Messages: messages,
+	})
+	_ = w.sendSign(res, wr)
+}
+
+// openChatAsOwner responds with error and returns false if chat does not exist or user is not its owner
+func (w *Webserver) openChatAsOwner(wr http.ResponseWriter, chatID, username string) (*chat.Chat, bool) {
+	c, role, ok := w.openChatAsMember(wr, chatID, username)
+	if !ok {
+		return nil, false
+	}
+
+	if role != chat.RoleOwner {
+		_ = c.Close()
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeForbidden, "only chat owner can do this")
+		return nil, false
+	}
+
+	return c, true
+}
+
+// openChatAsAdmin responds with error and returns false if chat does not exist or user is not owner or admin
+func (w *Webserver) openChatAsAdmin(wr http.ResponseWriter, chatID, username string) (*chat.Chat, chat.Role, bool) {
+	c, role, ok := w.openChatAsMember(wr, chatID, username)
+	if !ok {
+		return nil, "", false
+	}
+
+	if !role.IsAdmin() {
+		_ = c.Close()
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeForbidden, "only chat admins can do this")
+		return nil, "", false
+	}
+
+	return c, role, true
+}
+
+// openChatAsMember responds with error and returns false if chat does not exist or user is not its member
+func (w *Webserver) openChatAsMember(wr http.ResponseWriter, chatID, username string) (*chat.Chat, chat.Role, bool) {
+	c, err := w.chats.Open(chatID)
+	if err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return nil, "", false
+	}
+
+	role, err := c.GetMemberRole(username)
+	if err != nil {
+		_ = c.Close()
+		panic(err)
+	}
+
+	if role == "" {
+		_ = c.Close()
+		w.sendChatError(wr, chat.ErrNotMember)
+		return nil, "", false
+	}
+
+	return c, role, true
+}
+
+// sendChatError responds with protocol.Error for chat errors caused by the client, returns false for other errors
+func (w *Webserver) sendChatError(wr http.ResponseWriter, err error) bool {
+	var slowMode *chat.SlowModeError
+	switch {
+	case errors.Is(err, chat.ErrChatNotFound), errors.Is(err, chat.ErrMessageNotFound), errors.Is(err, chat.ErrInviteNotFound):
+		w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, err.Error())
+	case errors.Is(err, chat.ErrNotMember), errors.Is(err, chat.ErrForbidden):
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeForbidden, err.Error())
+	case errors.Is(err, chat.ErrAlreadyMember):
+		w.sendSignError(wr, http.StatusConflict, protocol.ErrorCodeBadRequest, err.Error())
+	case errors.Is(err, chat.ErrInviteRevoked), errors.Is(err, chat.ErrInviteExpired), errors.Is(err, chat.ErrInviteExhausted):
+		w.sendSignError(wr, http.StatusGone, protocol.ErrorCodeInvalidToken, err.Error())
+	case errors.Is(err, chat.ErrArchived):
+		w.sendSignError(wr, http.StatusConflict, protocol.ErrorCodeChatArchived, err.Error())
+	case errors.As(err, &slowMode):
+		wr.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(slowMode.Wait.Seconds()))))
+		w.sendSignError(wr, http.StatusTooManyRequests, protocol.ErrorCodeSlowMode, err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+// notifyChatMembers delivers server payload to every member inbox, failures are logged and skipped
+func (w *Webserver) notifyChatMembers(c *chat.Chat, payloadType string, v interface{}) {
+	members, err := c.Members()
+	if err != nil {
+		log.Printf("failed to read members of chat %s: %s", c.GetChatID(), err)
+		return
+	}
+
+	for member := range members {
+		if err := w.notifyUser(member, payloadType, v); err != nil {
+			log.Printf("failed to notify %s about chat %s: %s", member, c.GetChatID(), err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package webserver
+
+import (
+	"encoding/json"
+	"github.com/soul-ua/server/internal/chat"
+	"github.com/soul-ua/server/pkg/protocol"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultInviteLifetime = 7 * 24 * time.Hour
+	maxInviteLifetime     = 30 * 24 * time.Hour
+)
+
+func (w *Webserver) handleCreateChatInvite(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.CreateChatInviteRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	lifetime := time.Duration(req.ExpiresIn) * time.Second
+	if lifetime == 0 {
+		lifetime = defaultInviteLifetime
+	}
+	if lifetime < 0 || lifetime > maxInviteLifetime || req.MaxUses < 0 {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "invalid invite expiration or max uses")
+		return
+	}
+
+	c, _, ok := w.openChatAsAdmin(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	invite, err := c.CreateInvite(username, time.Now().Add(lifetime), req.MaxUses)
+	if err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	token, err := chat.SignInviteToken(protocol.ChatInviteToken{
+		ChatID:    req.ChatID,
+		InviteID:  invite.ID,
+		ExpiresAt: invite.ExpiresAt,
+	}, w.unlockedPrivateKey)
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("[%s] create invite %s for chat %s", username, invite.ID, req.ChatID)
+	res, _ := json.Marshal(protocol.CreateChatInviteResponse{
+		InviteID:  invite.ID,
+		Token:     token,
+		ExpiresAt: invite.ExpiresAt,
+	})
+	_ = w.sendSign(res, wr)
+}
+
+func (w *Webserver) handleGetChatInvites(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.GetChatInvitesRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, _, ok := w.openChatAsAdmin(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	invites, err := c.Invites()
+	if err != nil {
+		panic(err)
+	}
+
+	res := protocol.GetChatInvitesResponse{
+		Invites: make([]protocol.ChatInvite, len(invites)),
+	}
+	for i, invite := range invites {
+		res.Invites[i] = protocol.ChatInvite{
+			ID:        invite.ID,
+			CreatedBy: invite.CreatedBy,
+			ExpiresAt: invite.ExpiresAt,
+			MaxUses:   invite.MaxUses,
+			Uses:      invite.Uses,
+			Revoked:   invite.Revoked,
+		}
+	}
+
+	data, _ := json.Marshal(res)
+	_ = w.sendSign(data, wr)
+}
+
+func (w *Webserver) handleRevokeChatInvite(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.RevokeChatInviteRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	c, _, ok := w.openChatAsAdmin(wr, req.ChatID, username)
+	if !ok {
+		return
+	}
+	defer c.Close()
+
+	log.Printf("[%s] revoke invite %s for chat %s", username, req.InviteID, req.ChatID)
+	if err := c.RevokeInvite(req.InviteID); err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleJoinChat(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.JoinChatRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	token, err := chat.VerifyInviteToken(req.Token, w.publicKey)
+	if err != nil {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeInvalidToken, err.Error())
+		return
+	}
+
+	c, err := w.chats.Open(token.ChatID)
+	if err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+	defer c.Close()
+
+	if err := c.RedeemInvite(token.InviteID, username, time.Now()); err != nil {
+		if !w.sendChatError(wr, err) {
+			panic(err)
+		}
+		return
+	}
+
+	name, err := c.GetName()
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("[%s] join chat %s with invite %s", username, token.ChatID, token.InviteID)
+	res, _ := json.Marshal(protocol.JoinChatResponse{
+		ChatID: token.ChatID,
+		Name:   name,
+	})
+	_ = w.sendSign(res, wr)
+}
@@ -0,0 +1,115 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/internal/prekeys"
+	"github.com/soul-ua/server/pkg/protocol"
+	"log"
+	"net/http"
+)
+
+const (
+	// maxOneTimePrekeys limits one upload, clients top up after PrekeysLow notification
+	maxOneTimePrekeys = 100
+	// prekeysLowThreshold is number of remaining one-time prekeys when owner gets PrekeysLow
+	prekeysLowThreshold = 10
+
+	x25519KeySize = 32
+)
+
+func (w *Webserver) handleUploadPrekeys(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.UploadPrekeysRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(req.IdentityKey) != x25519KeySize || len(req.SignedPrekey) != x25519KeySize || req.SignedPrekeyID == 0 {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "identity key and signed prekey should be X25519 public keys with non-zero ID")
+		return
+	}
+
+	if len(req.OneTimePrekeys) > maxOneTimePrekeys {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest,
+			fmt.Sprintf("at most %d one-time prekeys can be uploaded at once", maxOneTimePrekeys))
+		return
+	}
+
+	for _, prekey := range req.OneTimePrekeys {
+		if len(prekey.Key) != x25519KeySize || prekey.ID == 0 {
+			w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "one-time prekeys should be X25519 public keys with non-zero ID")
+			return
+		}
+	}
+
+	publicKey, err := w.accounts.GetUserPublicKeyArmor(username)
+	if err != nil {
+		panic(err)
+	}
+
+	// same check as clients do on fetched bundles, so server never hands out bundles which fail it
+	bundle := protocol.PrekeyBundle{
+		Username:     username,
+		IdentityKey:  req.IdentityKey,
+		SignedPrekey: req.SignedPrekey,
+		Signature:    req.Signature,
+	}
+	if err := bundle.Verify(publicKey); err != nil {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "invalid signed prekey signature")
+		return
+	}
+
+	count, err := w.prekeys.Upload(username, req)
+	if errors.Is(err, prekeys.ErrTooManyPrekeys) {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest,
+			fmt.Sprintf("at most %d one-time prekeys can be stored", prekeys.MaxStoredOneTimePrekeys))
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	res, _ := json.Marshal(protocol.UploadPrekeysResponse{
+		OneTimePrekeys: count,
+	})
+	_ = w.sendSign(res, wr)
+}
+
+func (w *Webserver) handleFetchPrekeyBundle(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.FetchPrekeyBundleRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	// every fetch takes one-time prekey of target, so both fetcher and target are limited
+	if !w.allowUser(wr, "/prekeys/bundle", username) || !w.allowTarget(wr, "/prekeys/bundle", req.Username) {
+		return
+	}
+
+	if !w.checkRecipient(wr, req.Username) {
+		return
+	}
+
+	bundle, remaining, err := w.prekeys.Fetch(req.Username)
+	if errors.Is(err, prekeys.ErrNoPrekeys) {
+		w.sendSignError(wr, http.StatusNotFound, protocol.ErrorCodeNotFound, "user has no prekeys")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	// notify once when crossing the threshold and once when out of one-time prekeys
+	if bundle.OneTimePrekeyID != 0 && (remaining == prekeysLowThreshold || remaining == 0) {
+		err := w.notifyUser(req.Username, "PrekeysLow", protocol.PrekeysLow{
+			Remaining: remaining,
+		})
+		if err != nil {
+			log.Println("failed to notify about low prekeys", req.Username, err)
+		}
+	}
+
+	res, _ := json.Marshal(bundle)
+	_ = w.sendSign(res, wr)
+}
@@ -0,0 +1,92 @@
+package webserver
+
+import (
+	"fmt"
+	"github.com/soul-ua/server/internal/ratelimit"
+	"github.com/soul-ua/server/pkg/protocol"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit of one endpoint by client IP, by verified username and by username the request is about
+type RateLimit struct {
+	ByIP     ratelimit.Policy
+	ByUser   ratelimit.Policy
+	ByTarget ratelimit.Policy
+}
+
+// DefaultRateLimits by endpoint path, endpoints which are not listed are not limited.
+// Sends are limited loosely, typing notifications go through /send as well.
+var DefaultRateLimits = map[string]RateLimit{
+	"/register": {
+		ByIP: ratelimit.Policy{Burst: 5, Every: 10 * time.Minute},
+	},
+	"/contact/request": {
+		ByIP:   ratelimit.Policy{Burst: 30, Every: time.Minute},
+		ByUser: ratelimit.Policy{Burst: 10, Every: 6 * time.Minute},
+	},
+	"/send": {
+		ByIP:   ratelimit.Policy{Burst: 300, Every: 200 * time.Millisecond},
+		ByUser: ratelimit.Policy{Burst: 60, Every: time.Second},
+	},
+	"/send/sealed": {
+		ByIP: ratelimit.Policy{Burst: 300, Every: 200 * time.Millisecond},
+	},
+	"/report": {
+		ByUser: ratelimit.Policy{Burst: 20, Every: 3 * time.Minute},
+	},
+	"/prekeys/bundle": {
+		ByIP:     ratelimit.Policy{Burst: 60, Every: time.Second},
+		ByUser:   ratelimit.Policy{Burst: 20, Every: 30 * time.Second},
+		ByTarget: ratelimit.Policy{Burst: 30, Every: time.Minute},
+	},
+}
+
+// rateLimited handler of path by client IP, username is limited by the handler with allowUser
+// once the request is verified, otherwise anyone could drain buckets of others
+func (w *Webserver) rateLimited(path string, next http.HandlerFunc) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if !w.allow(wr, path+" ip:"+clientIP(r), w.rateLimits[path].ByIP) {
+			return
+		}
+		next(wr, r)
+	}
+}
+
+// allowUser sends rate limit error and returns false if verified username is over the limit of path
+func (w *Webserver) allowUser(wr http.ResponseWriter, path, username string) bool {
+	return w.allow(wr, path+" user:"+username, w.rateLimits[path].ByUser)
+}
+
+// allowTarget is allowUser for username the request is about, e.g. owner of fetched prekeys
+func (w *Webserver) allowTarget(wr http.ResponseWriter, path, target string) bool {
+	return w.allow(wr, path+" target:"+target, w.rateLimits[path].ByTarget)
+}
+
+func (w *Webserver) allow(wr http.ResponseWriter, key string, policy ratelimit.Policy) bool {
+	ok, retryAfter := w.limiter.Allow(key, policy, time.Now())
+	if ok {
+		return true
+	}
+
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	wr.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	w.sendError(wr, http.StatusTooManyRequests, &protocol.Error{
+		Code:       protocol.ErrorCodeRateLimited,
+		Message:    fmt.Sprintf("too many requests, retry in %d seconds", seconds),
+		RetryAfter: seconds,
+	})
+	return false
+}
+
+// clientIP is remote address of the connection, proxies in front of the server are not trusted
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
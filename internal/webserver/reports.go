@@ -0,0 +1,94 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/soul-ua/server/internal/inbox"
+	"github.com/soul-ua/server/pkg/protocol"
+	"log"
+	"net/http"
+)
+
+// maxReportReason in bytes
+const maxReportReason = 2000
+
+// handleReport puts reported envelope into moderation queue. Envelope found in reporter inbox is taken
+// from there, so its sender is vouched for by the server; disclosure proves what the sender wrote.
+func (w *Webserver) handleReport(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.ReportRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if !w.allowUser(wr, "/report", username) {
+		return
+	}
+
+	if len(req.Reason) > maxReportReason {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "reason is too long")
+		return
+	}
+
+	envelope, err := protocol.UnpackEnvelopeAs(protocol.ContentTypeCBOR, req.Envelope)
+	if err != nil {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, err.Error())
+		return
+	}
+
+	if envelope.To != username {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "only received envelopes can be reported")
+		return
+	}
+
+	inbx, err := inbox.NewInbox(username)
+	if err != nil {
+		panic(err)
+	}
+	stored, err := inbx.Get(envelope.ID)
+	_ = inbx.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	inInbox := stored != nil && bytes.Equal(stored.Payload, envelope.Payload)
+	if inInbox {
+		envelope = stored
+	}
+
+	report := protocol.Report{
+		Reporter:   username,
+		Reported:   envelope.From,
+		EnvelopeID: envelope.ID,
+		Reason:     req.Reason,
+		Envelope:   req.Envelope,
+		InInbox:    inInbox,
+	}
+
+	if req.Disclosure != nil {
+		senderPublicKey, err := w.accounts.GetUserPublicKeyArmor(envelope.From)
+		if err != nil {
+			w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "sender of disclosed envelope is unknown")
+			return
+		}
+
+		report.Content, err = protocol.OpenDisclosed(envelope, req.Disclosure, senderPublicKey)
+		if err != nil {
+			w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, err.Error())
+			return
+		}
+		report.Verified = true
+	}
+
+	reportID, err := w.reports.Add(report)
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("[%s] reported %s envelope %s verified=%v", username, report.Reported, report.EnvelopeID, report.Verified)
+
+	res, _ := json.Marshal(protocol.ReportResponse{
+		ID:       reportID,
+		Verified: report.Verified,
+	})
+	_ = w.sendSign(res, wr)
+}
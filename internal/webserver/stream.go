@@ -0,0 +1,222 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/soul-ua/server/internal/accounts"
+	"github.com/soul-ua/server/pkg/protocol"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// maxPresenceUsernames limits both watched users of a stream and POST /presence lookups
+const maxPresenceUsernames = 500
+
+// handleStream holds the response open and writes ephemeral envelopes as CBOR sequence until client disconnects
+func (w *Webserver) handleStream(wr http.ResponseWriter, r *http.Request) {
+	if contentType, err := protocol.NegotiateContentType(r.Header.Get("Accept")); err != nil || contentType != protocol.ContentTypeCBOR {
+		w.sendSignError(wr, http.StatusNotAcceptable, protocol.ErrorCodeBadRequest, "stream is only available as "+protocol.ContentTypeCBOR)
+		return
+	}
+
+	var req protocol.StreamRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(req.Presence) > maxPresenceUsernames {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "too many users to watch")
+		return
+	}
+
+	flusher, ok := wr.(http.Flusher)
+	if !ok {
+		panic("response writer does not support flushing")
+	}
+
+	log.Printf("[%s] stream opened", username)
+
+	watch := slices.Clone(req.Presence)
+	slices.Sort(watch)
+	sub, online := w.hub.Subscribe(username, slices.Compact(watch))
+	defer func() {
+		if sub.Close() {
+			w.publishPresence(username)
+		}
+		log.Printf("[%s] stream closed", username)
+	}()
+
+	if online {
+		w.publishPresence(username)
+	}
+
+	wr.Header().Set("Content-Type", protocol.ContentTypeCBOR)
+	wr.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case envelope, ok := <-sub.C:
+			if !ok {
+				// server is shutting down or account was suspended
+				return
+			}
+
+			packed, err := envelope.PackAs(protocol.ContentTypeCBOR)
+			if err != nil {
+				panic(err)
+			}
+			if _, err := wr.Write(packed); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// publishPresence of username to streams watching them, unless username hides presence
+func (w *Webserver) publishPresence(username string) {
+	watchers := w.hub.Watchers(username)
+	if len(watchers) == 0 {
+		return
+	}
+
+	presence, err := w.presenceOf(username)
+	if err != nil {
+		log.Println("failed to get presence of", username, err)
+		return
+	}
+	if !presence.Online && presence.LastSeen == 0 {
+		// hidden, or never seen, nothing to tell
+		return
+	}
+
+	for _, sub := range watchers {
+		envelopeID, err := uuid.NewV7()
+		if err != nil {
+			panic(err)
+		}
+
+		publicKey, err := w.accounts.GetUserPublicKeyArmor(sub.Username)
+		if err != nil {
+			log.Println("failed to get public key of", sub.Username, err)
+			continue
+		}
+
+		payload, err := protocol.EncryptStructSign(presence, publicKey, w.privateKey)
+		if err != nil {
+			log.Println("failed to encrypt presence for", sub.Username, err)
+			continue
+		}
+
+		w.hub.PublishTo(sub, &protocol.Envelope{
+			ID:          envelopeID.String(),
+			From:        "server",
+			To:          sub.Username,
+			PayloadType: "Presence",
+			Payload:     payload,
+			Ephemeral:   true,
+			Time:        time.Now().Unix(),
+		})
+	}
+}
+
+// presenceOf username as visible to others according to their privacy settings
+func (w *Webserver) presenceOf(username string) (protocol.Presence, error) {
+	presence := protocol.Presence{
+		Username: username,
+	}
+
+	// unknown and inactive accounts are hidden
+	state, err := w.accounts.GetAccountState(username)
+	if errors.Is(err, accounts.ErrorAccountNotFound) || (err == nil && state != protocol.AccountActive) {
+		return presence, nil
+	} else if err != nil {
+		return protocol.Presence{}, err
+	}
+
+	settings, err := w.accounts.GetPrivacySettings(username)
+	if err != nil {
+		return protocol.Presence{}, err
+	}
+	if settings.Presence == protocol.PresenceNobody {
+		return presence, nil
+	}
+
+	online, lastSeen := w.hub.Presence(username)
+	presence.Online = online
+	if !lastSeen.IsZero() {
+		presence.LastSeen = lastSeen.Unix()
+	}
+	return presence, nil
+}
+
+func (w *Webserver) handleGetPresence(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.GetPresenceRequest
+	if _, err := w.decodeVerifyUserRequest(r, &req); err != nil {
+		panic(err)
+	}
+
+	if len(req.Usernames) > maxPresenceUsernames {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "too many users requested")
+		return
+	}
+
+	res := protocol.GetPresenceResponse{
+		Presence: make([]protocol.Presence, 0, len(req.Usernames)),
+	}
+	for _, username := range req.Usernames {
+		presence, err := w.presenceOf(username)
+		if err != nil {
+			panic(err)
+		}
+		res.Presence = append(res.Presence, presence)
+	}
+
+	data, _ := json.Marshal(res)
+	_ = w.sendSign(data, wr)
+}
+
+func (w *Webserver) handleSetPrivacy(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.PrivacySettings
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if req.Presence != protocol.PresenceEveryone && req.Presence != protocol.PresenceNobody {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "invalid presence visibility "+req.Presence)
+		return
+	}
+
+	log.Printf("[%s] set presence visibility to %s", username, req.Presence)
+	if err := w.accounts.SetPrivacySettings(username, req); err != nil {
+		panic(err)
+	}
+
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+// sendEphemeral publishes envelope to recipient streams, it is never stored
+func (w *Webserver) sendEphemeral(wr http.ResponseWriter, envelope *protocol.Envelope) {
+	envelopeID, err := uuid.NewV7()
+	if err != nil {
+		panic(err)
+	}
+
+	envelope.ID = envelopeID.String()
+	envelope.Time = time.Now().Unix()
+
+	res, _ := json.Marshal(protocol.SendResponse{
+		Success:   true,
+		ID:        envelope.ID,
+		Delivered: w.hub.Publish(envelope.To, envelope),
+	})
+	_ = w.sendSign(res, wr)
+}
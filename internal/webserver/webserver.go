@@ -1,29 +1,146 @@
 package webserver
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/soul-ua/server/internal/accounts"
+	"github.com/soul-ua/server/internal/blobs"
 	"github.com/soul-ua/server/internal/chat"
 	"github.com/soul-ua/server/internal/inbox"
+	"github.com/soul-ua/server/internal/prekeys"
+	"github.com/soul-ua/server/internal/ratelimit"
+	"github.com/soul-ua/server/internal/registration"
+	"github.com/soul-ua/server/internal/reports"
+	"github.com/soul-ua/server/internal/stream"
 	"github.com/soul-ua/server/pkg/protocol"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// Version of the server, set with -ldflags "-X github.com/soul-ua/server/internal/webserver.Version=..."
+var Version = "0.1.0"
+
+// DefaultMaxPayloadSize is request body limit advertised in ServerInfo
+const DefaultMaxPayloadSize = 1 << 20
+
+// DefaultMaxRetention of undelivered envelopes, longer TTL set by senders is cut to it
+const DefaultMaxRetention = 30 * 24 * time.Hour
+
+// DefaultPadPolicy is advertised in ServerInfo, padding is not required so old clients keep working.
+// Power of two buckets are far enough apart for the server to tell unpadded payloads by size.
+var DefaultPadPolicy = protocol.PadPolicy{
+	Scheme:      protocol.PadSchemePowerOfTwo,
+	MinSize:     512,
+	MaxOverhead: 320,
+}
+
+var supportedProtocolVersions = []int{1, 2}
+
+// maxAckEnvelopes in one request, it is twice the inbox page
+const maxAckEnvelopes = 200
+
+// Config of limits and optional features, see DefaultConfig
+type Config struct {
+	// URL of the server advertised in ServerInfo, empty if unknown
+	URL string
+
+	MaxPayloadSize    int64
+	MaxAttachmentSize int64
+	MaxRetention      time.Duration
+	InboxLimits       inbox.Limits
+	// RateLimits by endpoint path, nil disables rate limiting
+	RateLimits map[string]RateLimit
+	PadPolicy  protocol.PadPolicy
+
+	// AdminKey is armored public key, admin endpoints are disabled without it
+	AdminKey string
+
+	// Optional features, routes of disabled ones are not served and they are not advertised in ServerInfo
+	Chats        bool
+	Attachments  bool
+	Stream       bool
+	SealedSender bool
+	Ratchet      bool
+}
+
+// DefaultConfig is made of package defaults, so changes to them apply
+func DefaultConfig() Config {
+	return Config{
+		MaxPayloadSize:    DefaultMaxPayloadSize,
+		MaxAttachmentSize: DefaultMaxAttachmentSize,
+		MaxRetention:      DefaultMaxRetention,
+		InboxLimits:       inbox.DefaultLimits,
+		RateLimits:        DefaultRateLimits,
+		PadPolicy:         DefaultPadPolicy,
+
+		Chats:        true,
+		Attachments:  true,
+		Stream:       true,
+		SealedSender: true,
+		Ratchet:      true,
+	}
+}
+
+// features advertised in ServerInfo
+func (c Config) features() []string {
+	features := make([]string, 0)
+	for feature, enabled := range map[string]bool{
+		protocol.FeatureChats:        c.Chats,
+		protocol.FeatureSealedSender: c.SealedSender,
+		protocol.FeatureRatchet:      c.Ratchet,
+		protocol.FeatureStream:       c.Stream,
+		protocol.FeatureAttachments:  c.Attachments,
+	} {
+		if enabled {
+			features = append(features, feature)
+		}
+	}
+	slices.Sort(features)
+	return features
+}
+
 type Webserver struct {
 	accounts accounts.Accounts
+	chats    *chat.Store
+	prekeys  prekeys.Prekeys
+	blobs    *blobs.Store
+	hub      *stream.Hub
+	limiter  ratelimit.Limiter
+	httpSrv  *http.Server
+
+	registration registration.Policy
+	reports      reports.Reports
+	adminKey     string // armored public key, admin endpoints are disabled without it
+	adminMu      sync.Mutex
+	adminTimes   map[int64]struct{} // Time of admin requests accepted within adminRequestWindow
+	url          string
+
+	maxPayloadSize    int64
+	maxAttachmentSize int64
+	maxRetention      time.Duration
+	inboxLimits       inbox.Limits
+	rateLimits        map[string]RateLimit
+	padPolicy         protocol.PadPolicy
+	features          []string
 
 	publicKey          string
 	privateKey         string
 	unlockedPrivateKey *crypto.Key
 }
 
-func NewWebserver(accountsUC accounts.Accounts) (*Webserver, error) {
+func NewWebserver(accountsUC accounts.Accounts, chats *chat.Store, prekeysUC prekeys.Prekeys, blobStore *blobs.Store, limiter ratelimit.Limiter, registrationPolicy registration.Policy, reportsUC reports.Reports, config Config) (*Webserver, error) {
 	privateKey, err := accountsUC.GetUserPrivateKeyArmor("server")
 	if err != nil {
 		return nil, fmt.Errorf("failed to read private key: %w", err)
@@ -39,31 +156,217 @@ func NewWebserver(accountsUC accounts.Accounts) (*Webserver, error) {
 		return nil, fmt.Errorf("failed to parse private key: %w", err)
 	}
 
-	return &Webserver{
+	// chunks of uploads should pass limitRequest
+	if config.MaxPayloadSize <= blobChunkSize {
+		return nil, fmt.Errorf("max payload size should be larger than upload chunk of %d bytes", blobChunkSize)
+	}
+
+	adminKey := config.AdminKey
+	if adminKey != "" {
+		adminKeyObj, err := crypto.NewKeyFromArmored(adminKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse admin key: %w", err)
+		}
+		if adminKey, err = adminKeyObj.GetArmoredPublicKey(); err != nil {
+			return nil, fmt.Errorf("failed to read admin public key: %w", err)
+		}
+	}
+
+	w := &Webserver{
 		accounts: accountsUC,
+		chats:    chats,
+		prekeys:  prekeysUC,
+		blobs:    blobStore,
+		hub:      stream.NewHub(),
+		limiter:  limiter,
+
+		registration: registrationPolicy,
+		reports:      reportsUC,
+		adminKey:     adminKey,
+		adminTimes:   make(map[int64]struct{}),
+		url:          config.URL,
+
+		maxPayloadSize:    config.MaxPayloadSize,
+		maxAttachmentSize: config.MaxAttachmentSize,
+		maxRetention:      config.MaxRetention,
+		inboxLimits:       config.InboxLimits,
+		rateLimits:        config.RateLimits,
+		padPolicy:         config.PadPolicy,
+		features:          config.features(),
 
 		publicKey:          publicKey,
 		privateKey:         privateKey,
 		unlockedPrivateKey: privateKeyObj,
-	}, nil
+	}
+	// server is built before Start, so Shutdown called before or during Start is not lost
+	w.httpSrv = &http.Server{
+		Handler: w.limitRequest(w.rejectInactive(w.routes())),
+	}
+
+	return w, nil
 }
 
 func (w *Webserver) Start(addr string) error {
+	return w.StartTLS(addr, "", "")
+}
+
+// StartTLS serves HTTPS with certificate and key files, plain HTTP if they are empty
+func (w *Webserver) StartTLS(addr, certFile, keyFile string) error {
 	fmt.Println("Starting webserver on", addr)
 
-	http.HandleFunc("GET /.soul-server-info", w.handleServerInfo)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if certFile != "" {
+		err = w.httpSrv.ServeTLS(ln, certFile, keyFile)
+	} else {
+		err = w.httpSrv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (w *Webserver) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.soul-server-info", w.handleServerInfo)
+
+	mux.HandleFunc("POST /register", w.rateLimited("/register", w.handleRegister))
+	mux.HandleFunc("POST /contact/request", w.rateLimited("/contact/request", w.handleContactRequest))
+	mux.HandleFunc("POST /inbox", w.handleInboxRequest)
+	mux.HandleFunc("POST /inbox/ack", w.handleAckInbox)
+	mux.HandleFunc("POST /send", w.rateLimited("/send", w.handleSend))
+	mux.HandleFunc("POST /account", w.handleAccountInfo)
+	mux.HandleFunc("POST /presence", w.handleGetPresence)
+	mux.HandleFunc("POST /privacy", w.handleSetPrivacy)
+	mux.HandleFunc("POST /block", w.handleBlock)
+	mux.HandleFunc("POST /unblock", w.handleUnblock)
+	mux.HandleFunc("POST /blocklist", w.handleGetBlocklist)
+	mux.HandleFunc("POST /report", w.handleReport)
+
+	if w.hasFeature(protocol.FeatureSealedSender) {
+		mux.HandleFunc("POST /send/sealed", w.rateLimited("/send/sealed", w.handleSendSealed))
+		mux.HandleFunc("POST /delivery-token", w.handleSetDeliveryToken)
+	}
+
+	if w.hasFeature(protocol.FeatureRatchet) {
+		mux.HandleFunc("POST /prekeys", w.handleUploadPrekeys)
+		mux.HandleFunc("POST /prekeys/bundle", w.rateLimited("/prekeys/bundle", w.handleFetchPrekeyBundle))
+	}
+
+	if w.hasFeature(protocol.FeatureStream) {
+		mux.HandleFunc("POST /stream", w.handleStream)
+	}
+
+	if w.hasFeature(protocol.FeatureAttachments) {
+		mux.HandleFunc("POST /blobs/upload", w.handleCreateUpload)
+		mux.HandleFunc("PUT /blobs/upload/{id}", w.handleUploadChunk)
+		mux.HandleFunc("POST /blobs/upload/status", w.handleUploadStatus)
+		mux.HandleFunc("POST /blobs/download", w.handleDownloadBlob)
+	}
+
+	if w.hasFeature(protocol.FeatureChats) {
+		w.chatRoutes(mux)
+	}
+
+	mux.HandleFunc("POST /admin/accounts", w.handleAdminListAccounts)
+	mux.HandleFunc("POST /admin/accounts/suspend", w.handleAdminSuspend)
+	mux.HandleFunc("POST /admin/accounts/reinstate", w.handleAdminReinstate)
+	mux.HandleFunc("POST /admin/accounts/delete", w.handleAdminDelete)
+
+	return mux
+}
+
+func (w *Webserver) chatRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /chat", w.handleCreateChat)
+	mux.HandleFunc("DELETE /chat", w.handleDeleteChat)
+	mux.HandleFunc("POST /chat/archive", w.handleArchiveChat)
+	mux.HandleFunc("POST /chat/unarchive", w.handleUnarchiveChat)
+	mux.HandleFunc("POST /chat/info", w.handleGetChatInfo)
+	mux.HandleFunc("POST /chat/settings", w.handleUpdateChatSettings)
+	mux.HandleFunc("POST /chat/pin", w.handlePinChatMessage)
+	mux.HandleFunc("POST /chat/unpin", w.handleUnpinChatMessage)
+	mux.HandleFunc("POST /chat/members/add", w.handleAddChatMember)
+	mux.HandleFunc("POST /chat/members/remove", w.handleRemoveChatMember)
+	mux.HandleFunc("POST /chat/members/role", w.handleSetChatMemberRole)
+	mux.HandleFunc("POST /chat/send", w.handleChatSend)
+	mux.HandleFunc("POST /chat/messages", w.handleGetChatMessages)
+	mux.HandleFunc("POST /chat/invites/create", w.handleCreateChatInvite)
+	mux.HandleFunc("POST /chat/invites", w.handleGetChatInvites)
+	mux.HandleFunc("POST /chat/invites/revoke", w.handleRevokeChatInvite)
+	mux.HandleFunc("POST /chat/join", w.handleJoinChat)
+}
 
-	http.HandleFunc("POST /register", w.handleRegister)
-	http.HandleFunc("POST /contact/request", w.handleContactRequest)
-	http.HandleFunc("POST /inbox", w.handleInboxRequest)
-	http.HandleFunc("POST /send", w.handleSend)
+func (w *Webserver) hasFeature(feature string) bool {
+	return slices.Contains(w.features, feature)
+}
 
-	http.HandleFunc("PUT /chat", w.handleCreateChat)
+// Shutdown stops accepting new requests and waits for active ones to finish, open streams are closed
+func (w *Webserver) Shutdown(ctx context.Context) error {
+	w.hub.Close()
+	return w.httpSrv.Shutdown(ctx)
+}
+
+// limitRequest rejects unsupported protocol versions and bodies above maxPayloadSize
+func (w *Webserver) limitRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get(protocol.ProtocolVersionHeader); v != "" {
+			version, err := strconv.Atoi(v)
+			if err != nil || !slices.Contains(supportedProtocolVersions, version) {
+				w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeUnsupportedVersion,
+					fmt.Sprintf("protocol version %s is not supported, server supports %v", v, supportedProtocolVersions))
+				return
+			}
+		}
+
+		if r.ContentLength > w.maxPayloadSize {
+			w.sendSignError(wr, http.StatusRequestEntityTooLarge, protocol.ErrorCodePayloadTooLarge,
+				fmt.Sprintf("request body is larger than %d bytes", w.maxPayloadSize))
+			return
+		}
+		r.Body = http.MaxBytesReader(wr, r.Body, w.maxPayloadSize)
+
+		next.ServeHTTP(wr, r)
+	})
+}
 
-	return http.ListenAndServe(addr, nil)
+// rejectInactive answers requests signed by suspended and deleted accounts with ErrorCodeAccountSuspended
+// and ErrorCodeAccountDeleted. Handlers panic when verifyUserRequest fails, so its typed errors are mapped here
+// once instead of in every handler, other panics are passed on.
+func (w *Webserver) rejectInactive(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+
+			// handlers panic with errors of verifyUserRequest
+			err, _ := v.(error)
+			switch {
+			case errors.Is(err, accounts.ErrAccountSuspended):
+				w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeAccountSuspended, "account is suspended")
+			case errors.Is(err, accounts.ErrAccountDeleted):
+				w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeAccountDeleted, "account is deleted")
+			default:
+				panic(v)
+			}
+		}()
+
+		next.ServeHTTP(wr, r)
+	})
 }
 
 func (w *Webserver) handleInboxRequest(wr http.ResponseWriter, r *http.Request) {
+	contentType, err := protocol.NegotiateContentType(r.Header.Get("Accept"))
+	if err != nil {
+		w.sendSignError(wr, http.StatusNotAcceptable, protocol.ErrorCodeBadRequest, err.Error())
+		return
+	}
+
 	var req protocol.GetInboxRequest
 	username, err := w.decodeVerifyUserRequest(r, &req)
 	if err != nil {
@@ -82,24 +385,54 @@ func (w *Webserver) handleInboxRequest(wr http.ResponseWriter, r *http.Request)
 
 	var sinceID []byte
 	sinceID = nil // todo: implement me
-	err = inbx.Read(sinceID, func(payload []byte) error {
-		envelopes = append(envelopes, payload)
+	err = inbx.Read(sinceID, func(envelope *protocol.Envelope) error {
+		packed, err := envelope.PackAs(contentType)
+		if err != nil {
+			return err
+		}
+		envelopes = append(envelopes, packed)
 		return nil
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	res := bytes.Buffer{}
-	enc := gob.NewEncoder(&res)
-	err = enc.Encode(protocol.GetInboxResponse{
+	res, err := (&protocol.GetInboxResponse{
 		Envelopes: envelopes,
-	})
+	}).PackAs(contentType)
+	if err != nil {
+		panic(err)
+	}
+
+	_ = w.sendSignContentType(res, contentType, wr)
+}
+
+// handleAckInbox deletes envelopes processed by inbox owner
+func (w *Webserver) handleAckInbox(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.AckInboxRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(req.EnvelopeIDs) > maxAckEnvelopes {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, fmt.Sprintf("at most %d envelopes can be acknowledged at once", maxAckEnvelopes))
+		return
+	}
+
+	inbx, err := inbox.NewInbox(username)
+	if err != nil {
+		panic(err)
+	}
+	defer inbx.Close()
+
+	deleted, err := inbx.Delete(req.EnvelopeIDs)
 	if err != nil {
 		panic(err)
 	}
+	log.Printf("[%s] acknowledged %d envelopes", username, deleted)
 
-	_ = w.sendSign(res.Bytes(), wr)
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
 }
 
 func (w *Webserver) handleContactRequest(wr http.ResponseWriter, r *http.Request) {
@@ -114,76 +447,195 @@ func (w *Webserver) handleContactRequest(wr http.ResponseWriter, r *http.Request
 		panic(err)
 	}
 
+	if !w.allowUser(wr, "/contact/request", username) {
+		return
+	}
+
+	dropped := func() { _ = w.sendSign([]byte(`{"success":true}`), wr) }
+	if !w.checkRecipient(wr, req.To) || !w.checkBlocked(wr, req.To, username, dropped) {
+		return
+	}
+
 	userPublicKey, err := w.accounts.GetUserPublicKeyArmor(req.To)
 	if err != nil {
 		panic(err)
 	}
 
-	payload, err := protocol.EncryptStructSign(protocol.ContactRequested{
+	err = w.notifyUserFor(req.To, username, "ContactRequested", protocol.ContactRequested{
 		From:      username,
 		PublicKey: userPublicKey,
-	}, userPublicKey, w.privateKey)
-	if err != nil {
+	})
+	if errors.Is(err, inbox.ErrInboxFull) {
+		w.sendSignError(wr, http.StatusInsufficientStorage, protocol.ErrorCodeInboxFull, "recipient inbox is full")
+		return
+	} else if err != nil {
 		panic(err)
 	}
 
-	envelope := &protocol.Envelope{
-		From:        "server",
-		To:          req.To,
-		PayloadType: "ContactRequested",
-		Payload:     payload,
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
+}
+
+func (w *Webserver) handleSend(wr http.ResponseWriter, r *http.Request) {
+	contentType, err := protocol.NegotiateContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		w.sendSignError(wr, http.StatusUnsupportedMediaType, protocol.ErrorCodeBadRequest, err.Error())
+		return
 	}
 
-	inbx, err := inbox.NewInbox(req.To)
+	username, body, err := w.verifyUserRequest(r)
 	if err != nil {
 		panic(err)
 	}
-	defer inbx.Close()
 
-	if _, err := inbx.Append(envelope); err != nil {
+	envelope, err := protocol.UnpackEnvelopeAs(contentType, body)
+	if err != nil {
 		panic(err)
 	}
 
-	_ = w.sendSign([]byte(`{"success":true}`), wr)
-}
+	if envelope.From != username {
+		panic("username in body and header are not equal")
+	}
 
-func (w *Webserver) handleCreateChat(wr http.ResponseWriter, r *http.Request) {
-	var req protocol.CreateChatRequest
-	username, err := w.decodeVerifyUserRequest(r, &req)
+	if !w.allowUser(wr, "/send", username) {
+		return
+	}
+
+	if !w.checkRecipient(wr, envelope.To) || !w.checkBlocked(wr, envelope.To, username, func() { w.dropEnvelope(wr) }) {
+		return
+	}
+
+	switch envelope.GetVersion() {
+	case protocol.EnvelopeVersion1:
+	case protocol.EnvelopeVersion2:
+		if envelope.PayloadType != "" {
+			w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "payload type should be encrypted in envelope version 2")
+			return
+		}
+	case protocol.EnvelopeVersion4:
+		if !w.hasFeature(protocol.FeatureRatchet) {
+			w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "envelope version 4 is not supported, ratchet feature is disabled")
+			return
+		}
+		if envelope.PayloadType != "" || envelope.Ratchet == nil {
+			w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "envelope version 4 should have ratchet header and no payload type")
+			return
+		}
+	default:
+		// sealed sender envelopes go to /send/sealed
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, fmt.Sprintf("unsupported envelope version %d", envelope.Version))
+		return
+	}
+
+	if !w.checkPadded(wr, envelope.Payload) || !w.checkTTL(wr, envelope.TTL) {
+		return
+	}
+
+	if envelope.Ephemeral {
+		if len(envelope.Attachments) > 0 {
+			w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "ephemeral envelopes can't have attachments")
+			return
+		}
+		w.sendEphemeral(wr, envelope)
+		return
+	}
+
+	if !w.checkAttachments(wr, envelope.Attachments) {
+		return
+	}
+	envelope.ExpiresAt = w.expiresAt(time.Now(), envelope.TTL)
+
+	// todo: check is current user in contact list of to
+	// todo: what if payload encrypted with wrong key? O_o how to check it?
+
+	inbx, err := inbox.NewInbox(envelope.To)
 	if err != nil {
 		panic(err)
 	}
+	defer inbx.Close()
 
-	log.Println(username, "create chat", req)
-	c, err := chat.CreateChat(username, req.Name, req.PublicKey)
-	if err != nil {
+	envelopeID, err := inbx.AppendLimited(envelope, w.inboxLimits)
+	if errors.Is(err, inbox.ErrInboxFull) {
+		w.sendSignError(wr, http.StatusInsufficientStorage, protocol.ErrorCodeInboxFull, "recipient inbox is full")
+		return
+	} else if err != nil {
 		panic(err)
 	}
-	defer c.Close()
 
-	res, _ := json.Marshal(protocol.CreateChatResponse{
-		ChatID: c.GetChatID(),
+	if len(envelope.Attachments) > 0 {
+		if err := w.blobs.Reference(envelope.ID, envelope.To, envelope.Attachments); err != nil {
+			panic(err)
+		}
+	}
+
+	res, _ := json.Marshal(protocol.SendResponse{
+		Success: true,
+		ID:      envelopeID.String(),
 	})
 	_ = w.sendSign(res, wr)
 }
 
-func (w *Webserver) handleSend(wr http.ResponseWriter, r *http.Request) {
-	username, body, err := w.verifyUserRequest(r)
+// handleSendSealed accepts unsigned sealed sender envelopes, delivery is authorized by recipient delivery token
+func (w *Webserver) handleSendSealed(wr http.ResponseWriter, r *http.Request) {
+	contentType, err := protocol.NegotiateContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		w.sendSignError(wr, http.StatusUnsupportedMediaType, protocol.ErrorCodeBadRequest, err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		panic(err)
 	}
 
-	envelope, err := protocol.UnpackEnvelope(body)
+	envelope, err := protocol.UnpackEnvelopeAs(contentType, body)
+	if err != nil {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, err.Error())
+		return
+	}
+
+	if envelope.GetVersion() != protocol.EnvelopeVersion3 || envelope.From != "" || envelope.PayloadType != "" {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "only sealed sender envelopes without from and payload type are accepted")
+		return
+	}
+
+	if envelope.Ephemeral {
+		// streams are tied to authenticated users, sealed sender can't reach them anonymously
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "ephemeral envelopes should be sent to /send")
+		return
+	}
+
+	if !w.checkPadded(wr, envelope.Payload) || !w.checkTTL(wr, envelope.TTL) {
+		return
+	}
+
+	token, err := base64.StdEncoding.DecodeString(r.Header.Get(protocol.DeliveryTokenHeader))
+	if err != nil || len(token) == 0 {
+		w.sendSignError(wr, http.StatusUnauthorized, protocol.ErrorCodeInvalidToken, "delivery token is required")
+		return
+	}
+
+	valid, err := w.accounts.HasDeliveryVerifier(envelope.To, protocol.DeliveryTokenVerifier(token))
 	if err != nil {
 		panic(err)
 	}
 
-	if envelope.From != username {
-		panic("username in body and header are not equal")
+	// deleted accounts have no verifiers, suspended ones keep them for when they are reinstated
+	if state, err := w.accounts.GetAccountState(envelope.To); err != nil && !errors.Is(err, accounts.ErrorAccountNotFound) {
+		panic(err)
+	} else if state != protocol.AccountActive {
+		valid = false
 	}
 
-	// todo: check is current user in contact list of to
-	// todo: what if payload encrypted with wrong key? O_o how to check it?
+	// same response for unknown recipient and wrong token, so tokens can't be used to probe accounts
+	if !valid {
+		w.sendSignError(wr, http.StatusUnauthorized, protocol.ErrorCodeInvalidToken, "invalid delivery token")
+		return
+	}
+
+	if !w.checkAttachments(wr, envelope.Attachments) {
+		return
+	}
+	envelope.ExpiresAt = w.expiresAt(time.Now(), envelope.TTL)
 
 	inbx, err := inbox.NewInbox(envelope.To)
 	if err != nil {
@@ -191,11 +643,55 @@ func (w *Webserver) handleSend(wr http.ResponseWriter, r *http.Request) {
 	}
 	defer inbx.Close()
 
-	if _, err := inbx.Append(envelope); err != nil {
+	envelopeID, err := inbx.AppendLimited(envelope, w.inboxLimits)
+	if errors.Is(err, inbox.ErrInboxFull) {
+		w.sendSignError(wr, http.StatusInsufficientStorage, protocol.ErrorCodeInboxFull, "recipient inbox is full")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	if len(envelope.Attachments) > 0 {
+		if err := w.blobs.Reference(envelope.ID, envelope.To, envelope.Attachments); err != nil {
+			panic(err)
+		}
+	}
+
+	res, _ := json.Marshal(protocol.SendResponse{
+		Success: true,
+		ID:      envelopeID.String(),
+	})
+	_ = w.sendSign(res, wr)
+}
+
+func (w *Webserver) handleSetDeliveryToken(wr http.ResponseWriter, r *http.Request) {
+	var req protocol.SetDeliveryTokenRequest
+	username, err := w.decodeVerifyUserRequest(r, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(req.Verifier) != sha256.Size {
+		w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "verifier should be sha256 of delivery token")
+		return
+	}
+
+	if req.Revoke {
+		log.Printf("[%s] revoke delivery token", username)
+		if err := w.accounts.RevokeDeliveryVerifier(username, req.Verifier); err != nil {
+			panic(err)
+		}
+
+		_ = w.sendSign([]byte(`{"success":true}`), wr)
+		return
+	}
+
+	log.Printf("[%s] set delivery token", username)
+	if err := w.accounts.AddDeliveryVerifier(username, req.Verifier); err != nil {
 		panic(err)
 	}
 
-	wr.WriteHeader(http.StatusOK)
+	_ = w.sendSign([]byte(`{"success":true}`), wr)
 }
 
 func (w *Webserver) handleRegister(wr http.ResponseWriter, r *http.Request) {
@@ -220,10 +716,41 @@ func (w *Webserver) handleRegister(wr http.ResponseWriter, r *http.Request) {
 		panic(fmt.Errorf("failed to verify signature: %w", err))
 	}
 
-	if err := w.accounts.RegisterAccount(registerRequest.Username, registerRequest.PublicKey); err != nil {
+	// checked before admission, so invite codes are not spent on taken usernames
+	_, err = w.accounts.GetUserPublicKeyArmor(username)
+	if err == nil {
+		w.sendSignError(wr, http.StatusConflict, protocol.ErrorCodeUsernameTaken, "username is taken")
+		return
+	} else if !errors.Is(err, accounts.ErrorAccountNotFound) {
+		panic(err)
+	}
+
+	err = w.registration.Admit(registerRequest)
+	if errors.Is(err, registration.ErrInvalidInvite) {
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeInvalidInvite, err.Error())
+		return
+	} else if errors.Is(err, registration.ErrInvalidProofOfWork) {
+		w.sendSignError(wr, http.StatusForbidden, protocol.ErrorCodeInvalidProofOfWork, err.Error())
+		return
+	} else if err != nil {
 		panic(err)
 	}
 
+	err = w.accounts.RegisterAccount(registerRequest.Username, registerRequest.PublicKey)
+	if err != nil {
+		// username was taken meanwhile or storage failed, invite code is not spent on it
+		if err := w.registration.Release(registerRequest); err != nil {
+			log.Printf("[%s] failed to release registration: %s", username, err)
+		}
+	}
+	if errors.Is(err, accounts.ErrAccountAlreadyExists) {
+		w.sendSignError(wr, http.StatusConflict, protocol.ErrorCodeUsernameTaken, "username is taken")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+	log.Printf("[%s] registered", username)
+
 	res, _ := json.Marshal(protocol.RegisterResponse{
 		Success: true,
 	})
@@ -232,13 +759,66 @@ func (w *Webserver) handleRegister(wr http.ResponseWriter, r *http.Request) {
 
 func (w *Webserver) handleServerInfo(wr http.ResponseWriter, r *http.Request) {
 	data, _ := json.Marshal(protocol.ServerInfo{
-		Version:         "0.0.0",
+		Version:         Version,
+		URL:             w.url,
 		PublicKey:       w.publicKey,
 		CurrentUnitTime: crypto.GetUnixTime(),
+
+		ProtocolVersions: supportedProtocolVersions,
+		Encodings:        []string{protocol.ContentTypeCBOR, protocol.ContentTypeGob},
+		MaxPayloadSize:   w.maxPayloadSize,
+		Features:         w.features,
+		PadPolicy:        w.padPolicy,
+		Registration:     w.registration.Info(),
+
+		MaxAttachmentSize: w.maxAttachmentSize,
+		MaxRetention:      int64(w.maxRetention / time.Second),
 	})
 	_ = w.sendSign(data, wr)
 }
 
+// checkPadded sends an error and returns false if pad policy requires padding and payload size doesn't match it
+func (w *Webserver) checkPadded(wr http.ResponseWriter, payload []byte) bool {
+	if w.padPolicy.Accepts(len(payload)) {
+		return true
+	}
+
+	w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeNotPadded,
+		fmt.Sprintf("payload should be padded with %s scheme", w.padPolicy.Scheme))
+	return false
+}
+
+// checkTTL sends an error and returns false if ttl is negative
+func (w *Webserver) checkTTL(wr http.ResponseWriter, ttl int64) bool {
+	if ttl >= 0 {
+		return true
+	}
+
+	w.sendSignError(wr, http.StatusBadRequest, protocol.ErrorCodeBadRequest, "ttl should not be negative")
+	return false
+}
+
+// expiresAt of envelope stored at now with ttl seconds, ttl is cut to max retention and 0 never expires
+func (w *Webserver) expiresAt(now time.Time, ttl int64) int64 {
+	retention := int64(w.maxRetention / time.Second)
+	if ttl == 0 || (retention > 0 && ttl > retention) {
+		ttl = retention
+	}
+	if ttl == 0 {
+		return 0
+	}
+	return now.Unix() + min(ttl, math.MaxInt64-now.Unix())
+}
+
+// messageTTL of chat message with ttl seconds, it is cut to max retention like expiresAt and to what
+// time.Duration holds, 0 keeps message until it is deleted
+func (w *Webserver) messageTTL(ttl int64) time.Duration {
+	if retention := int64(w.maxRetention / time.Second); retention > 0 && ttl > retention {
+		ttl = retention
+	}
+	return time.Duration(min(ttl, int64(math.MaxInt64/time.Second))) * time.Second
+}
+
 func (w *Webserver) decodeVerifyUserRequest(r *http.Request, v interface{}) (string, error) {
 	username, data, err := w.verifyUserRequest(r)
 	if err != nil {
@@ -257,7 +837,7 @@ func (w *Webserver) verifyUserRequest(r *http.Request) (string, []byte, error) {
 	username := r.Header.Get("soul-username")
 	pgpSignatureBase64 := r.Header.Get("PGP-Signature")
 
-	userPublicKeyArmor, err := w.accounts.GetUserPublicKeyArmor(username)
+	userPublicKeyArmor, state, err := w.accounts.GetAccount(username)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to get user public key: %w", err)
 	}
@@ -266,16 +846,88 @@ func (w *Webserver) verifyUserRequest(r *http.Request) (string, []byte, error) {
 		return "", nil, fmt.Errorf("failed to verify signature: %w", err)
 	}
 
+	// state is checked only after signature, so it is not revealed to anyone who knows the username,
+	// errors are answered by rejectInactive
+	switch state {
+	case protocol.AccountSuspended:
+		return "", nil, fmt.Errorf("%w: %s", accounts.ErrAccountSuspended, username)
+	case protocol.AccountDeleted:
+		return "", nil, fmt.Errorf("%w: %s", accounts.ErrAccountDeleted, username)
+	}
+
 	return username, data, nil
 }
 
+// notifyUser encrypts v to user public key, signs it with server key and puts into user inbox,
+// notices fit into a full inbox up to the server notice limits
+func (w *Webserver) notifyUser(username, payloadType string, v interface{}) error {
+	return w.notifyUserFor(username, "server", payloadType, v)
+}
+
+// notifyUserFor puts notice triggered by sender into user inbox, it is charged to sender limits,
+// so users can't use up room the inbox keeps for notices of the server itself
+func (w *Webserver) notifyUserFor(username, sender, payloadType string, v interface{}) error {
+	userPublicKey, err := w.accounts.GetUserPublicKeyArmor(username)
+	if err != nil {
+		return fmt.Errorf("failed to get user public key: %w", err)
+	}
+
+	payload, err := protocol.EncryptStructSign(v, userPublicKey, w.privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+
+	inbx, err := inbox.NewInbox(username)
+	if err != nil {
+		return err
+	}
+	defer inbx.Close()
+
+	_, err = inbx.AppendCharged(&protocol.Envelope{
+		From:        "server",
+		To:          username,
+		PayloadType: payloadType,
+		Payload:     payload,
+		ExpiresAt:   w.expiresAt(time.Now(), 0),
+	}, w.inboxLimits, sender)
+	return err
+}
+
+// sendSignError responds with signed protocol.Error, so client can tell rejection from broken response
+func (w *Webserver) sendSignError(wr http.ResponseWriter, status int, code, message string) {
+	w.sendError(wr, status, &protocol.Error{
+		Code:    code,
+		Message: message,
+	})
+}
+
+func (w *Webserver) sendError(wr http.ResponseWriter, status int, rspErr *protocol.Error) {
+	data, _ := json.Marshal(rspErr)
+
+	pgpSignatureBase64, err := protocol.Sign(data, w.unlockedPrivateKey)
+	if err != nil {
+		http.Error(wr, "failed to sign error", http.StatusInternalServerError)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "application/json")
+	wr.Header().Set("PGP-Signature", pgpSignatureBase64)
+	wr.WriteHeader(status)
+
+	_, _ = wr.Write(data)
+}
+
 func (w *Webserver) sendSign(data []byte, wr http.ResponseWriter) error {
+	return w.sendSignContentType(data, "application/json", wr)
+}
+
+func (w *Webserver) sendSignContentType(data []byte, contentType string, wr http.ResponseWriter) error {
 	pgpSignatureBase64, err := protocol.Sign(data, w.unlockedPrivateKey)
 	if err != nil {
 		return fmt.Errorf("failed to sign data: %w", err)
 	}
 
-	wr.Header().Set("Content-Type", "application/json")
+	wr.Header().Set("Content-Type", contentType)
 	wr.Header().Set("PGP-Signature", pgpSignatureBase64)
 
 	_, _ = wr.Write(data)
@@ -0,0 +1,23 @@
+package protocol
+
+// AccountInfo of POST /account, the request body is empty JSON object
+type AccountInfo struct {
+	Username string       `json:"username"`
+	Storage  StorageUsage `json:"storage"`
+	Inbox    InboxUsage   `json:"inbox"`
+}
+
+// StorageUsage of attachments, uploads in progress are counted in full
+type StorageUsage struct {
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota"`
+}
+
+// InboxUsage of envelopes waiting in the inbox, zero max is unlimited.
+// Acknowledged and expired envelopes are not counted.
+type InboxUsage struct {
+	Count    int64 `json:"count"`
+	Bytes    int64 `json:"bytes"`
+	MaxCount int64 `json:"max_count"`
+	MaxBytes int64 `json:"max_bytes"`
+}
@@ -0,0 +1,38 @@
+package protocol
+
+// Account states, requests signed by suspended accounts are rejected with ErrorCodeAccountSuspended
+// and deleted accounts are gone for everyone, their usernames are never registered again
+const (
+	AccountActive    = "active"
+	AccountSuspended = "suspended"
+	AccountDeleted   = "deleted"
+)
+
+// Admin requests are signed with the admin key configured on the server instead of a user key,
+// soul-username header is not used to authorize them. Time is unix milliseconds when request is made,
+// server rejects requests with Time far from its clock or already used, so signed requests can't be replayed.
+
+// AdminAccount is entry of AdminListAccountsResponse
+type AdminAccount struct {
+	Username string `json:"username"`
+	State    string `json:"state"`
+}
+
+// AdminListAccountsRequest of POST /admin/accounts, accounts are sorted by username and listed after After
+type AdminListAccountsRequest struct {
+	Time  int64  `json:"time"`
+	After string `json:"after,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+type AdminListAccountsResponse struct {
+	Accounts []AdminAccount `json:"accounts"`
+}
+
+// AdminAccountRequest of POST /admin/accounts/suspend, /admin/accounts/reinstate and /admin/accounts/delete.
+// Report is optional ID of report which is resolved with the action.
+type AdminAccountRequest struct {
+	Time     int64  `json:"time"`
+	Username string `json:"username"`
+	Report   string `json:"report,omitempty"`
+}
@@ -0,0 +1,192 @@
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AttachmentChunkSize is size of plaintext chunks sealed separately, so attachments are encrypted
+// and decrypted as streams without holding the whole file in memory
+const AttachmentChunkSize = 64 << 10
+
+var ErrAttachmentCorrupted = errors.New("attachment is corrupted")
+
+// Attachment refers to encrypted blob uploaded with POST /blobs/upload, it is sent inside encrypted
+// payloads only, so the server never sees the key
+type Attachment struct {
+	ID       string `json:"id"`  // blob ID, hex sha256 of the ciphertext
+	Key      []byte `json:"key"` // AES-256-GCM key, see EncryptAttachment
+	Size     int64  `json:"size"`
+	Name     string `json:"name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+}
+
+// CreateUploadRequest starts resumable upload of Size bytes of ciphertext
+type CreateUploadRequest struct {
+	Size int64 `json:"size"`
+}
+
+// UploadStatusRequest is used to find the offset to resume upload from
+type UploadStatusRequest struct {
+	UploadID string `json:"upload_id"`
+}
+
+// UploadResponse of POST /blobs/upload, PUT /blobs/upload/{id} and POST /blobs/upload/status.
+// Chunks are signed request bodies of up to ChunkSize bytes sent to PUT /blobs/upload/{id}?offset=Offset,
+// BlobID is set once all Size bytes are uploaded.
+type UploadResponse struct {
+	UploadID  string `json:"upload_id"`
+	Size      int64  `json:"size"`
+	Offset    int64  `json:"offset"`
+	ChunkSize int64  `json:"chunk_size"`
+	BlobID    string `json:"blob_id,omitempty"`
+}
+
+// DownloadBlobRequest of POST /blobs/download, response is the raw blob and honours Range header
+type DownloadBlobRequest struct {
+	ID string `json:"id"`
+}
+
+// BlobID of ciphertext
+func BlobID(ciphertext []byte) string {
+	sum := sha256.Sum256(ciphertext)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidBlobID reports whether id is hex sha256
+func ValidBlobID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// EncryptedAttachmentSize of plaintext of size bytes, every chunk carries GCM tag and there is at least one chunk
+func EncryptedAttachmentSize(size int64) int64 {
+	chunks := (size + AttachmentChunkSize - 1) / AttachmentChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size + chunks*aesGCMTagSize
+}
+
+const aesGCMTagSize = 16
+
+// EncryptAttachment reads src to the end and writes ciphertext to dst, returns generated key.
+// Chunks are sealed with nonce of chunk counter and final flag, so they can't be reordered or truncated.
+func EncryptAttachment(dst io.Writer, src io.Reader) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate attachment key: %w", err)
+	}
+
+	aead, err := attachmentAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, AttachmentChunkSize)
+	next := make([]byte, AttachmentChunkSize)
+
+	n, err := io.ReadFull(src, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("failed to read attachment: %w", err)
+	}
+
+	for counter := uint64(0); ; counter++ {
+		final := n < AttachmentChunkSize
+		m := 0
+		if !final {
+			// chunk is final if nothing follows it
+			m, err = io.ReadFull(src, next)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("failed to read attachment: %w", err)
+			}
+			final = m == 0
+		}
+
+		sealed := aead.Seal(nil, attachmentNonce(counter, final), buf[:n], nil)
+		if _, err := dst.Write(sealed); err != nil {
+			return nil, fmt.Errorf("failed to write attachment: %w", err)
+		}
+
+		if final {
+			return key, nil
+		}
+		buf, next, n = next, buf, m
+	}
+}
+
+// DecryptAttachment reads ciphertext from src and writes plaintext to dst, chunks are written only after
+// they are authenticated, but dst may be left with a prefix of the attachment if src is corrupted
+func DecryptAttachment(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := attachmentAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	chunkSize := AttachmentChunkSize + aesGCMTagSize
+	buf := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+
+	n, err := io.ReadFull(src, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read attachment: %w", err)
+	}
+
+	for counter := uint64(0); ; counter++ {
+		final := n < chunkSize
+		m := 0
+		if !final {
+			m, err = io.ReadFull(src, next)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("failed to read attachment: %w", err)
+			}
+			final = m == 0
+		}
+
+		plaintext, err := aead.Open(buf[:0], attachmentNonce(counter, final), buf[:n], nil)
+		if err != nil {
+			return fmt.Errorf("%w: chunk %d", ErrAttachmentCorrupted, counter)
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return fmt.Errorf("failed to write attachment: %w", err)
+		}
+
+		if final {
+			return nil
+		}
+		buf, next, n = next, buf, m
+	}
+}
+
+func attachmentAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("attachment key should be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed To create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// attachmentNonce is big endian chunk counter followed by 1 for the final chunk, key is never reused across files
+func attachmentNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAttachmentEncryptDecrypt(t *testing.T) {
+	for _, size := range []int{0, 1, AttachmentChunkSize - 1, AttachmentChunkSize, AttachmentChunkSize*2 + 7} {
+		plaintext := bytes.Repeat([]byte{'a'}, size)
+
+		var ciphertext bytes.Buffer
+		key, err := EncryptAttachment(&ciphertext, bytes.NewReader(plaintext))
+		if err != nil {
+			t.Fatalf("Error encrypting %d bytes: %v", size, err)
+		}
+
+		if got, want := int64(ciphertext.Len()), EncryptedAttachmentSize(int64(size)); got != want {
+			t.Errorf("Ciphertext of %d bytes is %d bytes, want %d", size, got, want)
+		}
+
+		var decrypted bytes.Buffer
+		if err := DecryptAttachment(&decrypted, bytes.NewReader(ciphertext.Bytes()), key); err != nil {
+			t.Fatalf("Error decrypting %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("Decrypted attachment of %d bytes does not match", size)
+		}
+	}
+}
+
+func TestAttachmentTruncated(t *testing.T) {
+	plaintext := bytes.Repeat([]byte{'a'}, AttachmentChunkSize*2)
+
+	var ciphertext bytes.Buffer
+	key, err := EncryptAttachment(&ciphertext, bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatalf("Error encrypting: %v", err)
+	}
+
+	// drop the final chunk, so the first one is read as final
+	truncated := ciphertext.Bytes()[:AttachmentChunkSize+aesGCMTagSize]
+	err = DecryptAttachment(&bytes.Buffer{}, bytes.NewReader(truncated), key)
+	if !errors.Is(err, ErrAttachmentCorrupted) {
+		t.Errorf("Expected ErrAttachmentCorrupted for truncated attachment, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package protocol
+
+// BlockRequest of POST /block. Envelopes, contact requests and chat invites from blocked user are rejected
+// with ErrorCodeBlocked, from muted user they are accepted and silently dropped, so the sender can't tell.
+// Sealed sender envelopes can't be told apart, rotate delivery token to cut blocked users off.
+type BlockRequest struct {
+	Username string `json:"username"`
+	Mute     bool   `json:"mute,omitempty"`
+}
+
+// UnblockRequest of POST /unblock, it unmutes as well
+type UnblockRequest struct {
+	Username string `json:"username"`
+}
+
+// BlockedUser is entry of user blocklist
+type BlockedUser struct {
+	Username string `json:"username"`
+	Mute     bool   `json:"mute,omitempty"`
+	Since    int64  `json:"since"`
+}
+
+// BlocklistResponse of POST /blocklist, the request body is empty JSON object
+type BlocklistResponse struct {
+	Blocked []BlockedUser `json:"blocked"`
+}
@@ -8,3 +8,176 @@ type CreateChatRequest struct {
 type CreateChatResponse struct {
 	ChatID string `json:"chat_id"`
 }
+
+type ArchiveChatRequest struct {
+	ChatID string `json:"chat_id"`
+}
+
+type UnarchiveChatRequest struct {
+	ChatID string `json:"chat_id"`
+}
+
+type DeleteChatRequest struct {
+	ChatID string `json:"chat_id"`
+}
+
+// ChatArchived is server Payload to every chat member, chat is read-only until unarchived
+type ChatArchived struct {
+	ChatID string `json:"chat_id"`
+	Name   string `json:"name"`
+	By     string `json:"by"`
+}
+
+type ChatUnarchived struct {
+	ChatID string `json:"chat_id"`
+	Name   string `json:"name"`
+	By     string `json:"by"`
+}
+
+type ChatDeleted struct {
+	ChatID string `json:"chat_id"`
+	Name   string `json:"name"`
+	By     string `json:"by"`
+}
+
+type ChatSettings struct {
+	PostPolicy   string   `json:"post_policy"`   // everyone or admins
+	InvitePolicy string   `json:"invite_policy"` // everyone or admins
+	SlowMode     int64    `json:"slow_mode"`     // seconds between posts of the same member, 0 is disabled
+	Pinned       []string `json:"pinned,omitempty"`
+}
+
+type GetChatInfoRequest struct {
+	ChatID string `json:"chat_id"`
+}
+
+type GetChatInfoResponse struct {
+	ChatID   string            `json:"chat_id"`
+	Name     string            `json:"name"`
+	Archived bool              `json:"archived"`
+	Settings ChatSettings      `json:"settings"`
+	Members  map[string]string `json:"members"` // username to role: owner, admin or member
+}
+
+type UpdateChatSettingsRequest struct {
+	ChatID       string `json:"chat_id"`
+	PostPolicy   string `json:"post_policy"`
+	InvitePolicy string `json:"invite_policy"`
+	SlowMode     int64  `json:"slow_mode"`
+}
+
+type PinChatMessageRequest struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+}
+
+type UnpinChatMessageRequest struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+}
+
+type AddChatMemberRequest struct {
+	ChatID   string `json:"chat_id"`
+	Username string `json:"username"`
+}
+
+// RemoveChatMemberRequest removes other member (admins only) or leaves the chat if Username is the sender
+type RemoveChatMemberRequest struct {
+	ChatID   string `json:"chat_id"`
+	Username string `json:"username"`
+}
+
+type SetChatMemberRoleRequest struct {
+	ChatID   string `json:"chat_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"` // admin or member
+}
+
+// ChatMemberAdded is server Payload to the new member
+type ChatMemberAdded struct {
+	ChatID string `json:"chat_id"`
+	Name   string `json:"name"`
+	By     string `json:"by"`
+}
+
+// ChatSendRequest Payload should be encrypted with the chat public key
+type ChatSendRequest struct {
+	ChatID      string `json:"chat_id"`
+	PayloadType string `json:"payload_type"`
+	Payload     []byte `json:"payload"`
+
+	Attachments []string `json:"attachments,omitempty"` // IDs of blobs referenced by the payload
+	TTL         int64    `json:"ttl,omitempty"`         // seconds after which the message is deleted from the chat
+}
+
+type ChatSendResponse struct {
+	MessageID string `json:"message_id"`
+}
+
+type GetChatMessagesRequest struct {
+	ChatID  string `json:"chat_id"`
+	SinceID string `json:"since_id"`
+}
+
+type GetChatMessagesResponse struct {
+	Messages []ChatMessage `json:"messages"`
+}
+
+type ChatMessage struct {
+	ID          string `json:"id"`
+	From        string `json:"from"`
+	Time        int64  `json:"time"`
+	PayloadType string `json:"payload_type"`
+	Payload     []byte `json:"payload"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"`
+}
+
+// ChatInviteToken is signed by the server and shared as "base64(json).signature"
+type ChatInviteToken struct {
+	ChatID    string `json:"chat_id"`
+	InviteID  string `json:"invite_id"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type CreateChatInviteRequest struct {
+	ChatID    string `json:"chat_id"`
+	ExpiresIn int64  `json:"expires_in"` // seconds
+	MaxUses   int    `json:"max_uses"`   // 0 is unlimited
+}
+
+type CreateChatInviteResponse struct {
+	InviteID  string `json:"invite_id"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type GetChatInvitesRequest struct {
+	ChatID string `json:"chat_id"`
+}
+
+type GetChatInvitesResponse struct {
+	Invites []ChatInvite `json:"invites"`
+}
+
+type ChatInvite struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	ExpiresAt int64  `json:"expires_at"`
+	MaxUses   int    `json:"max_uses"`
+	Uses      int    `json:"uses"`
+	Revoked   bool   `json:"revoked"`
+}
+
+type RevokeChatInviteRequest struct {
+	ChatID   string `json:"chat_id"`
+	InviteID string `json:"invite_id"`
+}
+
+type JoinChatRequest struct {
+	Token string `json:"token"`
+}
+
+type JoinChatResponse struct {
+	ChatID string `json:"chat_id"`
+	Name   string `json:"name"`
+}
@@ -15,5 +15,6 @@ type ContactRequested struct {
 }
 
 type ContactRequestAccepted struct {
-	PublicKey string `json:"public_key"`
+	PublicKey     string `json:"public_key"`
+	DeliveryToken []byte `json:"delivery_token,omitempty"` // allows to send sealed sender envelopes, see SealSender
 }
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"mime"
+	"strings"
+)
+
+// Content types of binary messages, ContentTypeGob is golang specific and kept only for old clients
+const (
+	ContentTypeCBOR = "application/cbor"
+	ContentTypeGob  = "application/x-gob"
+)
+
+var (
+	cborEncMode cbor.EncMode
+	cborDecMode cbor.DecMode
+)
+
+func init() {
+	var err error
+
+	// deterministic encoding, so the same message is always the same bytes
+	cborEncMode, err = cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	cborDecMode, err = cbor.DecOptions{
+		DupMapKey: cbor.DupMapKeyEnforcedAPF,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// DetectContentType of packed message. CBOR messages are maps (major type 5, 0xa0-0xbf),
+// gob stream starts with a message length which is either below 0x80 or a negated byte count (0xf8-0xff)
+func DetectContentType(packed []byte) string {
+	if len(packed) > 0 && packed[0] >= 0xa0 && packed[0] <= 0xbf {
+		return ContentTypeCBOR
+	}
+	return ContentTypeGob
+}
+
+// NegotiateContentType picks binary content type from Content-Type or Accept header value, gob if nothing is set
+func NegotiateContentType(header string) (string, error) {
+	if header == "" {
+		return ContentTypeGob, nil
+	}
+
+	for _, part := range strings.Split(header, ",") {
+		mediaType, _, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case ContentTypeCBOR, ContentTypeGob:
+			return mediaType, nil
+		case "*/*", "application/*", "application/json", "application/octet-stream":
+			// old clients did not care about content type
+			return ContentTypeGob, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported content type %q", header)
+}
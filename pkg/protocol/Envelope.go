@@ -4,6 +4,22 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"io"
+	"time"
+)
+
+// Envelope versions, zero Version is EnvelopeVersion1
+const (
+	// EnvelopeVersion1 has PayloadType in cleartext
+	EnvelopeVersion1 = 1
+	// EnvelopeVersion2 has empty PayloadType, Payload is encrypted InnerEnvelope, see SealEnvelope
+	EnvelopeVersion2 = 2
+	// EnvelopeVersion3 has empty From and PayloadType, Payload is encrypted SealedContent, see SealSender
+	EnvelopeVersion3 = 3
+	// EnvelopeVersion4 has empty PayloadType, Payload is InnerEnvelope encrypted with double ratchet session
+	// described by Ratchet header, see pkg/ratchet
+	EnvelopeVersion4 = 4
 )
 
 type Envelope struct {
@@ -11,8 +27,15 @@ type Envelope struct {
 	From        string
 	To          string
 	Time        int64
-	PayloadType string // todo: type of Payload can be encrypted as part of Payload
+	PayloadType string // empty for EnvelopeVersion2, type is inside encrypted InnerEnvelope
 	Payload     []byte
+	Version     int
+	Ratchet     *RatchetHeader // only for EnvelopeVersion4
+	Ephemeral   bool           // never stored, delivered only to connected streams, see StreamRequest
+	Attachments []string       // IDs of blobs referenced by the payload, so server keeps them until they are downloaded
+	TTL         int64          // seconds set by sender, envelope is deleted if it is not read in time
+	ExpiresAt   int64          // unix seconds assigned by the server from TTL and its max retention, 0 never expires
+	Padded      bool           // EnvelopeVersion1 Payload plaintext is padded with PadPolicy.Pad, later versions always unpad
 }
 
 type envelopeWire struct {
@@ -22,8 +45,33 @@ type envelopeWire struct {
 	Time        int64
 	PayloadType string
 	Payload     []byte
+	Version     int
+	Ratchet     *RatchetHeader
+	Ephemeral   bool
+	Attachments []string
+	TTL         int64
+	ExpiresAt   int64
+	Padded      bool
 }
 
+// envelopeCBOR follows schema/envelope.cddl, keys are integers to keep it compact
+type envelopeCBOR struct {
+	ID          string         `cbor:"1,keyasint"`
+	From        string         `cbor:"2,keyasint"`
+	To          string         `cbor:"3,keyasint"`
+	Time        int64          `cbor:"4,keyasint"`
+	PayloadType string         `cbor:"5,keyasint"`
+	Payload     []byte         `cbor:"6,keyasint"`
+	Version     int            `cbor:"7,keyasint,omitempty"`
+	Ratchet     *RatchetHeader `cbor:"8,keyasint,omitempty"`
+	Ephemeral   bool           `cbor:"9,keyasint,omitempty"`
+	Attachments []string       `cbor:"10,keyasint,omitempty"`
+	TTL         int64          `cbor:"11,keyasint,omitempty"`
+	ExpiresAt   int64          `cbor:"12,keyasint,omitempty"`
+	Padded      bool           `cbor:"13,keyasint,omitempty"`
+}
+
+// Pack envelope with gob, kept for old clients, use PackAs(ContentTypeCBOR) instead
 func (e *Envelope) Pack() ([]byte, error) {
 	// todo: this is golang specific, should be replaced with protobuf or other common binary format
 	packed := bytes.Buffer{}
@@ -35,6 +83,13 @@ func (e *Envelope) Pack() ([]byte, error) {
 		Time:        e.Time,
 		PayloadType: e.PayloadType,
 		Payload:     e.Payload,
+		Version:     e.Version,
+		Ratchet:     e.Ratchet,
+		Ephemeral:   e.Ephemeral,
+		Attachments: e.Attachments,
+		TTL:         e.TTL,
+		ExpiresAt:   e.ExpiresAt,
+		Padded:      e.Padded,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed To encode wire envelope: %w", err)
@@ -43,7 +98,72 @@ func (e *Envelope) Pack() ([]byte, error) {
 	return packed.Bytes(), nil
 }
 
+// PackAs envelope with one of ContentTypeCBOR or ContentTypeGob
+func (e *Envelope) PackAs(contentType string) ([]byte, error) {
+	switch contentType {
+	case ContentTypeGob:
+		return e.Pack()
+	case ContentTypeCBOR:
+		packed, err := cborEncMode.Marshal(envelopeCBOR{
+			ID:          e.ID,
+			From:        e.From,
+			To:          e.To,
+			Time:        e.Time,
+			PayloadType: e.PayloadType,
+			Payload:     e.Payload,
+			Version:     e.Version,
+			Ratchet:     e.Ratchet,
+			Ephemeral:   e.Ephemeral,
+			Attachments: e.Attachments,
+			TTL:         e.TTL,
+			ExpiresAt:   e.ExpiresAt,
+			Padded:      e.Padded,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed To encode cbor envelope: %w", err)
+		}
+		return packed, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// UnpackEnvelope detects encoding, so envelopes stored before cbor migration are still readable
 func UnpackEnvelope(packed []byte) (*Envelope, error) {
+	return UnpackEnvelopeAs(DetectContentType(packed), packed)
+}
+
+func UnpackEnvelopeAs(contentType string, packed []byte) (*Envelope, error) {
+	switch contentType {
+	case ContentTypeGob:
+		return unpackEnvelopeGob(packed)
+	case ContentTypeCBOR:
+		var wire envelopeCBOR
+		if err := cborDecMode.Unmarshal(packed, &wire); err != nil {
+			return nil, fmt.Errorf("failed To decode cbor envelope: %w", err)
+		}
+
+		return &Envelope{
+			ID:          wire.ID,
+			From:        wire.From,
+			To:          wire.To,
+			Time:        wire.Time,
+			PayloadType: wire.PayloadType,
+			Payload:     wire.Payload,
+			Version:     wire.Version,
+			Ratchet:     wire.Ratchet,
+			Ephemeral:   wire.Ephemeral,
+			Attachments: wire.Attachments,
+			TTL:         wire.TTL,
+			ExpiresAt:   wire.ExpiresAt,
+			Padded:      wire.Padded,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+func unpackEnvelopeGob(packed []byte) (*Envelope, error) {
 	packetBuf := bytes.NewBuffer(packed)
 
 	dec := gob.NewDecoder(packetBuf)
@@ -60,5 +180,60 @@ func UnpackEnvelope(packed []byte) (*Envelope, error) {
 		Time:        wire.Time,
 		PayloadType: wire.PayloadType,
 		Payload:     wire.Payload,
+		Version:     wire.Version,
+		Ratchet:     wire.Ratchet,
+		Ephemeral:   wire.Ephemeral,
+		Attachments: wire.Attachments,
+		TTL:         wire.TTL,
+		ExpiresAt:   wire.ExpiresAt,
+		Padded:      wire.Padded,
+	}, nil
+}
+
+// Expired reports whether envelope should no longer be delivered at now
+func (e *Envelope) Expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && e.ExpiresAt <= now.Unix()
+}
+
+// GetVersion returns EnvelopeVersion1 for envelopes without Version
+func (e *Envelope) GetVersion() int {
+	if e.Version == 0 {
+		return EnvelopeVersion1
+	}
+	return e.Version
+}
+
+// EnvelopeDecoder reads CBOR sequence of envelopes (RFC 8742), as sent by POST /stream
+type EnvelopeDecoder struct {
+	dec *cbor.Decoder
+}
+
+func NewEnvelopeDecoder(r io.Reader) *EnvelopeDecoder {
+	return &EnvelopeDecoder{
+		dec: cborDecMode.NewDecoder(r),
+	}
+}
+
+// Decode next envelope, returns io.EOF at the end of the stream
+func (d *EnvelopeDecoder) Decode() (*Envelope, error) {
+	var wire envelopeCBOR
+	if err := d.dec.Decode(&wire); err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		ID:          wire.ID,
+		From:        wire.From,
+		To:          wire.To,
+		Time:        wire.Time,
+		PayloadType: wire.PayloadType,
+		Payload:     wire.Payload,
+		Version:     wire.Version,
+		Ratchet:     wire.Ratchet,
+		Ephemeral:   wire.Ephemeral,
+		Attachments: wire.Attachments,
+		TTL:         wire.TTL,
+		ExpiresAt:   wire.ExpiresAt,
+		Padded:      wire.Padded,
 	}, nil
 }
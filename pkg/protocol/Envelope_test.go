@@ -0,0 +1,270 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// envelopeCBORGolden is testEnvelope encoded by hand following schema/envelope.cddl:
+//
+//	a6                      map(6)
+//	   01 62 3031           1: "01"
+//	   02 65 616c696365     2: "alice"
+//	   03 63 626f62         3: "bob"
+//	   04 1a 6553f100       4: 1700000000
+//	   05 64 54657874       5: "Text"
+//	   06 43 010203         6: h'010203'
+const envelopeCBORGolden = "a6016230310265616c6963650363626f62041a6553f1000564546578740643010203"
+
+var testEnvelope = Envelope{
+	ID:          "01",
+	From:        "alice",
+	To:          "bob",
+	Time:        1700000000,
+	PayloadType: "Text",
+	Payload:     []byte{1, 2, 3},
+}
+
+func TestEnvelopeCBORMatchesSchema(t *testing.T) {
+	packed, err := testEnvelope.PackAs(ContentTypeCBOR)
+	if err != nil {
+		t.Fatalf("Error packing envelope: %v", err)
+	}
+
+	if hex.EncodeToString(packed) != envelopeCBORGolden {
+		t.Errorf("Packed envelope does not match schema:\n got %x\nwant %s", packed, envelopeCBORGolden)
+	}
+
+	golden, _ := hex.DecodeString(envelopeCBORGolden)
+	envelope, err := UnpackEnvelopeAs(ContentTypeCBOR, golden)
+	if err != nil {
+		t.Fatalf("Error unpacking golden envelope: %v", err)
+	}
+
+	if !reflect.DeepEqual(*envelope, testEnvelope) {
+		t.Errorf("Unpacked envelope %+v, want %+v", *envelope, testEnvelope)
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	for _, contentType := range []string{ContentTypeCBOR, ContentTypeGob} {
+		packed, err := testEnvelope.PackAs(contentType)
+		if err != nil {
+			t.Fatalf("Error packing envelope as %s: %v", contentType, err)
+		}
+
+		if detected := DetectContentType(packed); detected != contentType {
+			t.Errorf("Detected %s, want %s", detected, contentType)
+		}
+
+		envelope, err := UnpackEnvelope(packed)
+		if err != nil {
+			t.Fatalf("Error unpacking envelope as %s: %v", contentType, err)
+		}
+
+		if !reflect.DeepEqual(*envelope, testEnvelope) {
+			t.Errorf("Unpacked %s envelope %+v, want %+v", contentType, *envelope, testEnvelope)
+		}
+	}
+}
+
+func TestGetInboxResponseRoundTrip(t *testing.T) {
+	for _, contentType := range []string{ContentTypeCBOR, ContentTypeGob} {
+		packedEnvelope, err := testEnvelope.PackAs(contentType)
+		if err != nil {
+			t.Fatalf("Error packing envelope as %s: %v", contentType, err)
+		}
+
+		packed, err := (&GetInboxResponse{
+			Envelopes: [][]byte{packedEnvelope, packedEnvelope},
+		}).PackAs(contentType)
+		if err != nil {
+			t.Fatalf("Error packing inbox response as %s: %v", contentType, err)
+		}
+
+		res, err := UnpackGetInboxResponse(contentType, packed)
+		if err != nil {
+			t.Fatalf("Error unpacking inbox response as %s: %v", contentType, err)
+		}
+
+		if len(res.Envelopes) != 2 || !bytes.Equal(res.Envelopes[1], packedEnvelope) {
+			t.Errorf("Unexpected %s inbox response envelopes: %x", contentType, res.Envelopes)
+		}
+	}
+
+	// envelopes are embedded as maps: {1: [envelope]}
+	golden, _ := hex.DecodeString(envelopeCBORGolden)
+	packed, _ := (&GetInboxResponse{Envelopes: [][]byte{golden}}).PackAs(ContentTypeCBOR)
+	if want := "a10181" + envelopeCBORGolden; hex.EncodeToString(packed) != want {
+		t.Errorf("Packed inbox response does not match schema:\n got %x\nwant %s", packed, want)
+	}
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	cases := map[string]string{
+		"":                                ContentTypeGob,
+		"application/json":                ContentTypeGob,
+		"application/cbor":                ContentTypeCBOR,
+		"application/x-gob":               ContentTypeGob,
+		"text/html, application/cbor;q=1": ContentTypeCBOR,
+	}
+
+	for header, want := range cases {
+		got, err := NegotiateContentType(header)
+		if err != nil {
+			t.Errorf("Error negotiating %q: %v", header, err)
+		}
+		if got != want {
+			t.Errorf("Negotiated %q for %q, want %q", got, header, want)
+		}
+	}
+
+	if _, err := NegotiateContentType("text/html"); err == nil {
+		t.Errorf("Expected error for unsupported content type")
+	}
+}
+
+func TestSealOpenEnvelope(t *testing.T) {
+	alicePrivate, alicePublic, _ := GeneratePair("alice", "alice@test")
+	bobPrivate, bobPublic, _ := GeneratePair("bob", "bob@test")
+
+	envelope, err := SealEnvelope("alice", "bob", InnerEnvelope{
+		PayloadType: "Text",
+		Payload:     []byte(`{"text":"hi"}`),
+	}, bobPublic, alicePrivate, PadPolicy{Scheme: PadSchemePadme, MinSize: 256})
+	if err != nil {
+		t.Fatalf("Error sealing envelope: %v", err)
+	}
+
+	if envelope.PayloadType != "" || envelope.GetVersion() != EnvelopeVersion2 {
+		t.Errorf("Sealed envelope leaks payload type or has wrong version: %+v", envelope)
+	}
+
+	packed, _ := envelope.PackAs(ContentTypeCBOR)
+	unpacked, err := UnpackEnvelope(packed)
+	if err != nil {
+		t.Fatalf("Error unpacking envelope: %v", err)
+	}
+
+	inner, err := OpenEnvelope(unpacked, alicePublic, bobPrivate)
+	if err != nil {
+		t.Fatalf("Error opening envelope: %v", err)
+	}
+
+	if inner.PayloadType != "Text" || string(inner.Payload) != `{"text":"hi"}` {
+		t.Errorf("Unexpected inner envelope %+v", inner)
+	}
+
+	if _, err := OpenEnvelope(unpacked, bobPublic, bobPrivate); err == nil {
+		t.Errorf("Expected error opening envelope with wrong sender key")
+	}
+}
+
+func TestOpenEnvelopeV1Padding(t *testing.T) {
+	alicePrivate, alicePublic, _ := GeneratePair("alice", "alice@test")
+	bobPrivate, bobPublic, _ := GeneratePair("bob", "bob@test")
+
+	// raw payload of an old client which happens to start with pad magic and a short length
+	raw := append(append([]byte{}, padMagic...), 0, 0, 0, 1, 'a', 'b', 'c')
+	payload, err := EncryptSign(raw, bobPublic, alicePrivate)
+	if err != nil {
+		t.Fatalf("Error encrypting payload: %v", err)
+	}
+
+	inner, err := OpenEnvelope(&Envelope{From: "alice", To: "bob", PayloadType: "Raw", Payload: payload}, alicePublic, bobPrivate)
+	if err != nil {
+		t.Fatalf("Error opening unpadded envelope: %v", err)
+	}
+	if !bytes.Equal(inner.Payload, raw) {
+		t.Errorf("Expected unpadded payload %q as is, got %q", raw, inner.Payload)
+	}
+
+	policy := PadPolicy{Scheme: PadSchemePadme, MinSize: 256}
+	payload, err = EncryptSign(policy.Pad(raw), bobPublic, alicePrivate)
+	if err != nil {
+		t.Fatalf("Error encrypting payload: %v", err)
+	}
+
+	envelope := &Envelope{From: "alice", To: "bob", PayloadType: "Raw", Payload: payload, Padded: true}
+	packed, _ := envelope.PackAs(ContentTypeCBOR)
+	unpacked, err := UnpackEnvelope(packed)
+	if err != nil {
+		t.Fatalf("Error unpacking envelope: %v", err)
+	}
+
+	inner, err = OpenEnvelope(unpacked, alicePublic, bobPrivate)
+	if err != nil {
+		t.Fatalf("Error opening padded envelope: %v", err)
+	}
+	if !bytes.Equal(inner.Payload, raw) {
+		t.Errorf("Expected padded payload to be stripped to %q, got %q", raw, inner.Payload)
+	}
+}
+
+func TestSealSender(t *testing.T) {
+	alicePrivate, alicePublic, _ := GeneratePair("alice", "alice@test")
+	bobPrivate, bobPublic, _ := GeneratePair("bob", "bob@test")
+	keys := map[string]string{"alice": alicePublic, "bob": bobPublic}
+
+	envelope, err := SealSender("alice", "bob", InnerEnvelope{
+		PayloadType: "Text",
+		Payload:     []byte(`{"text":"hi"}`),
+	}, bobPublic, alicePrivate, PadPolicy{})
+	if err != nil {
+		t.Fatalf("Error sealing sender: %v", err)
+	}
+
+	if envelope.From != "" || envelope.PayloadType != "" || envelope.GetVersion() != EnvelopeVersion3 {
+		t.Errorf("Sealed sender envelope leaks metadata: %+v", envelope)
+	}
+
+	from, inner, err := OpenSealedSender(envelope, "bob", bobPrivate, func(username string) (string, error) {
+		return keys[username], nil
+	})
+	if err != nil {
+		t.Fatalf("Error opening sealed sender envelope: %v", err)
+	}
+
+	if from != "alice" || inner.PayloadType != "Text" {
+		t.Errorf("Unexpected sender %q or inner envelope %+v", from, inner)
+	}
+
+	_, _, err = OpenSealedSender(envelope, "bob", bobPrivate, func(username string) (string, error) {
+		return bobPublic, nil
+	})
+	if err == nil {
+		t.Errorf("Expected error when claimed sender key does not match signature")
+	}
+}
+
+func TestSealSenderRejectsResealed(t *testing.T) {
+	alicePrivate, alicePublic, _ := GeneratePair("alice", "alice@test")
+	bobPrivate, bobPublic, _ := GeneratePair("bob", "bob@test")
+	carolPrivate, carolPublic, _ := GeneratePair("carol", "carol@test")
+	keys := map[string]string{"alice": alicePublic, "bob": bobPublic, "carol": carolPublic}
+
+	envelope, err := SealSender("alice", "bob", InnerEnvelope{PayloadType: "Text", Payload: []byte(`{"text":"hi"}`)}, bobPublic, alicePrivate, PadPolicy{})
+	if err != nil {
+		t.Fatalf("Error sealing sender: %v", err)
+	}
+
+	// bob decrypts the content and seals it unchanged to carol
+	data, err := Decrypt(envelope.Payload, bobPrivate)
+	if err != nil {
+		t.Fatalf("Error decrypting sealed content: %v", err)
+	}
+	data, _ = Unpad(data)
+	payload, err := Encrypt(data, carolPublic)
+	if err != nil {
+		t.Fatalf("Error encrypting sealed content: %v", err)
+	}
+	resealed := &Envelope{To: "carol", Payload: payload, Version: EnvelopeVersion3}
+
+	if _, _, err := OpenSealedSender(resealed, "carol", carolPrivate, func(username string) (string, error) {
+		return keys[username], nil
+	}); err == nil {
+		t.Errorf("Expected content sealed for bob to be rejected by carol")
+	}
+}
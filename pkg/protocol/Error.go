@@ -0,0 +1,73 @@
+package protocol
+
+const (
+	ErrorCodeBadRequest   = "bad_request"
+	ErrorCodeForbidden    = "forbidden"
+	ErrorCodeNotFound     = "not_found"
+	ErrorCodeChatArchived = "chat_archived"
+	ErrorCodeSlowMode     = "slow_mode"
+	ErrorCodeInvalidToken = "invalid_token"
+
+	ErrorCodePayloadTooLarge    = "payload_too_large"
+	ErrorCodeUnsupportedVersion = "unsupported_protocol_version"
+	ErrorCodeNotPadded          = "payload_not_padded"
+	ErrorCodeUploadOffset       = "upload_offset_mismatch"
+	ErrorCodeQuotaExceeded      = "quota_exceeded"
+	ErrorCodeInboxFull          = "inbox_full"
+	ErrorCodeRateLimited        = "rate_limited"
+	ErrorCodeUsernameTaken      = "username_taken"
+	ErrorCodeInvalidInvite      = "invalid_invite"
+	ErrorCodeInvalidProofOfWork = "invalid_proof_of_work"
+	ErrorCodeBlocked            = "blocked"
+	ErrorCodeAccountSuspended   = "account_suspended"
+	ErrorCodeAccountDeleted     = "account_deleted"
+)
+
+var (
+	ErrBadRequest   = &Error{Code: ErrorCodeBadRequest}
+	ErrForbidden    = &Error{Code: ErrorCodeForbidden}
+	ErrNotFound     = &Error{Code: ErrorCodeNotFound}
+	ErrChatArchived = &Error{Code: ErrorCodeChatArchived}
+	ErrSlowMode     = &Error{Code: ErrorCodeSlowMode}
+	ErrInvalidToken = &Error{Code: ErrorCodeInvalidToken}
+
+	ErrPayloadTooLarge    = &Error{Code: ErrorCodePayloadTooLarge}
+	ErrUnsupportedVersion = &Error{Code: ErrorCodeUnsupportedVersion}
+	ErrNotPadded          = &Error{Code: ErrorCodeNotPadded}
+	ErrUploadOffset       = &Error{Code: ErrorCodeUploadOffset}
+	ErrQuotaExceeded      = &Error{Code: ErrorCodeQuotaExceeded}
+	ErrInboxFull          = &Error{Code: ErrorCodeInboxFull}
+	ErrRateLimited        = &Error{Code: ErrorCodeRateLimited}
+	ErrUsernameTaken      = &Error{Code: ErrorCodeUsernameTaken}
+	ErrInvalidInvite      = &Error{Code: ErrorCodeInvalidInvite}
+	ErrInvalidProofOfWork = &Error{Code: ErrorCodeInvalidProofOfWork}
+	ErrBlocked            = &Error{Code: ErrorCodeBlocked}
+	ErrAccountSuspended   = &Error{Code: ErrorCodeAccountSuspended}
+	ErrAccountDeleted     = &Error{Code: ErrorCodeAccountDeleted}
+)
+
+// Error is server response for rejected requests, sent with non 200 status code
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// RetryAfter is set with ErrorCodeRateLimited to seconds before the request can be retried,
+	// the same value is sent in Retry-After header
+	RetryAfter int64 `json:"retry_after,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Is matches errors by Code, so errors.Is(err, ErrNotFound) works for any message
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Code == t.Code
+}
@@ -1,10 +1,79 @@
 package protocol
 
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+)
+
 type GetInboxRequest struct {
 	SinceID string `json:"since_id"`
 }
 
-// GetInboxResponse is server packed envelopes with is gob encoded
+// AckInboxRequest deletes processed envelopes from the inbox, so they no longer count to inbox limits
+type AckInboxRequest struct {
+	EnvelopeIDs []string `json:"envelope_ids"`
+}
+
+// GetInboxResponse is server packed envelopes, the response and every envelope use the same content type
 type GetInboxResponse struct {
 	Envelopes [][]byte
 }
+
+// getInboxResponseCBOR follows schema/envelope.cddl, envelopes are embedded as maps, not as byte strings
+type getInboxResponseCBOR struct {
+	Envelopes []cbor.RawMessage `cbor:"1,keyasint"`
+}
+
+func (r *GetInboxResponse) PackAs(contentType string) ([]byte, error) {
+	switch contentType {
+	case ContentTypeGob:
+		res := bytes.Buffer{}
+		if err := gob.NewEncoder(&res).Encode(r); err != nil {
+			return nil, fmt.Errorf("failed To encode inbox response: %w", err)
+		}
+		return res.Bytes(), nil
+	case ContentTypeCBOR:
+		wire := getInboxResponseCBOR{
+			Envelopes: make([]cbor.RawMessage, len(r.Envelopes)),
+		}
+		for i, envelope := range r.Envelopes {
+			wire.Envelopes[i] = envelope
+		}
+
+		packed, err := cborEncMode.Marshal(wire)
+		if err != nil {
+			return nil, fmt.Errorf("failed To encode cbor inbox response: %w", err)
+		}
+		return packed, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+func UnpackGetInboxResponse(contentType string, packed []byte) (*GetInboxResponse, error) {
+	switch contentType {
+	case ContentTypeGob:
+		var res GetInboxResponse
+		if err := gob.NewDecoder(bytes.NewBuffer(packed)).Decode(&res); err != nil {
+			return nil, fmt.Errorf("failed To decode inbox response: %w", err)
+		}
+		return &res, nil
+	case ContentTypeCBOR:
+		var wire getInboxResponseCBOR
+		if err := cborDecMode.Unmarshal(packed, &wire); err != nil {
+			return nil, fmt.Errorf("failed To decode cbor inbox response: %w", err)
+		}
+
+		res := &GetInboxResponse{
+			Envelopes: make([][]byte, len(wire.Envelopes)),
+		}
+		for i, envelope := range wire.Envelopes {
+			res.Envelopes[i] = envelope
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
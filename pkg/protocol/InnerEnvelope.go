@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// InnerEnvelope is encrypted Payload of EnvelopeVersion2 envelopes, so server only sees routing fields
+type InnerEnvelope struct {
+	PayloadType string            `json:"payload_type"`
+	Payload     []byte            `json:"payload"` // plain, inner envelope is encrypted as a whole
+	SentAt      int64             `json:"sent_at,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// SealEnvelope pads inner according to pad policy, encrypts with recipient public key and signs with sender private key
+func SealEnvelope(from, to string, inner InnerEnvelope, recipientPublicKeyArmor, senderPrivateKeyArmor string, pad PadPolicy) (*Envelope, error) {
+	data, err := json.Marshal(inner)
+	if err != nil {
+		return nil, fmt.Errorf("failed To marshal inner envelope: %w", err)
+	}
+
+	payload, err := EncryptSign(pad.Pad(data), recipientPublicKeyArmor, senderPrivateKeyArmor)
+	if err != nil {
+		return nil, fmt.Errorf("failed To seal envelope: %w", err)
+	}
+
+	return &Envelope{
+		From:    from,
+		To:      to,
+		Payload: payload,
+		Version: EnvelopeVersion2,
+	}, nil
+}
+
+// OpenEnvelope decrypts and verifies EnvelopeVersion1 and EnvelopeVersion2 envelopes,
+// for EnvelopeVersion1 PayloadType is taken from the envelope. Sealed sender envelopes need OpenSealedSender.
+func OpenEnvelope(e *Envelope, senderPublicKeyArmor, recipientPrivateKeyArmor string) (*InnerEnvelope, error) {
+	switch e.GetVersion() {
+	case EnvelopeVersion1:
+		payload, err := DecryptVerify(e.Payload, senderPublicKeyArmor, recipientPrivateKeyArmor)
+		if err != nil {
+			return nil, err
+		}
+
+		// payload of old clients is arbitrary and may start with pad magic, so it is unpadded only if flagged
+		if e.Padded {
+			payload, err = Unpad(payload)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &InnerEnvelope{
+			PayloadType: e.PayloadType,
+			Payload:     payload,
+		}, nil
+	case EnvelopeVersion2:
+		data, err := DecryptVerify(e.Payload, senderPublicKeyArmor, recipientPrivateKeyArmor)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = Unpad(data)
+		if err != nil {
+			return nil, err
+		}
+
+		var inner InnerEnvelope
+		if err := json.Unmarshal(data, &inner); err != nil {
+			return nil, fmt.Errorf("failed To unmarshal inner envelope: %w", err)
+		}
+
+		return &inner, nil
+	default:
+		return nil, fmt.Errorf("unsupported envelope version %d", e.Version)
+	}
+}
@@ -0,0 +1,31 @@
+package protocol
+
+// TextMessage is user to user Payload, ID is generated by the sender so edits, deletions, reactions
+// and replies can refer to the message before server assigns envelope ID
+type TextMessage struct {
+	ID      string `json:"id"`
+	Text    string `json:"text"`
+	ReplyTo string `json:"reply_to,omitempty"` // ID of the message this one replies to
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// ExpiresIn makes the message disappear from recipient conversation this many seconds after it is received
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+}
+
+// MessageEdit replaces text of the message, only its author can edit it
+type MessageEdit struct {
+	MessageID string `json:"message_id"`
+	Text      string `json:"text"`
+}
+
+// MessageDelete leaves a tombstone in place of the message, only its author can delete it
+type MessageDelete struct {
+	MessageID string `json:"message_id"`
+}
+
+// Reaction adds emoji of the sender to the message, or removes it when Remove is set
+type Reaction struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	Remove    bool   `json:"remove,omitempty"`
+}
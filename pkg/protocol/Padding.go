@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Padding schemes, PadSchemeNone leaves plaintext as is
+const (
+	PadSchemeNone = ""
+	// PadSchemePadme rounds size so at most ~12% is wasted and only O(log log size) bits of the size leak
+	PadSchemePadme = "padme"
+	// PadSchemePowerOfTwo rounds size to the next power of two
+	PadSchemePowerOfTwo = "pow2"
+)
+
+// padMagic starts padded plaintext, JSON payloads never start with zero byte, so unpadded ones are told apart
+var padMagic = []byte{0x00, 'S', 'P'}
+
+const padHeaderSize = 3 + 4 // magic and big endian length of data
+
+// PadPolicy is published by the server in ServerInfo, plaintext is padded before encryption
+// and server checks only ciphertext size, so it allows MaxOverhead bytes of encryption overhead above the bucket.
+// The check only catches unpadded payloads when buckets are further apart than MaxOverhead.
+type PadPolicy struct {
+	Scheme      string
+	MinSize     int  // smallest padded size, hides short messages
+	MaxOverhead int  // encryption overhead added to padded size
+	Required    bool // server rejects payloads which are not padded
+}
+
+// Pad data according to the policy, the result is stripped with Unpad
+func (p PadPolicy) Pad(data []byte) []byte {
+	if p.Scheme == PadSchemeNone {
+		return data
+	}
+
+	size := max(padHeaderSize+len(data), p.MinSize)
+	padded := make([]byte, p.bucket(size))
+	copy(padded, padMagic)
+	binary.BigEndian.PutUint32(padded[len(padMagic):], uint32(len(data)))
+	copy(padded[padHeaderSize:], data)
+
+	return padded
+}
+
+// Unpad data padded with any policy, data without padding is returned as is
+func Unpad(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, padMagic) {
+		return data, nil
+	}
+
+	if len(data) < padHeaderSize {
+		return nil, fmt.Errorf("padded data is too short")
+	}
+
+	size := binary.BigEndian.Uint32(data[len(padMagic):])
+	if uint64(size) > uint64(len(data)-padHeaderSize) {
+		return nil, fmt.Errorf("padded data length %d is out of range", size)
+	}
+
+	return data[padHeaderSize : padHeaderSize+int(size)], nil
+}
+
+// Accepts checks if encrypted payload of given size could be produced from padded plaintext
+func (p PadPolicy) Accepts(payloadSize int) bool {
+	if p.Scheme == PadSchemeNone || !p.Required {
+		return true
+	}
+
+	for size := max(payloadSize-p.MaxOverhead, p.MinSize, 1); size <= payloadSize; size++ {
+		if p.bucket(size) == size {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p PadPolicy) bucket(size int) int {
+	switch p.Scheme {
+	case PadSchemePadme:
+		return padme(size)
+	case PadSchemePowerOfTwo:
+		if size <= 1 {
+			return 1
+		}
+		return 1 << bits.Len(uint(size-1))
+	default:
+		return size
+	}
+}
+
+// padme from "Reducing Metadata Leakage from Encrypted Files and Communication with PURBs"
+func padme(size int) int {
+	if size < 2 {
+		return size
+	}
+
+	e := bits.Len(uint(size)) - 1 // floor(log2(size))
+	s := bits.Len(uint(e))        // floor(log2(e)) + 1
+	mask := (1 << (e - s)) - 1
+
+	return (size + mask) &^ mask
+}
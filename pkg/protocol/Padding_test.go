@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPadUnpad(t *testing.T) {
+	for _, scheme := range []string{PadSchemePadme, PadSchemePowerOfTwo} {
+		policy := PadPolicy{Scheme: scheme, MinSize: 256}
+
+		for _, size := range []int{0, 1, 100, 249, 250, 1000, 12345, 1 << 20} {
+			data := bytes.Repeat([]byte{'x'}, size)
+			padded := policy.Pad(data)
+
+			if len(padded) < 256 || policy.bucket(len(padded)) != len(padded) {
+				t.Errorf("%s: padded size %d of %d is not a bucket", scheme, len(padded), size)
+			}
+
+			unpadded, err := Unpad(padded)
+			if err != nil {
+				t.Fatalf("%s: error unpadding %d: %v", scheme, size, err)
+			}
+			if !bytes.Equal(unpadded, data) {
+				t.Errorf("%s: unpadded data of %d does not match", scheme, size)
+			}
+		}
+	}
+
+	plain := []byte(`{"text":"hi"}`)
+	if unpadded, err := Unpad(plain); err != nil || !bytes.Equal(unpadded, plain) {
+		t.Errorf("Unpad should return unpadded data as is, got %q, %v", unpadded, err)
+	}
+}
+
+func TestPadme(t *testing.T) {
+	cases := map[int]int{
+		1:    1,
+		9:    10,
+		100:  104,
+		1000: 1024,
+		1025: 1088,
+	}
+
+	for size, want := range cases {
+		if got := padme(size); got != want {
+			t.Errorf("padme(%d) = %d, want %d", size, got, want)
+		}
+	}
+}
+
+func TestPadPolicyAccepts(t *testing.T) {
+	alicePrivate, _, _ := GeneratePair("alice", "alice@test")
+	_, bobPublic, _ := GeneratePair("bob", "bob@test")
+
+	policy := PadPolicy{Scheme: PadSchemePowerOfTwo, MinSize: 256, MaxOverhead: 320, Required: true}
+
+	padded, err := EncryptSign(policy.Pad(bytes.Repeat([]byte{'x'}, 3000)), bobPublic, alicePrivate)
+	if err != nil {
+		t.Fatalf("Error encrypting: %v", err)
+	}
+	if !policy.Accepts(len(padded)) {
+		t.Errorf("Padded payload of %d bytes should be accepted", len(padded))
+	}
+
+	unpadded, err := EncryptSign(bytes.Repeat([]byte{'x'}, 3000), bobPublic, alicePrivate)
+	if err != nil {
+		t.Fatalf("Error encrypting: %v", err)
+	}
+	if policy.Accepts(len(unpadded)) {
+		t.Errorf("Unpadded payload of %d bytes should be rejected", len(unpadded))
+	}
+}
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var ErrUnknownPayloadType = errors.New("unknown payload type")
+
+var payloadRegistry = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+func init() {
+	RegisterPayload("ContactRequest", ContactRequest{})
+	RegisterPayload("ContactRequested", ContactRequested{})
+	RegisterPayload("ContactRequestAccepted", ContactRequestAccepted{})
+
+	RegisterPayload("ChatArchived", ChatArchived{})
+	RegisterPayload("ChatUnarchived", ChatUnarchived{})
+	RegisterPayload("ChatDeleted", ChatDeleted{})
+	RegisterPayload("ChatMemberAdded", ChatMemberAdded{})
+
+	RegisterPayload("PrekeysLow", PrekeysLow{})
+
+	RegisterPayload("TextMessage", TextMessage{})
+	RegisterPayload("MessageEdit", MessageEdit{})
+	RegisterPayload("MessageDelete", MessageDelete{})
+	RegisterPayload("Reaction", Reaction{})
+	RegisterPayload("Receipt", Receipt{})
+
+	RegisterPayload("Typing", Typing{})
+	RegisterPayload("Presence", Presence{})
+}
+
+// RegisterPayload maps payloadType to type of v, so DecodePayload can return it.
+// Applications can register own types, registering the same name twice panics.
+func RegisterPayload(payloadType string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	payloadRegistry.Lock()
+	defer payloadRegistry.Unlock()
+
+	if _, ok := payloadRegistry.byName[payloadType]; ok {
+		panic(fmt.Sprintf("payload type %q is already registered", payloadType))
+	}
+
+	payloadRegistry.byName[payloadType] = t
+	payloadRegistry.byType[t] = payloadType
+}
+
+// PayloadTypeOf returns name v was registered with
+func PayloadTypeOf(v interface{}) (string, error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	payloadRegistry.RLock()
+	defer payloadRegistry.RUnlock()
+
+	payloadType, ok := payloadRegistry.byType[t]
+	if !ok {
+		return "", fmt.Errorf("%w: %v is not registered", ErrUnknownPayloadType, t)
+	}
+	return payloadType, nil
+}
+
+// DecodePayload unmarshals JSON payload into a value (not a pointer) of the registered type,
+// so callers can use type switch like `case protocol.TextMessage:`
+func DecodePayload(payloadType string, data []byte) (interface{}, error) {
+	payloadRegistry.RLock()
+	t, ok := payloadRegistry.byName[payloadType]
+	payloadRegistry.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownPayloadType, payloadType)
+	}
+
+	v := reflect.New(t)
+	if err := json.Unmarshal(data, v.Interface()); err != nil {
+		return nil, fmt.Errorf("failed To unmarshal %s payload: %w", payloadType, err)
+	}
+
+	return v.Elem().Interface(), nil
+}
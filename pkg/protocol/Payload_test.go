@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodePayload(t *testing.T) {
+	v, err := DecodePayload("TextMessage", []byte(`{"text":"hi"}`))
+	if err != nil {
+		t.Fatalf("Error decoding payload: %v", err)
+	}
+
+	if msg, ok := v.(TextMessage); !ok || msg.Text != "hi" {
+		t.Errorf("Expected TextMessage, got %#v", v)
+	}
+
+	if _, err := DecodePayload("NoSuchType", []byte(`{}`)); !errors.Is(err, ErrUnknownPayloadType) {
+		t.Errorf("Expected ErrUnknownPayloadType, got %v", err)
+	}
+}
+
+func TestPayloadTypeOf(t *testing.T) {
+	for _, v := range []interface{}{ContactRequested{}, &ContactRequested{}} {
+		if payloadType, err := PayloadTypeOf(v); err != nil || payloadType != "ContactRequested" {
+			t.Errorf("Expected ContactRequested for %T, got %q, %v", v, payloadType, err)
+		}
+	}
+
+	if _, err := PayloadTypeOf(struct{}{}); !errors.Is(err, ErrUnknownPayloadType) {
+		t.Errorf("Expected ErrUnknownPayloadType, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic registering the same payload type twice")
+		}
+	}()
+	RegisterPayload("TextMessage", TextMessage{})
+}
@@ -0,0 +1,30 @@
+package protocol
+
+// UploadPrekeysRequest replaces signed prekey and adds one-time prekeys, Signature is the same as in PrekeyBundle.
+// When IdentityKey changes, one-time prekeys uploaded before are dropped.
+type UploadPrekeysRequest struct {
+	IdentityKey    []byte          `json:"identity_key"`
+	SignedPrekeyID uint32          `json:"signed_prekey_id"`
+	SignedPrekey   []byte          `json:"signed_prekey"`
+	Signature      string          `json:"signature"`
+	OneTimePrekeys []OneTimePrekey `json:"one_time_prekeys"`
+}
+
+type OneTimePrekey struct {
+	ID  uint32 `json:"id"` // 0 is reserved for no one-time prekey
+	Key []byte `json:"key"`
+}
+
+type UploadPrekeysResponse struct {
+	OneTimePrekeys int `json:"one_time_prekeys"` // stored on the server after upload
+}
+
+// FetchPrekeyBundleRequest response is PrekeyBundle, every one-time prekey is given out only once
+type FetchPrekeyBundleRequest struct {
+	Username string `json:"username"`
+}
+
+// PrekeysLow is server Payload to the owner when one-time prekeys are running out, client should upload more
+type PrekeysLow struct {
+	Remaining int `json:"remaining"`
+}
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"fmt"
+)
+
+// PrekeyBundle is what initiator needs to start a double ratchet session with Username, see pkg/ratchet.
+// Keys are X25519 public keys, IdentityKey and SignedPrekey are signed with the account OpenPGP key.
+type PrekeyBundle struct {
+	Username        string `json:"username"`
+	IdentityKey     []byte `json:"identity_key"`
+	SignedPrekeyID  uint32 `json:"signed_prekey_id"`
+	SignedPrekey    []byte `json:"signed_prekey"`
+	Signature       string `json:"signature"`                    // base64 signature of SignedData by account key
+	OneTimePrekeyID uint32 `json:"one_time_prekey_id,omitempty"` // 0 if server ran out of one-time prekeys
+	OneTimePrekey   []byte `json:"one_time_prekey,omitempty"`
+}
+
+// SignedData of the bundle is identity key followed by signed prekey
+func (b *PrekeyBundle) SignedData() []byte {
+	return append(append([]byte{}, b.IdentityKey...), b.SignedPrekey...)
+}
+
+// Verify bundle signature with account public key of the bundle owner
+func (b *PrekeyBundle) Verify(publicKeyArmor string) error {
+	if err := VerifySignArmor(b.SignedData(), b.Signature, publicKeyArmor); err != nil {
+		return fmt.Errorf("failed To verify prekey bundle of %s: %w", b.Username, err)
+	}
+	return nil
+}
+
+// RatchetHeader is cleartext part of EnvelopeVersion4 envelopes, server sees it but can't derive keys from it.
+// Handshake fields are set until initiator receives the first reply, so responder can complete X3DH.
+type RatchetHeader struct {
+	DH []byte `cbor:"1,keyasint" json:"dh"` // current ratchet public key of the sender
+	PN uint32 `cbor:"2,keyasint" json:"pn"` // number of messages in previous sending chain
+	N  uint32 `cbor:"3,keyasint" json:"n"`  // message number in current sending chain
+
+	IdentityKey       []byte `cbor:"4,keyasint,omitempty" json:"identity_key,omitempty"`
+	EphemeralKey      []byte `cbor:"5,keyasint,omitempty" json:"ephemeral_key,omitempty"`
+	SignedPrekeyID    uint32 `cbor:"6,keyasint,omitempty" json:"signed_prekey_id,omitempty"`
+	OneTimePrekeyID   uint32 `cbor:"7,keyasint,omitempty" json:"one_time_prekey_id,omitempty"`
+	IdentitySignature string `cbor:"8,keyasint,omitempty" json:"identity_signature,omitempty"` // base64 signature of IdentityKey by account key
+}
+
+// IsHandshake is true for messages which can start a new session on the responder side
+func (h *RatchetHeader) IsHandshake() bool {
+	return len(h.EphemeralKey) > 0
+}
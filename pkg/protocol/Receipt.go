@@ -0,0 +1,14 @@
+package protocol
+
+// Receipt statuses, read implies delivered
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// Receipt is user to user Payload acknowledging envelopes by IDs from SendResponse.
+// Receipts are sealed like other payloads, clients never acknowledge receipts in turn.
+type Receipt struct {
+	EnvelopeIDs []string `json:"envelope_ids"`
+	Status      string   `json:"status"`
+}
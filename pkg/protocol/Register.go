@@ -1,11 +1,85 @@
 package protocol
 
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Registration modes of RegistrationPolicy
+const (
+	RegistrationOpen        = "open"
+	RegistrationInvite      = "invite"        // RegisterRequest.InviteCode issued by server admin is required
+	RegistrationProofOfWork = "proof-of-work" // RegisterRequest.ProofOfWork solved with SolveProofOfWork is required
+)
+
+// RegistrationPolicy is published by the server in ServerInfo, empty Mode is RegistrationOpen
+type RegistrationPolicy struct {
+	Mode       string
+	Difficulty int // leading zero bits of ProofOfWorkHash with RegistrationProofOfWork
+}
+
 type RegisterRequest struct {
-	Username  string `json:"username"`
-	PublicKey string `json:"public_key"`
+	Username    string `json:"username"`
+	PublicKey   string `json:"public_key"`
+	InviteCode  string `json:"invite_code,omitempty"`
+	ProofOfWork uint64 `json:"proof_of_work,omitempty"`
 }
 
 type RegisterResponse struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
 }
+
+// ProofOfWorkHash binds nonce to username and public key, so a proof can't be reused for another account
+// or replayed with another key by someone who has seen the request
+func ProofOfWorkHash(username, publicKey string, nonce uint64) [sha256.Size]byte {
+	keyHash := sha256.Sum256([]byte(publicKey))
+
+	data := make([]byte, 0, len(username)+1+len(keyHash)+8)
+	data = append(data, username...)
+	data = append(data, 0)
+	data = append(data, keyHash[:]...)
+	data = binary.BigEndian.AppendUint64(data, nonce)
+
+	return sha256.Sum256(data)
+}
+
+// CheckProofOfWork is true if hash of nonce has at least difficulty leading zero bits
+func CheckProofOfWork(username, publicKey string, nonce uint64, difficulty int) bool {
+	hash := ProofOfWorkHash(username, publicKey, nonce)
+
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros >= difficulty
+}
+
+// MaxProofOfWorkDifficulty clients agree to solve, it is about 4 billion hashes
+const MaxProofOfWorkDifficulty = 32
+
+// SolveProofOfWork finds nonce for CheckProofOfWork, it takes about 2^difficulty hashes.
+// Difficulty above MaxProofOfWorkDifficulty is rejected, so a server can't make clients spin forever,
+// cancelling ctx stops the search.
+func SolveProofOfWork(ctx context.Context, username, publicKey string, difficulty int) (uint64, error) {
+	if difficulty > MaxProofOfWorkDifficulty {
+		return 0, fmt.Errorf("proof of work difficulty %d is above %d", difficulty, MaxProofOfWorkDifficulty)
+	}
+
+	var nonce uint64
+	for !CheckProofOfWork(username, publicKey, nonce, difficulty) {
+		nonce++
+		if nonce%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return nonce, nil
+}
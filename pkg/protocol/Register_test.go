@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestProofOfWork(t *testing.T) {
+	nonce, err := SolveProofOfWork(context.Background(), "alice", "key", 12)
+	if err != nil {
+		t.Fatalf("Error solving proof of work: %v", err)
+	}
+
+	if !CheckProofOfWork("alice", "key", nonce, 12) {
+		t.Fatal("Solved proof of work should pass the check")
+	}
+	if !CheckProofOfWork("alice", "key", 0, 0) {
+		t.Error("Zero difficulty should always pass")
+	}
+
+	// the chance of another account passing with the same nonce is 2^-12
+	if CheckProofOfWork("bob", "key", nonce, 12) && CheckProofOfWork("alice", "other key", nonce, 12) {
+		t.Error("Proof of work should be bound to username and public key")
+	}
+}
+
+func TestProofOfWorkLimits(t *testing.T) {
+	if _, err := SolveProofOfWork(context.Background(), "alice", "key", MaxProofOfWorkDifficulty+1); err == nil {
+		t.Error("Expected difficulty above maximum to be rejected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := SolveProofOfWork(ctx, "alice", "key", MaxProofOfWorkDifficulty); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancelled context to stop the search, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"fmt"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// ReportRequest of POST /report, Envelope is the reported one as received from inbox, packed with CBOR
+type ReportRequest struct {
+	Envelope   []byte      `json:"envelope"`
+	Reason     string      `json:"reason"`
+	Disclosure *Disclosure `json:"disclosure,omitempty"` // optional, makes the report verifiable
+}
+
+type ReportResponse struct {
+	ID       string `json:"id"`
+	Verified bool   `json:"verified"` // disclosed content is signed by the sender
+}
+
+// Disclosure of envelope session key lets the server decrypt the payload and verify sender signature inside it,
+// recipient private key is not disclosed. Only EnvelopeVersion1 and EnvelopeVersion2 can be disclosed.
+type Disclosure struct {
+	SessionKey []byte `json:"session_key"`
+	Algorithm  string `json:"algorithm"`
+}
+
+// Report in the moderation queue
+type Report struct {
+	ID         string `json:"id"`
+	Reporter   string `json:"reporter"`
+	Reported   string `json:"reported"` // sender, empty for sealed sender envelopes
+	EnvelopeID string `json:"envelope_id"`
+	Reason     string `json:"reason"`
+	Envelope   []byte `json:"envelope"`          // packed with CBOR
+	Content    []byte `json:"content,omitempty"` // disclosed plaintext, inner envelope JSON for EnvelopeVersion2
+	Verified   bool   `json:"verified"`          // Content is signed by Reported
+	InInbox    bool   `json:"in_inbox"`          // envelope was found in reporter inbox, so server vouches for Reported
+	CreatedAt  int64  `json:"created_at"`
+
+	ResolvedAt int64  `json:"resolved_at,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// DiscloseEnvelope decrypts session key of envelope with recipient private key
+func DiscloseEnvelope(e *Envelope, recipientPrivateKeyArmor string) (*Disclosure, error) {
+	split, err := splitDisclosable(e)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := crypto.NewKeyFromArmored(recipientPrivateKeyArmor)
+	if err != nil {
+		return nil, fmt.Errorf("failed To decode private key: %w", err)
+	}
+
+	keyRing, err := crypto.NewKeyRing(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed To create key ring: %w", err)
+	}
+
+	sessionKey, err := keyRing.DecryptSessionKey(split.KeyPacket)
+	if err != nil {
+		return nil, fmt.Errorf("failed To decrypt session key: %w", err)
+	}
+
+	return &Disclosure{
+		SessionKey: sessionKey.Key,
+		Algorithm:  sessionKey.Algo,
+	}, nil
+}
+
+// OpenDisclosed envelope with disclosed session key, returns unpadded plaintext if it is signed by the sender
+func OpenDisclosed(e *Envelope, d *Disclosure, senderPublicKeyArmor string) ([]byte, error) {
+	split, err := splitDisclosable(e)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := crypto.NewKeyFromArmored(senderPublicKeyArmor)
+	if err != nil {
+		return nil, fmt.Errorf("failed To decode public key: %w", err)
+	}
+
+	keyRing, err := crypto.NewKeyRing(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed To create key ring: %w", err)
+	}
+
+	sessionKey := crypto.NewSessionKeyFromToken(d.SessionKey, d.Algorithm)
+	decrypted, err := sessionKey.DecryptAndVerify(split.DataPacket, keyRing, crypto.GetUnixTime())
+	if err != nil {
+		return nil, fmt.Errorf("failed To open disclosed envelope: %w", err)
+	}
+
+	if e.GetVersion() == EnvelopeVersion1 && !e.Padded {
+		return decrypted.GetBinary(), nil
+	}
+
+	return Unpad(decrypted.GetBinary())
+}
+
+func splitDisclosable(e *Envelope) (*crypto.PGPSplitMessage, error) {
+	if v := e.GetVersion(); v != EnvelopeVersion1 && v != EnvelopeVersion2 {
+		return nil, fmt.Errorf("envelope version %d can't be disclosed", v)
+	}
+
+	split, err := crypto.NewPGPMessage(e.Payload).SplitMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed To split envelope payload: %w", err)
+	}
+	return split, nil
+}
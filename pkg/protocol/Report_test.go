@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDiscloseEnvelope(t *testing.T) {
+	alicePrivate, alicePublic, _ := GeneratePair("alice", "alice@example.com")
+	bobPrivate, bobPublic, _ := GeneratePair("bob", "bob@example.com")
+	_, carolPublic, _ := GeneratePair("carol", "carol@example.com")
+
+	inner := InnerEnvelope{PayloadType: "Text", Payload: []byte(`{"text":"spam"}`)}
+	envelope, err := SealEnvelope("alice", "bob", inner, bobPublic, alicePrivate, PadPolicy{Scheme: PadSchemePowerOfTwo})
+	if err != nil {
+		t.Fatalf("Error sealing envelope: %v", err)
+	}
+
+	disclosure, err := DiscloseEnvelope(envelope, bobPrivate)
+	if err != nil {
+		t.Fatalf("Error disclosing envelope: %v", err)
+	}
+
+	content, err := OpenDisclosed(envelope, disclosure, alicePublic)
+	if err != nil {
+		t.Fatalf("Error opening disclosed envelope: %v", err)
+	}
+	var disclosed InnerEnvelope
+	if err := json.Unmarshal(content, &disclosed); err != nil || !bytes.Equal(disclosed.Payload, inner.Payload) {
+		t.Errorf("Unexpected disclosed content %s", content)
+	}
+
+	if _, err := OpenDisclosed(envelope, disclosure, carolPublic); err == nil {
+		t.Error("Disclosed envelope should not verify with key of another sender")
+	}
+}
@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// DeliveryTokenHeader carries base64 delivery token of the recipient for POST /send/sealed, request itself is not signed
+const DeliveryTokenHeader = "Soul-Delivery-Token"
+
+// SetDeliveryTokenRequest registers sha256 of a random token, the token itself is shared only with one contact
+// in ContactRequestAccepted, so server can authorize sealed sender delivery without knowing who sends.
+// Server doesn't learn the contact either, client keeps track of its tokens and revokes one by its Verifier.
+type SetDeliveryTokenRequest struct {
+	Verifier []byte `json:"verifier"`
+	Revoke   bool   `json:"revoke,omitempty"`
+}
+
+// SealedContent is encrypted Payload of EnvelopeVersion3 envelopes, sender is known only to the recipient
+type SealedContent struct {
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Inner     json.RawMessage `json:"inner"`     // InnerEnvelope
+	Signature string          `json:"signature"` // base64 detached signature of sealedSignedData by sender key
+}
+
+// sealedSignedData binds inner envelope to its recipient, so recipient can't re-seal it to someone else
+// and pass it as sent by the original sender
+func sealedSignedData(to string, inner []byte) []byte {
+	return append([]byte(to+"\n"), inner...)
+}
+
+// DeliveryTokenVerifier is what server stores and compares with sha256 of presented token
+func DeliveryTokenVerifier(token []byte) []byte {
+	sum := sha256.Sum256(token)
+	return sum[:]
+}
+
+// SealSender hides sender of the envelope from the server, envelope has no From and is not signed outside,
+// sender signature is inside encrypted SealedContent which is padded according to pad policy
+func SealSender(from, to string, inner InnerEnvelope, recipientPublicKeyArmor, senderPrivateKeyArmor string, pad PadPolicy) (*Envelope, error) {
+	innerData, err := json.Marshal(inner)
+	if err != nil {
+		return nil, fmt.Errorf("failed To marshal inner envelope: %w", err)
+	}
+
+	senderKey, err := crypto.NewKeyFromArmored(senderPrivateKeyArmor)
+	if err != nil {
+		return nil, fmt.Errorf("failed To decode private key: %w", err)
+	}
+
+	signature, err := Sign(sealedSignedData(to, innerData), senderKey)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := json.Marshal(SealedContent{
+		From:      from,
+		To:        to,
+		Inner:     innerData,
+		Signature: signature,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed To marshal sealed content: %w", err)
+	}
+
+	payload, err := Encrypt(pad.Pad(content), recipientPublicKeyArmor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		To:      to,
+		Payload: payload,
+		Version: EnvelopeVersion3,
+	}, nil
+}
+
+// OpenSealedSender decrypts EnvelopeVersion3 envelope and verifies sender with public key returned by senderPublicKey,
+// content sealed for anyone else than recipient is rejected
+func OpenSealedSender(e *Envelope, recipient, recipientPrivateKeyArmor string, senderPublicKey func(username string) (string, error)) (string, *InnerEnvelope, error) {
+	if e.GetVersion() != EnvelopeVersion3 {
+		return "", nil, fmt.Errorf("envelope version %d is not sealed sender", e.GetVersion())
+	}
+
+	data, err := Decrypt(e.Payload, recipientPrivateKeyArmor)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err = Unpad(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var content SealedContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return "", nil, fmt.Errorf("failed To unmarshal sealed content: %w", err)
+	}
+
+	if content.To != recipient {
+		return "", nil, fmt.Errorf("sealed content is addressed To %q, not %q", content.To, recipient)
+	}
+
+	publicKey, err := senderPublicKey(content.From)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed To get sender public key: %w", err)
+	}
+
+	if err := VerifySignArmor(sealedSignedData(content.To, content.Inner), content.Signature, publicKey); err != nil {
+		return "", nil, fmt.Errorf("failed To verify sender %s: %w", content.From, err)
+	}
+
+	var inner InnerEnvelope
+	if err := json.Unmarshal(content.Inner, &inner); err != nil {
+		return "", nil, fmt.Errorf("failed To unmarshal inner envelope: %w", err)
+	}
+
+	return content.From, &inner, nil
+}
@@ -0,0 +1,9 @@
+package protocol
+
+// SendResponse of POST /send and POST /send/sealed, ID is assigned to the envelope by the server
+// and is what receipts refer to
+type SendResponse struct {
+	Success   bool   `json:"success"`
+	ID        string `json:"id"`
+	Delivered bool   `json:"delivered,omitempty"` // for ephemeral envelopes, false if recipient had no open streams
+}
@@ -1,8 +1,36 @@
 package protocol
 
+// ProtocolVersion is the latest protocol version, server and SDK agree on the highest version both support.
+// Version 2 adds EnvelopeVersion2 with encrypted PayloadType.
+const ProtocolVersion = 2
+
+// ProtocolVersionHeader is sent by clients with every request, so server can reject unsupported ones
+const ProtocolVersionHeader = "Soul-Protocol-Version"
+
+// Optional features advertised in ServerInfo.Features
+const (
+	FeatureChats        = "chats"
+	FeaturePush         = "push"
+	FeatureFederation   = "federation"
+	FeatureAttachments  = "attachments"
+	FeatureSealedSender = "sealed-sender"
+	FeatureRatchet      = "ratchet" // prekey bundles for double ratchet sessions, see pkg/ratchet
+	FeatureStream       = "stream"  // ephemeral envelopes and presence over POST /stream
+)
+
 type ServerInfo struct {
 	Version         string
 	URL             string
 	PublicKey       string
 	CurrentUnitTime int64
+
+	ProtocolVersions []int    // empty for servers before negotiation, which only know version 1
+	Encodings        []string // content types of binary messages in order of preference, empty means gob only
+	MaxPayloadSize   int64    // request body limit in bytes, 0 is unknown
+	Features         []string
+	PadPolicy        PadPolicy // how clients should pad plaintext before encryption
+	Registration     RegistrationPolicy
+
+	MaxAttachmentSize int64 // size limit of uploaded blobs in bytes, 0 if attachments are not supported
+	MaxRetention      int64 // seconds undelivered envelopes are kept, longer TTL is cut to it, 0 is unlimited
 }
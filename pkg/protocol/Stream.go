@@ -0,0 +1,46 @@
+package protocol
+
+// StreamRequest opens POST /stream, response is CBOR sequence of ephemeral envelopes (see EnvelopeDecoder)
+// which lasts until client disconnects. Envelopes are not signed by the server as a whole,
+// their payloads are signed by senders and Presence payloads by the server.
+type StreamRequest struct {
+	Presence []string `json:"presence,omitempty"` // usernames to receive Presence updates about
+}
+
+// Presence visibility in PrivacySettings
+const (
+	PresenceEveryone = "everyone"
+	PresenceNobody   = "nobody"
+)
+
+// PrivacySettings of the user, set with POST /privacy
+type PrivacySettings struct {
+	Presence string `json:"presence"` // PresenceEveryone by default
+}
+
+// Presence is server Payload of ephemeral envelopes to streams watching Username,
+// also returned by POST /presence. Users hiding presence are never online and have no LastSeen.
+type Presence struct {
+	Username string `json:"username"`
+	Online   bool   `json:"online"`
+	LastSeen int64  `json:"last_seen,omitempty"` // unix seconds when the last stream was closed, 0 if unknown
+}
+
+type GetPresenceRequest struct {
+	Usernames []string `json:"usernames"`
+}
+
+type GetPresenceResponse struct {
+	Presence []Presence `json:"presence"`
+}
+
+// Typing states
+const (
+	TypingStarted = "typing"
+	TypingStopped = "paused"
+)
+
+// Typing is user to user Payload, sent in ephemeral envelopes
+type Typing struct {
+	State string `json:"state"`
+}
@@ -149,3 +149,43 @@ func DecryptVerify(data []byte, publicKeyArmor string, privateKeyArmor string) (
 
 	return decrypted.GetBinary(), nil
 }
+
+// Encrypt data with publicKey without signing, sender is unknown to the recipient unless it is proven inside data
+func Encrypt(data []byte, publicKeyArmor string) ([]byte, error) {
+	publicKey, err := crypto.NewKeyFromArmored(publicKeyArmor)
+	if err != nil {
+		return nil, fmt.Errorf("failed To decode public key: %w", err)
+	}
+
+	encryptionKeyRing, err := crypto.NewKeyRing(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed To create encryption key ring: %w", err)
+	}
+
+	encrypted, err := encryptionKeyRing.Encrypt(crypto.NewPlainMessage(data), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed To encrypt data: %w", err)
+	}
+
+	return encrypted.GetBinary(), nil
+}
+
+// Decrypt data with privateKey without signature verification
+func Decrypt(data []byte, privateKeyArmor string) ([]byte, error) {
+	privateKey, err := crypto.NewKeyFromArmored(privateKeyArmor)
+	if err != nil {
+		return nil, fmt.Errorf("failed To decode private key: %w", err)
+	}
+
+	encryptionKeyRing, err := crypto.NewKeyRing(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed To create encryption key ring: %w", err)
+	}
+
+	decrypted, err := encryptionKeyRing.Decrypt(crypto.NewPGPMessage(data), nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed To decrypt data: %w", err)
+	}
+
+	return decrypted.GetBinary(), nil
+}
@@ -0,0 +1,249 @@
+// Package ratchet implements X3DH handshake and double ratchet sessions, see
+// https://signal.org/docs/specifications/x3dh/ and https://signal.org/docs/specifications/doubleratchet/.
+// Every message is encrypted with its own key, so compromising long-term keys doesn't expose past messages.
+package ratchet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"maps"
+)
+
+// MaxSkip limits message keys kept for lost or reordered messages in a single chain
+const MaxSkip = 1000
+
+var (
+	ErrNoHandshake    = errors.New("header has no handshake")
+	ErrNoSendingChain = errors.New("session has no sending chain yet")
+	ErrTooManySkipped = errors.New("too many skipped messages")
+	ErrDecrypt        = errors.New("failed to decrypt message")
+)
+
+// Session is double ratchet state of one side, it is persisted as JSON by the client.
+// Session must be saved after every Encrypt and Decrypt, message keys are never reused.
+type Session struct {
+	RootKey []byte            `json:"root_key"`
+	DHs     KeyPair           `json:"dhs"` // our current ratchet key pair
+	DHr     []byte            `json:"dhr"` // their current ratchet public key
+	CKs     []byte            `json:"cks"`
+	CKr     []byte            `json:"ckr"`
+	Ns      uint32            `json:"ns"`
+	Nr      uint32            `json:"nr"`
+	PN      uint32            `json:"pn"`
+	Skipped map[string][]byte `json:"skipped"` // message keys by skippedKey
+	AD      []byte            `json:"ad"`
+
+	// Handshake is sent in every header until the first reply arrives
+	Handshake *protocol.RatchetHeader `json:"handshake,omitempty"`
+	// HandshakeKey is ephemeral key of the handshake session is built from, ours for initiated sessions
+	// and theirs for responded ones, so a replayed handshake is told apart from a new one
+	HandshakeKey []byte `json:"handshake_key,omitempty"`
+}
+
+// Encrypt plaintext with the next sending message key
+func (s *Session) Encrypt(plaintext []byte) (protocol.RatchetHeader, []byte, error) {
+	if s.CKs == nil {
+		return protocol.RatchetHeader{}, nil, ErrNoSendingChain
+	}
+
+	var messageKey []byte
+	s.CKs, messageKey = kdfCK(s.CKs)
+
+	header := protocol.RatchetHeader{
+		DH: s.DHs.Public,
+		PN: s.PN,
+		N:  s.Ns,
+	}
+	if s.Handshake != nil {
+		header.IdentityKey = s.Handshake.IdentityKey
+		header.EphemeralKey = s.Handshake.EphemeralKey
+		header.SignedPrekeyID = s.Handshake.SignedPrekeyID
+		header.OneTimePrekeyID = s.Handshake.OneTimePrekeyID
+		header.IdentitySignature = s.Handshake.IdentitySignature
+	}
+	s.Ns++
+
+	ciphertext, err := seal(messageKey, plaintext, s.headerAD(header))
+	if err != nil {
+		return protocol.RatchetHeader{}, nil, err
+	}
+
+	return header, ciphertext, nil
+}
+
+// Decrypt message, session is changed only if decryption succeeds
+func (s *Session) Decrypt(header protocol.RatchetHeader, ciphertext []byte) ([]byte, error) {
+	next := s.clone()
+
+	if messageKey, ok := next.Skipped[skippedKey(header.DH, header.N)]; ok {
+		delete(next.Skipped, skippedKey(header.DH, header.N))
+		return s.commit(next, messageKey, header, ciphertext)
+	}
+
+	if !bytes.Equal(header.DH, next.DHr) {
+		if err := next.skip(header.PN); err != nil {
+			return nil, err
+		}
+		if err := next.step(header.DH); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := next.skip(header.N); err != nil {
+		return nil, err
+	}
+
+	var messageKey []byte
+	next.CKr, messageKey = kdfCK(next.CKr)
+	next.Nr++
+
+	return s.commit(next, messageKey, header, ciphertext)
+}
+
+func (s *Session) commit(next *Session, messageKey []byte, header protocol.RatchetHeader, ciphertext []byte) ([]byte, error) {
+	plaintext, err := open(messageKey, ciphertext, next.headerAD(header))
+	if err != nil {
+		return nil, err
+	}
+
+	// any reply means the other side has completed the handshake
+	next.Handshake = nil
+	*s = *next
+
+	return plaintext, nil
+}
+
+// skip stores message keys of the receiving chain up to until
+func (s *Session) skip(until uint32) error {
+	if s.CKr == nil {
+		return nil
+	}
+	if until > s.Nr && until-s.Nr > MaxSkip {
+		return ErrTooManySkipped
+	}
+
+	for s.Nr < until {
+		var messageKey []byte
+		s.CKr, messageKey = kdfCK(s.CKr)
+		s.Skipped[skippedKey(s.DHr, s.Nr)] = messageKey
+		s.Nr++
+	}
+
+	return nil
+}
+
+// step is DH ratchet step on new ratchet key of the other side
+func (s *Session) step(theirs []byte) error {
+	s.PN = s.Ns
+	s.Ns = 0
+	s.Nr = 0
+	s.DHr = theirs
+
+	secret, err := dh(s.DHs.Private, s.DHr)
+	if err != nil {
+		return err
+	}
+	s.RootKey, s.CKr = kdfRK(s.RootKey, secret)
+
+	if s.DHs, err = GenerateKeyPair(); err != nil {
+		return err
+	}
+
+	secret, err = dh(s.DHs.Private, s.DHr)
+	if err != nil {
+		return err
+	}
+	s.RootKey, s.CKs = kdfRK(s.RootKey, secret)
+
+	return nil
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Skipped = maps.Clone(s.Skipped)
+	if c.Skipped == nil {
+		c.Skipped = make(map[string][]byte)
+	}
+	return &c
+}
+
+// headerAD authenticates ratchet part of the header, handshake part is authenticated by the derived keys
+func (s *Session) headerAD(header protocol.RatchetHeader) []byte {
+	ad := append([]byte{}, s.AD...)
+	ad = append(ad, header.DH...)
+	ad = binary.BigEndian.AppendUint32(ad, header.PN)
+	return binary.BigEndian.AppendUint32(ad, header.N)
+}
+
+func skippedKey(dh []byte, n uint32) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(dh), n)
+}
+
+func kdfRK(rootKey, secret []byte) ([]byte, []byte) {
+	out := make([]byte, 64)
+	_, _ = io.ReadFull(hkdf.New(sha256.New, secret, rootKey, []byte("SoulRatchet")), out)
+	return out[:32], out[32:]
+}
+
+// kdfCK returns next chain key and message key
+func kdfCK(chainKey []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, chainKey)
+	mac.Write([]byte{0x02})
+	next := mac.Sum(nil)
+
+	mac.Reset()
+	mac.Write([]byte{0x01})
+
+	return next, mac.Sum(nil)
+}
+
+// messageCipher derives AES-256-GCM key and nonce from message key, every message key is used once
+func messageCipher(messageKey []byte) (cipher.AEAD, []byte, error) {
+	out := make([]byte, 32+12)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, messageKey, nil, []byte("SoulRatchetMessage")), out); err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(out[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return aead, out[32:], nil
+}
+
+func seal(messageKey, plaintext, ad []byte) ([]byte, error) {
+	aead, nonce, err := messageCipher(messageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message cipher: %w", err)
+	}
+	return aead.Seal(nil, nonce, plaintext, ad), nil
+}
+
+func open(messageKey, ciphertext, ad []byte) ([]byte, error) {
+	aead, nonce, err := messageCipher(messageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message cipher: %w", err)
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
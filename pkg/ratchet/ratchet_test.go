@@ -0,0 +1,174 @@
+package ratchet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"testing"
+)
+
+type message struct {
+	header     protocol.RatchetHeader
+	ciphertext []byte
+}
+
+func handshake(t *testing.T, withOneTimePrekey bool) (*Session, *Session) {
+	t.Helper()
+
+	aliceIdentity, _ := GenerateKeyPair()
+	bobIdentity, _ := GenerateKeyPair()
+	bobSignedPrekey, _ := GenerateKeyPair()
+	bobOneTimePrekey, _ := GenerateKeyPair()
+
+	bundle := protocol.PrekeyBundle{
+		Username:       "bob",
+		IdentityKey:    bobIdentity.Public,
+		SignedPrekeyID: 1,
+		SignedPrekey:   bobSignedPrekey.Public,
+	}
+	if withOneTimePrekey {
+		bundle.OneTimePrekeyID = 2
+		bundle.OneTimePrekey = bobOneTimePrekey.Public
+	}
+
+	alice, err := Initiate(aliceIdentity, bundle)
+	if err != nil {
+		t.Fatalf("Error initiating session: %v", err)
+	}
+
+	first := encrypt(t, alice, "hello bob")
+	if !first.header.IsHandshake() {
+		t.Fatalf("First message should carry handshake")
+	}
+
+	var oneTimePrekey *KeyPair
+	if withOneTimePrekey {
+		oneTimePrekey = &bobOneTimePrekey
+	}
+	bob, err := Respond(bobIdentity, bobSignedPrekey, oneTimePrekey, first.header)
+	if err != nil {
+		t.Fatalf("Error responding to handshake: %v", err)
+	}
+
+	if got := decrypt(t, bob, first); got != "hello bob" {
+		t.Fatalf("Decrypted %q, want %q", got, "hello bob")
+	}
+
+	if !bytes.Equal(alice.HandshakeKey, first.header.EphemeralKey) || !bytes.Equal(bob.HandshakeKey, first.header.EphemeralKey) {
+		t.Fatalf("Expected both sessions to keep ephemeral key of the handshake")
+	}
+
+	return alice, bob
+}
+
+func encrypt(t *testing.T, s *Session, text string) message {
+	t.Helper()
+
+	header, ciphertext, err := s.Encrypt([]byte(text))
+	if err != nil {
+		t.Fatalf("Error encrypting: %v", err)
+	}
+	return message{header, ciphertext}
+}
+
+func decrypt(t *testing.T, s *Session, m message) string {
+	t.Helper()
+
+	plaintext, err := s.Decrypt(m.header, m.ciphertext)
+	if err != nil {
+		t.Fatalf("Error decrypting message %d: %v", m.header.N, err)
+	}
+	return string(plaintext)
+}
+
+func TestSessionConversation(t *testing.T) {
+	for _, withOneTimePrekey := range []bool{true, false} {
+		alice, bob := handshake(t, withOneTimePrekey)
+
+		for i := 0; i < 3; i++ {
+			text := fmt.Sprintf("reply %d", i)
+			if got := decrypt(t, alice, encrypt(t, bob, text)); got != text {
+				t.Errorf("Decrypted %q, want %q", got, text)
+			}
+
+			text = fmt.Sprintf("message %d", i)
+			if got := decrypt(t, bob, encrypt(t, alice, text)); got != text {
+				t.Errorf("Decrypted %q, want %q", got, text)
+			}
+		}
+
+		if alice.Handshake != nil {
+			t.Errorf("Handshake should be dropped after the first reply")
+		}
+	}
+}
+
+func TestSessionOutOfOrder(t *testing.T) {
+	alice, bob := handshake(t, true)
+
+	messages := make([]message, 5)
+	for i := range messages {
+		messages[i] = encrypt(t, alice, fmt.Sprintf("message %d", i))
+	}
+
+	for _, i := range []int{3, 0, 4, 2, 1} {
+		if got, want := decrypt(t, bob, messages[i]), fmt.Sprintf("message %d", i); got != want {
+			t.Errorf("Decrypted %q, want %q", got, want)
+		}
+	}
+
+	if len(bob.Skipped) != 0 {
+		t.Errorf("Expected no skipped keys left, got %d", len(bob.Skipped))
+	}
+
+	if _, err := bob.Decrypt(messages[2].header, messages[2].ciphertext); err == nil {
+		t.Errorf("Expected error decrypting the same message twice")
+	}
+}
+
+func TestSessionRejectsTampered(t *testing.T) {
+	alice, bob := handshake(t, true)
+
+	m := encrypt(t, alice, "message")
+	m.ciphertext[0] ^= 0xFF
+
+	before, _ := json.Marshal(bob)
+	if _, err := bob.Decrypt(m.header, m.ciphertext); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt, got %v", err)
+	}
+
+	after, _ := json.Marshal(bob)
+	if string(before) != string(after) {
+		t.Errorf("Session should not change when decryption fails")
+	}
+}
+
+func TestSessionTooManySkipped(t *testing.T) {
+	alice, bob := handshake(t, true)
+
+	m := encrypt(t, alice, "message")
+	m.header.N = MaxSkip + 2
+	if _, err := bob.Decrypt(m.header, m.ciphertext); !errors.Is(err, ErrTooManySkipped) {
+		t.Errorf("Expected ErrTooManySkipped, got %v", err)
+	}
+}
+
+func TestSessionPersists(t *testing.T) {
+	alice, bob := handshake(t, true)
+
+	data, err := json.Marshal(bob)
+	if err != nil {
+		t.Fatalf("Error marshaling session: %v", err)
+	}
+
+	var restored Session
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Error unmarshaling session: %v", err)
+	}
+
+	if got := decrypt(t, &restored, encrypt(t, alice, "after restart")); got != "after restart" {
+		t.Errorf("Decrypted %q, want %q", got, "after restart")
+	}
+}
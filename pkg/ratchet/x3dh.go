@@ -0,0 +1,152 @@
+package ratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+// KeyPair is X25519 key pair, used for identity keys, prekeys and ratchet keys
+type KeyPair struct {
+	Private []byte `json:"private"`
+	Public  []byte `json:"public"`
+}
+
+func GenerateKeyPair() (KeyPair, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	return KeyPair{
+		Private: key.Bytes(),
+		Public:  key.PublicKey().Bytes(),
+	}, nil
+}
+
+func dh(private, public []byte) ([]byte, error) {
+	privateKey, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	publicKey, err := ecdh.X25519().NewPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	return privateKey.ECDH(publicKey)
+}
+
+// Initiate session with the owner of bundle. Bundle signature is not checked here,
+// caller should Verify it with the account key of the owner first.
+// Returned session includes handshake in every header until the first reply is decrypted.
+func Initiate(identity KeyPair, bundle protocol.PrekeyBundle) (*Session, error) {
+	ephemeral, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	// DH1 = DH(IKa, SPKb), DH2 = DH(EKa, IKb), DH3 = DH(EKa, SPKb), DH4 = DH(EKa, OPKb)
+	pairs := [][2][]byte{
+		{identity.Private, bundle.SignedPrekey},
+		{ephemeral.Private, bundle.IdentityKey},
+		{ephemeral.Private, bundle.SignedPrekey},
+	}
+	if bundle.OneTimePrekeyID != 0 {
+		pairs = append(pairs, [2][]byte{ephemeral.Private, bundle.OneTimePrekey})
+	}
+
+	sharedKey, err := x3dh(pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		DHr:     bundle.SignedPrekey,
+		Skipped: make(map[string][]byte),
+		AD:      associatedData(identity.Public, bundle.IdentityKey),
+		Handshake: &protocol.RatchetHeader{
+			IdentityKey:     identity.Public,
+			EphemeralKey:    ephemeral.Public,
+			SignedPrekeyID:  bundle.SignedPrekeyID,
+			OneTimePrekeyID: bundle.OneTimePrekeyID,
+		},
+		HandshakeKey: ephemeral.Public,
+	}
+
+	if s.DHs, err = GenerateKeyPair(); err != nil {
+		return nil, err
+	}
+
+	secret, err := dh(s.DHs.Private, s.DHr)
+	if err != nil {
+		return nil, err
+	}
+	s.RootKey, s.CKs = kdfRK(sharedKey, secret)
+
+	return s, nil
+}
+
+// Respond to handshake from header of the first message, oneTimePrekey is nil when header has no OneTimePrekeyID.
+// Identity key of the initiator should be verified by caller, e.g. with header IdentitySignature.
+// Returned session is ready to Decrypt the message the header came with.
+func Respond(identity, signedPrekey KeyPair, oneTimePrekey *KeyPair, header protocol.RatchetHeader) (*Session, error) {
+	if !header.IsHandshake() {
+		return nil, ErrNoHandshake
+	}
+
+	pairs := [][2][]byte{
+		{signedPrekey.Private, header.IdentityKey},
+		{identity.Private, header.EphemeralKey},
+		{signedPrekey.Private, header.EphemeralKey},
+	}
+	if header.OneTimePrekeyID != 0 {
+		if oneTimePrekey == nil {
+			return nil, fmt.Errorf("one-time prekey %d is required", header.OneTimePrekeyID)
+		}
+		pairs = append(pairs, [2][]byte{oneTimePrekey.Private, header.EphemeralKey})
+	}
+
+	sharedKey, err := x3dh(pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		RootKey:      sharedKey,
+		DHs:          signedPrekey,
+		Skipped:      make(map[string][]byte),
+		AD:           associatedData(header.IdentityKey, identity.Public),
+		HandshakeKey: header.EphemeralKey,
+	}, nil
+}
+
+func x3dh(pairs [][2][]byte) ([]byte, error) {
+	// F is 32 0xFF bytes for X25519, so the input is never a valid DH output
+	ikm := bytes.Repeat([]byte{0xFF}, 32)
+	for _, pair := range pairs {
+		secret, err := dh(pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute handshake secret: %w", err)
+		}
+		ikm = append(ikm, secret...)
+	}
+
+	sharedKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, make([]byte, 32), []byte("SoulX3DH")), sharedKey); err != nil {
+		return nil, err
+	}
+
+	return sharedKey, nil
+}
+
+// associatedData binds every message to identity keys of both sides, initiator goes first
+func associatedData(initiator, responder []byte) []byte {
+	return append(append([]byte{}, initiator...), responder...)
+}
@@ -0,0 +1,22 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+)
+
+// GetAccountInfo returns storage usage of the current user
+func (s *SDK) GetAccountInfo() (protocol.AccountInfo, error) {
+	rsp, err := s.Request("POST", "/account", []byte("{}"))
+	if err != nil {
+		return protocol.AccountInfo{}, fmt.Errorf("failed to get account info: %w", err)
+	}
+
+	var res protocol.AccountInfo
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return protocol.AccountInfo{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res, nil
+}
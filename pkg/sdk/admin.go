@@ -0,0 +1,71 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"time"
+)
+
+// Admin methods work when SDK is created with the private key matching server admin key,
+// username is not used for them, requests of other keys fail with protocol.ErrForbidden
+
+// ListAccounts sorted by username after after, limit 0 is the server maximum
+func (s *SDK) ListAccounts(after string, limit int) ([]protocol.AdminAccount, error) {
+	req, _ := json.Marshal(protocol.AdminListAccountsRequest{
+		Time:  s.adminTime(),
+		After: after,
+		Limit: limit,
+	})
+
+	var res protocol.AdminListAccountsResponse
+	body, err := s.Request("POST", "/admin/accounts", req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res.Accounts, nil
+}
+
+// SuspendAccount so server rejects its requests, reportID is resolved if not empty
+func (s *SDK) SuspendAccount(username, reportID string) error {
+	return s.adminAccount("/admin/accounts/suspend", username, reportID)
+}
+
+func (s *SDK) ReinstateAccount(username string) error {
+	return s.adminAccount("/admin/accounts/reinstate", username, "")
+}
+
+// DeleteAccount with its inbox and prekeys, this can't be undone and the username is never registered again
+func (s *SDK) DeleteAccount(username, reportID string) error {
+	return s.adminAccount("/admin/accounts/delete", username, reportID)
+}
+
+// adminTime is now in unix milliseconds, but later than Time of the previous admin request
+func (s *SDK) adminTime() int64 {
+	for {
+		last := s.lastAdminTime.Load()
+		t := max(time.Now().UnixMilli(), last+1)
+		if s.lastAdminTime.CompareAndSwap(last, t) {
+			return t
+		}
+	}
+}
+
+func (s *SDK) adminAccount(path, username, reportID string) error {
+	req, _ := json.Marshal(protocol.AdminAccountRequest{
+		Time:     s.adminTime(),
+		Username: username,
+		Report:   reportID,
+	})
+
+	if _, err := s.Request("POST", path, req); err != nil {
+		return fmt.Errorf("failed to moderate account: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// maxUploadConflicts stops UploadBlob if the offset keeps moving under it, e.g. two clients resume the same upload
+const maxUploadConflicts = 3
+
+// CreateUpload of size bytes, upload it with UploadBlob
+func (s *SDK) CreateUpload(size int64) (protocol.UploadResponse, error) {
+	req, _ := json.Marshal(protocol.CreateUploadRequest{
+		Size: size,
+	})
+
+	rsp, err := s.Request("POST", "/blobs/upload", req)
+	if err != nil {
+		return protocol.UploadResponse{}, fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	var res protocol.UploadResponse
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return protocol.UploadResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *SDK) UploadStatus(uploadID string) (protocol.UploadResponse, error) {
+	req, _ := json.Marshal(protocol.UploadStatusRequest{
+		UploadID: uploadID,
+	})
+
+	rsp, err := s.Request("POST", "/blobs/upload/status", req)
+	if err != nil {
+		return protocol.UploadResponse{}, fmt.Errorf("failed to get upload status: %w", err)
+	}
+
+	var res protocol.UploadResponse
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return protocol.UploadResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res, nil
+}
+
+// UploadBlob sends r in chunks starting from the offset server has, so calling it again with
+// the same upload ID and content resumes interrupted upload. Returns blob ID.
+func (s *SDK) UploadBlob(uploadID string, r io.ReadSeeker) (string, error) {
+	upload, err := s.UploadStatus(uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	conflicts := 0
+	chunk := make([]byte, upload.ChunkSize)
+	for upload.BlobID == "" {
+		if _, err := r.Seek(upload.Offset, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to seek to offset %d: %w", upload.Offset, err)
+		}
+
+		n, err := io.ReadFull(r, chunk[:min(upload.ChunkSize, upload.Size-upload.Offset)])
+		if err != nil {
+			return "", fmt.Errorf("failed to read chunk at offset %d: %w", upload.Offset, err)
+		}
+
+		path := "/blobs/upload/" + uploadID + "?offset=" + strconv.FormatInt(upload.Offset, 10)
+		rsp, _, err := s.RequestContentType("PUT", path, chunk[:n], "application/octet-stream", "")
+		if errors.Is(err, protocol.ErrUploadOffset) && conflicts < maxUploadConflicts {
+			conflicts++
+			if upload, err = s.UploadStatus(uploadID); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to upload chunk at offset %d: %w", upload.Offset, err)
+		}
+
+		if err = json.Unmarshal(rsp, &upload); err != nil {
+			return "", fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return upload.BlobID, nil
+}
+
+// DownloadBlob writes blob to dst and checks it against blob ID, dst may have received the blob
+// when mismatch error is returned
+func (s *SDK) DownloadBlob(blobID string, dst io.Writer) error {
+	hash := sha256.New()
+	if err := s.downloadBlob(blobID, func(body io.Reader) error {
+		_, err := io.Copy(io.MultiWriter(dst, hash), body)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != blobID {
+		return fmt.Errorf("blob %s does not match its ID", blobID)
+	}
+	return nil
+}
+
+func (s *SDK) downloadBlob(blobID string, read func(body io.Reader) error) error {
+	data, _ := json.Marshal(protocol.DownloadBlobRequest{
+		ID: blobID,
+	})
+
+	r, err := s.newRequest("POST", "/blobs/download", data, "", "")
+	if err != nil {
+		return err
+	}
+	if err := s.signRequest(r, data); err != nil {
+		return err
+	}
+
+	rsp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return fmt.Errorf("failed to download blob: %w", err)
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		if _, _, err := s.readResponse(rsp); err != nil {
+			return fmt.Errorf("failed to download blob: %w", err)
+		}
+		return fmt.Errorf("failed to download blob: status %d", rsp.StatusCode)
+	}
+	defer rsp.Body.Close()
+
+	if err := read(rsp.Body); err != nil {
+		return fmt.Errorf("failed to download blob: %w", err)
+	}
+	return nil
+}
+
+// UploadAttachment encrypts r into a temporary file and uploads it, the returned attachment
+// is sent inside a message, e.g. with SendAttachments
+func (s *SDK) UploadAttachment(r io.Reader, name, mimeType string) (protocol.Attachment, error) {
+	tmp, err := os.CreateTemp("", "soul-attachment-*")
+	if err != nil {
+		return protocol.Attachment{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	counter := &countingReader{r: r}
+	key, err := protocol.EncryptAttachment(tmp, counter)
+	if err != nil {
+		return protocol.Attachment{}, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return protocol.Attachment{}, fmt.Errorf("failed to get encrypted size: %w", err)
+	}
+	if s.info.MaxAttachmentSize > 0 && size > s.info.MaxAttachmentSize {
+		return protocol.Attachment{}, fmt.Errorf("%w: %d bytes, server accepts attachments up to %d",
+			protocol.ErrPayloadTooLarge, size, s.info.MaxAttachmentSize)
+	}
+
+	upload, err := s.CreateUpload(size)
+	if err != nil {
+		return protocol.Attachment{}, err
+	}
+
+	blobID, err := s.UploadBlob(upload.UploadID, tmp)
+	if err != nil {
+		return protocol.Attachment{}, err
+	}
+
+	return protocol.Attachment{
+		ID:       blobID,
+		Key:      key,
+		Size:     counter.n,
+		Name:     name,
+		MimeType: mimeType,
+	}, nil
+}
+
+// DownloadAttachment decrypts attachment into dst, chunks are authenticated before they are written,
+// but dst may receive a prefix of the attachment if an error is returned
+func (s *SDK) DownloadAttachment(attachment protocol.Attachment, dst io.Writer) error {
+	if !protocol.ValidBlobID(attachment.ID) {
+		return fmt.Errorf("invalid attachment ID %q", attachment.ID)
+	}
+
+	hash := sha256.New()
+	if err := s.downloadBlob(attachment.ID, func(body io.Reader) error {
+		return protocol.DecryptAttachment(dst, io.TeeReader(body, hash), attachment.Key)
+	}); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != attachment.ID {
+		return fmt.Errorf("%w: blob does not match its ID", protocol.ErrAttachmentCorrupted)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
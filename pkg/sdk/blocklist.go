@@ -0,0 +1,59 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+)
+
+// Block username, their envelopes, contact requests and chat invites are rejected with protocol.ErrBlocked
+func (s *SDK) Block(username string) error {
+	return s.block(protocol.BlockRequest{
+		Username: username,
+	})
+}
+
+// Mute username, their envelopes and contact requests are dropped by the server without telling them
+func (s *SDK) Mute(username string) error {
+	return s.block(protocol.BlockRequest{
+		Username: username,
+		Mute:     true,
+	})
+}
+
+func (s *SDK) block(blockRequest protocol.BlockRequest) error {
+	req, _ := json.Marshal(blockRequest)
+
+	if _, err := s.Request("POST", "/block", req); err != nil {
+		return fmt.Errorf("failed to block user: %w", err)
+	}
+
+	return nil
+}
+
+// Unblock blocked or muted username
+func (s *SDK) Unblock(username string) error {
+	req, _ := json.Marshal(protocol.UnblockRequest{
+		Username: username,
+	})
+
+	if _, err := s.Request("POST", "/unblock", req); err != nil {
+		return fmt.Errorf("failed to unblock user: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SDK) GetBlocklist() ([]protocol.BlockedUser, error) {
+	rsp, err := s.Request("POST", "/blocklist", []byte("{}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocklist: %w", err)
+	}
+
+	var res protocol.BlocklistResponse
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res.Blocked, nil
+}
@@ -2,7 +2,9 @@ package sdk
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/soul-ua/server/pkg/protocol"
+	"time"
 )
 
 func (s *SDK) CreateChat(name string) (string, string, error) {
@@ -27,3 +29,262 @@ func (s *SDK) CreateChat(name string) (string, string, error) {
 
 	return res.ChatID, chatPrivate, nil
 }
+
+// ArchiveChat makes chat read-only, only chat owner can do this
+func (s *SDK) ArchiveChat(chatID string) error {
+	req, _ := json.Marshal(protocol.ArchiveChatRequest{
+		ChatID: chatID,
+	})
+
+	if _, err := s.Request("POST", "/chat/archive", req); err != nil {
+		return fmt.Errorf("failed to archive chat: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SDK) UnarchiveChat(chatID string) error {
+	req, _ := json.Marshal(protocol.UnarchiveChatRequest{
+		ChatID: chatID,
+	})
+
+	if _, err := s.Request("POST", "/chat/unarchive", req); err != nil {
+		return fmt.Errorf("failed to unarchive chat: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteChat permanently, only chat owner can do this
+func (s *SDK) DeleteChat(chatID string) error {
+	req, _ := json.Marshal(protocol.DeleteChatRequest{
+		ChatID: chatID,
+	})
+
+	if _, err := s.Request("DELETE", "/chat", req); err != nil {
+		return fmt.Errorf("failed to delete chat: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SDK) GetChatInfo(chatID string) (protocol.GetChatInfoResponse, error) {
+	req, _ := json.Marshal(protocol.GetChatInfoRequest{
+		ChatID: chatID,
+	})
+
+	var res protocol.GetChatInfoResponse
+	body, err := s.Request("POST", "/chat/info", req)
+	if err != nil {
+		return res, fmt.Errorf("failed to get chat info: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &res); err != nil {
+		return res, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res, nil
+}
+
+// UpdateChatSettings policies are "everyone" or "admins", slowMode is in seconds, only chat admins can do this
+func (s *SDK) UpdateChatSettings(chatID, postPolicy, invitePolicy string, slowMode int64) error {
+	req, _ := json.Marshal(protocol.UpdateChatSettingsRequest{
+		ChatID:       chatID,
+		PostPolicy:   postPolicy,
+		InvitePolicy: invitePolicy,
+		SlowMode:     slowMode,
+	})
+
+	if _, err := s.Request("POST", "/chat/settings", req); err != nil {
+		return fmt.Errorf("failed to update chat settings: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SDK) PinChatMessage(chatID, messageID string) error {
+	req, _ := json.Marshal(protocol.PinChatMessageRequest{
+		ChatID:    chatID,
+		MessageID: messageID,
+	})
+
+	if _, err := s.Request("POST", "/chat/pin", req); err != nil {
+		return fmt.Errorf("failed to pin chat message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SDK) UnpinChatMessage(chatID, messageID string) error {
+	req, _ := json.Marshal(protocol.UnpinChatMessageRequest{
+		ChatID:    chatID,
+		MessageID: messageID,
+	})
+
+	if _, err := s.Request("POST", "/chat/unpin", req); err != nil {
+		return fmt.Errorf("failed to unpin chat message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SDK) AddChatMember(chatID, username string) error {
+	req, _ := json.Marshal(protocol.AddChatMemberRequest{
+		ChatID:   chatID,
+		Username: username,
+	})
+
+	if _, err := s.Request("POST", "/chat/members/add", req); err != nil {
+		return fmt.Errorf("failed to add chat member: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveChatMember or leave the chat if username is the current user
+func (s *SDK) RemoveChatMember(chatID, username string) error {
+	req, _ := json.Marshal(protocol.RemoveChatMemberRequest{
+		ChatID:   chatID,
+		Username: username,
+	})
+
+	if _, err := s.Request("POST", "/chat/members/remove", req); err != nil {
+		return fmt.Errorf("failed to remove chat member: %w", err)
+	}
+
+	return nil
+}
+
+// SetChatMemberRole role is "admin" or "member", only chat owner can do this
+func (s *SDK) SetChatMemberRole(chatID, username, role string) error {
+	req, _ := json.Marshal(protocol.SetChatMemberRoleRequest{
+		ChatID:   chatID,
+		Username: username,
+		Role:     role,
+	})
+
+	if _, err := s.Request("POST", "/chat/members/role", req); err != nil {
+		return fmt.Errorf("failed to set chat member role: %w", err)
+	}
+
+	return nil
+}
+
+// SendChatMessage payload should be already padded with PadPolicy and encrypted with the chat key, returns message ID.
+// attachmentIDs are blob IDs of attachments in the payload.
+func (s *SDK) SendChatMessage(chatID, payloadType string, payload []byte, attachmentIDs ...string) (string, error) {
+	return s.SendExpiringChatMessage(chatID, payloadType, payload, 0, attachmentIDs...)
+}
+
+// SendExpiringChatMessage is SendChatMessage which server drops ttl after it is posted, 0 never expires
+func (s *SDK) SendExpiringChatMessage(chatID, payloadType string, payload []byte, ttl time.Duration, attachmentIDs ...string) (string, error) {
+	req, _ := json.Marshal(protocol.ChatSendRequest{
+		ChatID:      chatID,
+		PayloadType: payloadType,
+		Payload:     payload,
+		Attachments: attachmentIDs,
+		TTL:         int64(ttl / time.Second),
+	})
+
+	var res protocol.ChatSendResponse
+	body, err := s.Request("POST", "/chat/send", req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send chat message: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res.MessageID, nil
+}
+
+func (s *SDK) GetChatMessages(chatID, sinceID string) ([]protocol.ChatMessage, error) {
+	req, _ := json.Marshal(protocol.GetChatMessagesRequest{
+		ChatID:  chatID,
+		SinceID: sinceID,
+	})
+
+	var res protocol.GetChatMessagesResponse
+	body, err := s.Request("POST", "/chat/messages", req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat messages: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res.Messages, nil
+}
+
+// CreateChatInvite returns invite token which any registered user can redeem with JoinChat, only chat admins can do this
+func (s *SDK) CreateChatInvite(chatID string, expiresIn int64, maxUses int) (protocol.CreateChatInviteResponse, error) {
+	req, _ := json.Marshal(protocol.CreateChatInviteRequest{
+		ChatID:    chatID,
+		ExpiresIn: expiresIn,
+		MaxUses:   maxUses,
+	})
+
+	var res protocol.CreateChatInviteResponse
+	body, err := s.Request("POST", "/chat/invites/create", req)
+	if err != nil {
+		return res, fmt.Errorf("failed to create chat invite: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &res); err != nil {
+		return res, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *SDK) GetChatInvites(chatID string) ([]protocol.ChatInvite, error) {
+	req, _ := json.Marshal(protocol.GetChatInvitesRequest{
+		ChatID: chatID,
+	})
+
+	var res protocol.GetChatInvitesResponse
+	body, err := s.Request("POST", "/chat/invites", req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat invites: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res.Invites, nil
+}
+
+func (s *SDK) RevokeChatInvite(chatID, inviteID string) error {
+	req, _ := json.Marshal(protocol.RevokeChatInviteRequest{
+		ChatID:   chatID,
+		InviteID: inviteID,
+	})
+
+	if _, err := s.Request("POST", "/chat/invites/revoke", req); err != nil {
+		return fmt.Errorf("failed to revoke chat invite: %w", err)
+	}
+
+	return nil
+}
+
+// JoinChat with invite token from CreateChatInvite
+func (s *SDK) JoinChat(token string) (protocol.JoinChatResponse, error) {
+	req, _ := json.Marshal(protocol.JoinChatRequest{
+		Token: token,
+	})
+
+	var res protocol.JoinChatResponse
+	body, err := s.Request("POST", "/chat/join", req)
+	if err != nil {
+		return res, fmt.Errorf("failed to join chat: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &res); err != nil {
+		return res, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res, nil
+}
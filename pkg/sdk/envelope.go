@@ -0,0 +1,273 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"time"
+)
+
+// Send v as JSON payload to username, recipient public key is taken from Keychain, returns envelope ID.
+// With protocol version 2 payload type is encrypted inside the envelope.
+func (s *SDK) Send(to, payloadType string, v interface{}) (string, error) {
+	envelope, err := s.seal(to, payloadType, v)
+	if err != nil {
+		return "", err
+	}
+
+	return s.SendEnvelopeID(envelope)
+}
+
+// seal v into envelope of the negotiated protocol version
+func (s *SDK) seal(to, payloadType string, v interface{}) (*protocol.Envelope, error) {
+	if s.protocolVersion < 2 {
+		return s.sealV1(to, payloadType, v)
+	}
+
+	recipientPublicKey, err := s.publicKeyOf(to)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyArmor, err := s.privateKey.Armor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor private key: %w", err)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	envelope, err := protocol.SealEnvelope(s.username, to, protocol.InnerEnvelope{
+		PayloadType: payloadType,
+		Payload:     data,
+		SentAt:      time.Now().Unix(),
+	}, recipientPublicKey, privateKeyArmor, s.PadPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt envelope: %w", err)
+	}
+
+	return envelope, nil
+}
+
+func (s *SDK) sealV1(to, payloadType string, v interface{}) (*protocol.Envelope, error) {
+	recipientPublicKey, err := s.publicKeyOf(to)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyArmor, err := s.privateKey.Armor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor private key: %w", err)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	pad := s.PadPolicy()
+	envelope := &protocol.Envelope{
+		To:          to,
+		PayloadType: payloadType,
+		Padded:      pad.Scheme != protocol.PadSchemeNone,
+	}
+	envelope.Payload, err = protocol.EncryptSign(pad.Pad(data), recipientPublicKey, privateKeyArmor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt envelope: %w", err)
+	}
+
+	return envelope, nil
+}
+
+// SendPayload sends v with payload type it was registered with, see protocol.RegisterPayload
+func (s *SDK) SendPayload(to string, v interface{}) (string, error) {
+	payloadType, err := protocol.PayloadTypeOf(v)
+	if err != nil {
+		return "", err
+	}
+
+	return s.Send(to, payloadType, v)
+}
+
+// SendSealedSender hides sender from the server, deliveryToken is the one recipient shared in ContactRequestAccepted.
+// Returns envelope ID.
+func (s *SDK) SendSealedSender(to, payloadType string, v interface{}, deliveryToken []byte) (string, error) {
+	recipientPublicKey, err := s.publicKeyOf(to)
+	if err != nil {
+		return "", err
+	}
+
+	privateKeyArmor, err := s.privateKey.Armor()
+	if err != nil {
+		return "", fmt.Errorf("failed to armor private key: %w", err)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	envelope, err := protocol.SealSender(s.username, to, protocol.InnerEnvelope{
+		PayloadType: payloadType,
+		Payload:     data,
+		SentAt:      time.Now().Unix(),
+	}, recipientPublicKey, privateKeyArmor, s.PadPolicy())
+	if err != nil {
+		return "", fmt.Errorf("failed to seal envelope: %w", err)
+	}
+
+	packed, err := envelope.PackAs(s.contentType)
+	if err != nil {
+		return "", fmt.Errorf("failed to pack envelope: %w", err)
+	}
+
+	// not signed, the only identity in the request is the delivery token
+	r, err := s.newRequest("POST", "/send/sealed", packed, s.contentType, "")
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set(protocol.DeliveryTokenHeader, base64.StdEncoding.EncodeToString(deliveryToken))
+
+	body, _, err := s.do(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to send sealed envelope: %w", err)
+	}
+
+	var res protocol.SendResponse
+	if err = json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	s.receipts.Track(res.ID, to)
+	return res.ID, nil
+}
+
+// GenerateDeliveryToken to register with SetDeliveryToken and share with the contact in ContactRequestAccepted
+func GenerateDeliveryToken() ([]byte, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate delivery token: %w", err)
+	}
+	return token, nil
+}
+
+// SetDeliveryToken registers token shared with one contact for sealed sender delivery, only its hash is sent
+// to the server, which never learns the contact. To replace the token of a contact revoke the previous one.
+func (s *SDK) SetDeliveryToken(token []byte) error {
+	req, _ := json.Marshal(protocol.SetDeliveryTokenRequest{
+		Verifier: protocol.DeliveryTokenVerifier(token),
+	})
+
+	if _, err := s.Request("POST", "/delivery-token", req); err != nil {
+		return fmt.Errorf("failed to set delivery token: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeDeliveryToken registered with SetDeliveryToken, contact holding it can't send sealed sender envelopes anymore
+func (s *SDK) RevokeDeliveryToken(token []byte) error {
+	req, _ := json.Marshal(protocol.SetDeliveryTokenRequest{
+		Verifier: protocol.DeliveryTokenVerifier(token),
+		Revoke:   true,
+	})
+
+	if _, err := s.Request("POST", "/delivery-token", req); err != nil {
+		return fmt.Errorf("failed to revoke delivery token: %w", err)
+	}
+
+	return nil
+}
+
+// OpenEnvelope decrypts envelope of any version and verifies sender, sender public key is taken from Keychain.
+// For sealed sender envelopes From is set to the verified sender. Delivery receipt is sent for opened envelope
+// unless disabled with SetAutoReceipts.
+func (s *SDK) OpenEnvelope(envelope *protocol.Envelope) (*protocol.InnerEnvelope, error) {
+	inner, err := s.openEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sendDeliveryReceipt(envelope, inner)
+	return inner, nil
+}
+
+func (s *SDK) openEnvelope(envelope *protocol.Envelope) (*protocol.InnerEnvelope, error) {
+	if envelope.GetVersion() == protocol.EnvelopeVersion4 {
+		return s.openRatchet(envelope)
+	}
+
+	if envelope.GetVersion() == protocol.EnvelopeVersion3 {
+		privateKeyArmor, err := s.privateKey.Armor()
+		if err != nil {
+			return nil, fmt.Errorf("failed to armor private key: %w", err)
+		}
+
+		from, inner, err := protocol.OpenSealedSender(envelope, s.username, privateKeyArmor, s.publicKeyOf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open sealed envelope %s: %w", envelope.ID, err)
+		}
+
+		envelope.From = from
+		return inner, nil
+	}
+
+	senderPublicKey, err := s.publicKeyOf(envelope.From)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyArmor, err := s.privateKey.Armor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor private key: %w", err)
+	}
+
+	inner, err := protocol.OpenEnvelope(envelope, senderPublicKey, privateKeyArmor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open envelope %s: %w", envelope.ID, err)
+	}
+
+	return inner, nil
+}
+
+// DecodeEnvelope opens envelope of any version, verifying sender with Keychain, and returns payload
+// as a value of the registered type, e.g. protocol.TextMessage. Unregistered types return protocol.ErrUnknownPayloadType.
+func (s *SDK) DecodeEnvelope(envelope *protocol.Envelope) (interface{}, error) {
+	inner, err := s.OpenEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := protocol.DecodePayload(inner.PayloadType, inner.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode envelope %s: %w", envelope.ID, err)
+	}
+
+	if receipt, ok := v.(protocol.Receipt); ok {
+		s.receipts.apply(envelope.From, receipt)
+	}
+
+	return v, nil
+}
+
+// publicKeyOf username from Keychain, server key is known from server info
+func (s *SDK) publicKeyOf(username string) (string, error) {
+	if username == "server" {
+		return s.info.PublicKey, nil
+	}
+
+	if s.keychain == nil {
+		return "", fmt.Errorf("keychain is not set")
+	}
+
+	publicKey, err := s.keychain.GetPublicKey(username)
+	if err != nil {
+		return "", fmt.Errorf("failed to get public key of %s: %w", username, err)
+	}
+
+	return publicKey, nil
+}
@@ -0,0 +1,255 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/soul-ua/server/pkg/protocol"
+	"slices"
+	"time"
+	"unicode/utf8"
+)
+
+// maxEmojiSize is enough for any emoji sequence with modifiers
+const maxEmojiSize = 32
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrNotAuthor       = errors.New("only author can change the message")
+)
+
+// SendText returns ID of the sent message, to be used in replies, edits, deletions and reactions,
+// and envelope ID to follow receipts
+func (s *SDK) SendText(to, text string) (string, string, error) {
+	return s.SendReply(to, "", text)
+}
+
+// SendReply to message with replyTo ID, returns ID of the sent message and envelope ID
+func (s *SDK) SendReply(to, replyTo, text string) (string, string, error) {
+	return s.sendText(to, protocol.TextMessage{
+		Text:    text,
+		ReplyTo: replyTo,
+	})
+}
+
+// SendAttachments uploaded with UploadAttachment, text is an optional caption
+func (s *SDK) SendAttachments(to, text string, attachments ...protocol.Attachment) (string, string, error) {
+	return s.sendText(to, protocol.TextMessage{
+		Text:        text,
+		Attachments: attachments,
+	})
+}
+
+// SendDisappearing text, recipient's Conversation drops it expiresIn after receiving and server drops it
+// if it is not delivered in time
+func (s *SDK) SendDisappearing(to, text string, expiresIn time.Duration) (string, string, error) {
+	if expiresIn < time.Second {
+		return "", "", fmt.Errorf("invalid expiration %s", expiresIn)
+	}
+
+	return s.sendText(to, protocol.TextMessage{
+		Text:      text,
+		ExpiresIn: int64(expiresIn / time.Second),
+	})
+}
+
+func (s *SDK) sendText(to string, msg protocol.TextMessage) (string, string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate message ID: %w", err)
+	}
+	msg.ID = id.String()
+
+	envelope, err := s.seal(to, "TextMessage", msg)
+	if err != nil {
+		return "", "", err
+	}
+
+	// server keeps attachments until recipient downloads them
+	for _, attachment := range msg.Attachments {
+		envelope.Attachments = append(envelope.Attachments, attachment.ID)
+	}
+	envelope.TTL = msg.ExpiresIn
+
+	envelopeID, err := s.SendEnvelopeID(envelope)
+	if err != nil {
+		return "", "", err
+	}
+
+	return msg.ID, envelopeID, nil
+}
+
+func (s *SDK) EditMessage(to, messageID, text string) error {
+	_, err := s.SendPayload(to, protocol.MessageEdit{
+		MessageID: messageID,
+		Text:      text,
+	})
+	return err
+}
+
+func (s *SDK) DeleteMessage(to, messageID string) error {
+	_, err := s.SendPayload(to, protocol.MessageDelete{
+		MessageID: messageID,
+	})
+	return err
+}
+
+// React to message with emoji, remove takes back reaction sent before
+func (s *SDK) React(to, messageID, emoji string, remove bool) error {
+	if emoji == "" || len(emoji) > maxEmojiSize || !utf8.ValidString(emoji) {
+		return fmt.Errorf("invalid emoji %q", emoji)
+	}
+
+	_, err := s.SendPayload(to, protocol.Reaction{
+		MessageID: messageID,
+		Emoji:     emoji,
+		Remove:    remove,
+	})
+	return err
+}
+
+// Message is TextMessage with edits, deletion and reactions applied
+type Message struct {
+	ID      string
+	From    string
+	Text    string
+	ReplyTo string
+	Edited  bool
+	Deleted bool // tombstone, Text and Attachments are cleared
+
+	ExpiresAt   time.Time // zero for messages which do not disappear
+	Attachments []protocol.Attachment
+	Reactions   map[string][]string // usernames by emoji
+}
+
+// Conversation interprets message payloads in the order they are received
+type Conversation struct {
+	messages map[string]*Message
+	order    []string
+	now      func() time.Time
+}
+
+func NewConversation() *Conversation {
+	return &Conversation{
+		messages: make(map[string]*Message),
+		now:      time.Now,
+	}
+}
+
+// Apply payload decoded with DecodeEnvelope, from is the verified sender.
+// Payloads which are not messages are ignored.
+func (c *Conversation) Apply(from string, v interface{}) error {
+	switch p := v.(type) {
+	case protocol.TextMessage:
+		if _, ok := c.messages[p.ID]; ok {
+			// duplicate delivery
+			return nil
+		}
+		msg := &Message{
+			ID:      p.ID,
+			From:    from,
+			Text:    p.Text,
+			ReplyTo: p.ReplyTo,
+
+			Attachments: p.Attachments,
+			Reactions:   make(map[string][]string),
+		}
+		if p.ExpiresIn > 0 {
+			msg.ExpiresAt = c.now().Add(time.Duration(p.ExpiresIn) * time.Second)
+		}
+		c.messages[p.ID] = msg
+		c.order = append(c.order, p.ID)
+	case protocol.MessageEdit:
+		msg, err := c.authored(from, p.MessageID)
+		if err != nil {
+			return err
+		}
+		if !msg.Deleted {
+			msg.Text = p.Text
+			msg.Edited = true
+		}
+	case protocol.MessageDelete:
+		msg, err := c.authored(from, p.MessageID)
+		if err != nil {
+			return err
+		}
+		msg.Text = ""
+		msg.Attachments = nil
+		msg.Deleted = true
+		msg.Reactions = make(map[string][]string)
+	case protocol.Reaction:
+		msg, ok := c.messages[p.MessageID]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrMessageNotFound, p.MessageID)
+		}
+		if msg.Deleted {
+			return nil
+		}
+
+		users := slices.DeleteFunc(msg.Reactions[p.Emoji], func(username string) bool {
+			return username == from
+		})
+		if !p.Remove {
+			users = append(users, from)
+		}
+
+		if len(users) == 0 {
+			delete(msg.Reactions, p.Emoji)
+		} else {
+			msg.Reactions[p.Emoji] = users
+		}
+	}
+
+	return nil
+}
+
+// Get message by ID, deleted messages are returned as tombstones, expired ones are not returned
+func (c *Conversation) Get(messageID string) (Message, bool) {
+	msg, ok := c.messages[messageID]
+	if !ok || msg.expired(c.now()) {
+		return Message{}, false
+	}
+	return *msg, true
+}
+
+// Messages in the order they were received, expired ones are skipped
+func (c *Conversation) Messages() []Message {
+	now := c.now()
+	messages := make([]Message, 0, len(c.order))
+	for _, id := range c.order {
+		if msg := c.messages[id]; !msg.expired(now) {
+			messages = append(messages, *msg)
+		}
+	}
+	return messages
+}
+
+// Expire drops disappearing messages expired at now and returns their IDs, so client can wipe
+// them from its own storage. Later edits and reactions to them fail with ErrMessageNotFound.
+func (c *Conversation) Expire(now time.Time) []string {
+	var expired []string
+	c.order = slices.DeleteFunc(c.order, func(id string) bool {
+		if !c.messages[id].expired(now) {
+			return false
+		}
+		delete(c.messages, id)
+		expired = append(expired, id)
+		return true
+	})
+	return expired
+}
+
+func (m *Message) expired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
+}
+
+func (c *Conversation) authored(from, messageID string) (*Message, error) {
+	msg, ok := c.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMessageNotFound, messageID)
+	}
+	if msg.From != from {
+		return nil, ErrNotAuthor
+	}
+	return msg, nil
+}
@@ -0,0 +1,72 @@
+package sdk
+
+import (
+	"errors"
+	"github.com/soul-ua/server/pkg/protocol"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConversationApply(t *testing.T) {
+	c := NewConversation()
+
+	steps := []struct {
+		from string
+		v    interface{}
+		err  error
+	}{
+		{"alice", protocol.TextMessage{ID: "1", Text: "hi"}, nil},
+		{"bob", protocol.TextMessage{ID: "2", Text: "hello", ReplyTo: "1"}, nil},
+		{"bob", protocol.MessageEdit{MessageID: "1", Text: "hacked"}, ErrNotAuthor},
+		{"alice", protocol.MessageEdit{MessageID: "1", Text: "hi there"}, nil},
+		{"bob", protocol.Reaction{MessageID: "1", Emoji: "👍"}, nil},
+		{"alice", protocol.Reaction{MessageID: "1", Emoji: "👍"}, nil},
+		{"bob", protocol.Reaction{MessageID: "1", Emoji: "👍", Remove: true}, nil},
+		{"alice", protocol.Reaction{MessageID: "3", Emoji: "👍"}, ErrMessageNotFound},
+		{"bob", protocol.MessageDelete{MessageID: "2"}, nil},
+		{"bob", protocol.MessageEdit{MessageID: "2", Text: "resurrected"}, nil},
+	}
+
+	for i, step := range steps {
+		if err := c.Apply(step.from, step.v); !errors.Is(err, step.err) {
+			t.Fatalf("Step %d: expected %v, got %v", i, step.err, err)
+		}
+	}
+
+	want := []Message{
+		{ID: "1", From: "alice", Text: "hi there", Edited: true, Reactions: map[string][]string{"👍": {"alice"}}},
+		{ID: "2", From: "bob", ReplyTo: "1", Deleted: true, Reactions: map[string][]string{}},
+	}
+	if got := c.Messages(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected messages:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestConversationExpire(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewConversation()
+	c.now = func() time.Time { return now }
+
+	_ = c.Apply("alice", protocol.TextMessage{ID: "1", Text: "stays"})
+	_ = c.Apply("alice", protocol.TextMessage{ID: "2", Text: "disappears", ExpiresIn: 60})
+
+	if msg, ok := c.Get("2"); !ok || !msg.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Expected message to expire in a minute, got %+v %v", msg, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("2"); ok {
+		t.Error("Expired message should not be returned")
+	}
+	if got := c.Messages(); len(got) != 1 || got[0].ID != "1" {
+		t.Errorf("Unexpected messages %+v", got)
+	}
+
+	if expired := c.Expire(now); !reflect.DeepEqual(expired, []string{"2"}) {
+		t.Errorf("Unexpected expired %v", expired)
+	}
+	if err := c.Apply("bob", protocol.Reaction{MessageID: "2", Emoji: "👍"}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected %v, got %v", ErrMessageNotFound, err)
+	}
+}
@@ -0,0 +1,72 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"slices"
+)
+
+var ErrIncompatibleServer = errors.New("incompatible server")
+
+var (
+	supportedProtocolVersions = []int{1, 2}
+	supportedContentTypes     = []string{protocol.ContentTypeCBOR, protocol.ContentTypeGob}
+)
+
+// negotiate picks the highest protocol version and the first content type in server preference which both sides support
+func negotiate(info protocol.ServerInfo) (int, string, error) {
+	serverVersions := info.ProtocolVersions
+	if len(serverVersions) == 0 {
+		// server before negotiation
+		serverVersions = []int{1}
+	}
+
+	version := 0
+	for _, v := range serverVersions {
+		if v > version && slices.Contains(supportedProtocolVersions, v) {
+			version = v
+		}
+	}
+	if version == 0 {
+		return 0, "", fmt.Errorf("%w: server supports protocol versions %v, client supports %v",
+			ErrIncompatibleServer, serverVersions, supportedProtocolVersions)
+	}
+
+	serverContentTypes := info.Encodings
+	if len(serverContentTypes) == 0 {
+		serverContentTypes = []string{protocol.ContentTypeGob}
+	}
+
+	for _, contentType := range serverContentTypes {
+		if slices.Contains(supportedContentTypes, contentType) {
+			return version, contentType, nil
+		}
+	}
+
+	return 0, "", fmt.Errorf("%w: server supports encodings %v, client supports %v",
+		ErrIncompatibleServer, serverContentTypes, supportedContentTypes)
+}
+
+// Info is server info received and negotiated on SDK creation
+func (s *SDK) Info() protocol.ServerInfo {
+	return s.info
+}
+
+// HasFeature checks optional feature advertised by the server, like protocol.FeatureChats
+func (s *SDK) HasFeature(feature string) bool {
+	return slices.Contains(s.info.Features, feature)
+}
+
+// PadPolicy used to pad payloads before encryption, server policy unless overridden with SetPadPolicy
+func (s *SDK) PadPolicy() protocol.PadPolicy {
+	if s.pad != nil {
+		return *s.pad
+	}
+	return s.info.PadPolicy
+}
+
+// SetPadPolicy overrides server pad policy, e.g. to pad more than the server requires
+func (s *SDK) SetPadPolicy(policy protocol.PadPolicy) {
+	s.pad = &policy
+}
@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+)
+
+// UploadPrekeys publishes identity key, signed prekey and one-time prekeys from SessionStore,
+// returns number of one-time prekeys stored on the server
+func (s *SDK) UploadPrekeys(signedPrekeyID uint32, oneTimePrekeyIDs []uint32) (int, error) {
+	bundle, err := s.NewPrekeyBundle(signedPrekeyID, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	req := protocol.UploadPrekeysRequest{
+		IdentityKey:    bundle.IdentityKey,
+		SignedPrekeyID: bundle.SignedPrekeyID,
+		SignedPrekey:   bundle.SignedPrekey,
+		Signature:      bundle.Signature,
+		OneTimePrekeys: make([]protocol.OneTimePrekey, 0, len(oneTimePrekeyIDs)),
+	}
+
+	for _, id := range oneTimePrekeyIDs {
+		prekey, err := s.sessions.Prekey(id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get one-time prekey %d: %w", id, err)
+		}
+		req.OneTimePrekeys = append(req.OneTimePrekeys, protocol.OneTimePrekey{
+			ID:  id,
+			Key: prekey.Public,
+		})
+	}
+
+	data, _ := json.Marshal(req)
+
+	var res protocol.UploadPrekeysResponse
+	body, err := s.Request("POST", "/prekeys", data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload prekeys: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &res); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res.OneTimePrekeys, nil
+}
+
+// FetchPrekeyBundle of username, signature is verified with public key from Keychain
+func (s *SDK) FetchPrekeyBundle(username string) (protocol.PrekeyBundle, error) {
+	req, _ := json.Marshal(protocol.FetchPrekeyBundleRequest{
+		Username: username,
+	})
+
+	var bundle protocol.PrekeyBundle
+	body, err := s.Request("POST", "/prekeys/bundle", req)
+	if err != nil {
+		return bundle, fmt.Errorf("failed to fetch prekey bundle: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &bundle); err != nil {
+		return bundle, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if bundle.Username != username {
+		return bundle, fmt.Errorf("server returned prekey bundle of %s instead of %s", bundle.Username, username)
+	}
+
+	publicKey, err := s.publicKeyOf(username)
+	if err != nil {
+		return bundle, err
+	}
+
+	if err := bundle.Verify(publicKey); err != nil {
+		return bundle, err
+	}
+
+	return bundle, nil
+}
+
+// StartSessionWith username using prekey bundle fetched from the server
+func (s *SDK) StartSessionWith(username string) error {
+	bundle, err := s.FetchPrekeyBundle(username)
+	if err != nil {
+		return err
+	}
+
+	return s.StartSession(bundle)
+}
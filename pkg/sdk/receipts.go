@@ -0,0 +1,225 @@
+package sdk
+
+import (
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"log"
+	"sync"
+	"time"
+)
+
+// ReceiptSent is status of outgoing envelope accepted by the server but not yet acknowledged by recipient
+const ReceiptSent = "sent"
+
+var receiptRank = map[string]int{
+	ReceiptSent:               0,
+	protocol.ReceiptDelivered: 1,
+	protocol.ReceiptRead:      2,
+}
+
+// DefaultReceiptMaxAge after which envelopes without progress are no longer tracked
+const DefaultReceiptMaxAge = 7 * 24 * time.Hour
+
+// ReceiptState of outgoing envelope
+type ReceiptState struct {
+	To        string
+	Status    string // ReceiptSent, protocol.ReceiptDelivered or protocol.ReceiptRead
+	UpdatedAt time.Time
+}
+
+// ReceiptTracker follows status of outgoing envelopes, it is fed by envelopes sent through SDK
+// and by receipts decoded with DecodeEnvelope. State is kept in memory only, envelopes are dropped
+// once they are read or when they are not updated for MaxAge.
+type ReceiptTracker struct {
+	mu       sync.Mutex
+	states   map[string]*ReceiptState
+	prunedAt time.Time
+
+	// OnChange is called without lock held when status of an envelope goes forward,
+	// read status is reported last, the envelope is not tracked after it
+	OnChange func(envelopeID string, state ReceiptState)
+
+	// MaxAge of envelope state since last update, zero keeps states until they are read
+	MaxAge time.Duration
+}
+
+func NewReceiptTracker() *ReceiptTracker {
+	return &ReceiptTracker{
+		states: make(map[string]*ReceiptState),
+		MaxAge: DefaultReceiptMaxAge,
+	}
+}
+
+// Track outgoing envelope, called by SDK for every sent envelope
+func (t *ReceiptTracker) Track(envelopeID, to string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	if _, ok := t.states[envelopeID]; !ok {
+		t.states[envelopeID] = &ReceiptState{
+			To:        to,
+			Status:    ReceiptSent,
+			UpdatedAt: now,
+		}
+	}
+}
+
+// prune states not updated for MaxAge at most once a minute, called with lock held
+func (t *ReceiptTracker) prune(now time.Time) {
+	if t.MaxAge <= 0 || now.Sub(t.prunedAt) < time.Minute {
+		return
+	}
+	t.prunedAt = now
+
+	for envelopeID, state := range t.states {
+		if now.Sub(state.UpdatedAt) > t.MaxAge {
+			delete(t.states, envelopeID)
+		}
+	}
+}
+
+// State of outgoing envelope, false if it is not tracked
+func (t *ReceiptTracker) State(envelopeID string) (ReceiptState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.states[envelopeID]
+	if !ok {
+		return ReceiptState{}, false
+	}
+	return *state, true
+}
+
+// Forget envelope, e.g. when it is deleted and no longer shown as pending
+func (t *ReceiptTracker) Forget(envelopeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.states, envelopeID)
+}
+
+// apply receipt from verified sender, only recipient of the envelope can acknowledge it and status never goes back
+func (t *ReceiptTracker) apply(from string, receipt protocol.Receipt) {
+	rank, ok := receiptRank[receipt.Status]
+	if !ok || receipt.Status == ReceiptSent {
+		return
+	}
+
+	type change struct {
+		envelopeID string
+		state      ReceiptState
+	}
+	var changes []change
+
+	t.mu.Lock()
+	now := time.Now()
+	for _, envelopeID := range receipt.EnvelopeIDs {
+		state, ok := t.states[envelopeID]
+		if !ok || state.To != from || receiptRank[state.Status] >= rank {
+			continue
+		}
+		state.Status = receipt.Status
+		state.UpdatedAt = now
+		changes = append(changes, change{envelopeID, *state})
+		if receipt.Status == protocol.ReceiptRead {
+			delete(t.states, envelopeID)
+		}
+	}
+	t.prune(now)
+	onChange := t.OnChange
+	t.mu.Unlock()
+
+	if onChange != nil {
+		for _, c := range changes {
+			onChange(c.envelopeID, c.state)
+		}
+	}
+}
+
+// Receipts tracks status of envelopes sent by this SDK
+func (s *SDK) Receipts() *ReceiptTracker {
+	return s.receipts
+}
+
+// SetAutoReceipts enables or disables delivery receipts sent when envelopes are opened, they are enabled by default
+func (s *SDK) SetAutoReceipts(enabled bool) {
+	s.noAutoReceipts = !enabled
+}
+
+// SendReceipt acknowledges envelopes received from username. Receipts are sealed like any other payload,
+// so server can't tell them from messages; receipt envelopes are not tracked as they are never acknowledged.
+func (s *SDK) SendReceipt(to, status string, envelopeIDs ...string) error {
+	if status != protocol.ReceiptDelivered && status != protocol.ReceiptRead {
+		return fmt.Errorf("invalid receipt status %q", status)
+	}
+
+	envelopeID, err := s.SendPayload(to, protocol.Receipt{
+		EnvelopeIDs: envelopeIDs,
+		Status:      status,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.receipts.Forget(envelopeID)
+	return nil
+}
+
+// sendDeliveryReceipt for opened envelope, receipts, server notifications, ephemeral and sealed sender
+// envelopes are skipped. Envelopes stay in the inbox until AckInbox, so acknowledged IDs are remembered to not send
+// receipts again when the envelope is fetched and opened once more.
+func (s *SDK) sendDeliveryReceipt(envelope *protocol.Envelope, inner *protocol.InnerEnvelope) {
+	if s.noAutoReceipts || inner.PayloadType == "Receipt" || envelope.Ephemeral || envelope.GetVersion() == protocol.EnvelopeVersion3 ||
+		envelope.From == "" || envelope.From == "server" || envelope.From == s.username || envelope.ID == "" {
+		return
+	}
+
+	s.deliveredMu.Lock()
+	_, delivered := s.delivered[envelope.ID]
+	s.deliveredMu.Unlock()
+	if delivered {
+		return
+	}
+
+	if err := s.SendReceipt(envelope.From, protocol.ReceiptDelivered, envelope.ID); err != nil {
+		// envelope is already opened, receipt is best effort and retried when it is opened again
+		log.Println("failed to send delivery receipt to", envelope.From, err)
+		return
+	}
+
+	s.deliveredMu.Lock()
+	s.delivered[envelope.ID] = time.Now()
+	s.deliveredMu.Unlock()
+}
+
+// pruneDelivered forgets envelopes acknowledged longer than server keeps envelopes ago, they are expired
+// and not fetched again. Servers without retention limit keep envelopes until AckInbox, DefaultReceiptMaxAge
+// is used for them.
+func (s *SDK) pruneDelivered(now time.Time) {
+	retention := time.Duration(s.info.MaxRetention) * time.Second
+	if retention <= 0 {
+		retention = DefaultReceiptMaxAge
+	}
+
+	s.deliveredMu.Lock()
+	defer s.deliveredMu.Unlock()
+
+	for envelopeID, deliveredAt := range s.delivered {
+		if now.Sub(deliveredAt) > retention {
+			delete(s.delivered, envelopeID)
+		}
+	}
+}
+
+// forgetDelivered envelopes deleted from the inbox, they are not fetched again
+func (s *SDK) forgetDelivered(envelopeIDs []string) {
+	s.deliveredMu.Lock()
+	defer s.deliveredMu.Unlock()
+
+	for _, envelopeID := range envelopeIDs {
+		delete(s.delivered, envelopeID)
+	}
+}
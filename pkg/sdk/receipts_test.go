@@ -0,0 +1,86 @@
+package sdk
+
+import (
+	"github.com/soul-ua/server/pkg/protocol"
+	"testing"
+	"time"
+)
+
+func TestReceiptTracker(t *testing.T) {
+	tracker := NewReceiptTracker()
+	tracker.Track("1", "bob")
+	tracker.Track("2", "bob")
+
+	var changed []string
+	tracker.OnChange = func(envelopeID string, state ReceiptState) {
+		changed = append(changed, envelopeID+":"+state.Status)
+	}
+
+	tracker.apply("mallory", protocol.Receipt{EnvelopeIDs: []string{"1"}, Status: protocol.ReceiptRead})
+	tracker.apply("bob", protocol.Receipt{EnvelopeIDs: []string{"1", "2", "3"}, Status: protocol.ReceiptDelivered})
+	tracker.apply("bob", protocol.Receipt{EnvelopeIDs: []string{"1"}, Status: protocol.ReceiptRead})
+	tracker.apply("bob", protocol.Receipt{EnvelopeIDs: []string{"1"}, Status: protocol.ReceiptDelivered})
+
+	if state, _ := tracker.State("2"); state.Status != protocol.ReceiptDelivered {
+		t.Errorf("Expected %s for envelope 2, got %s", protocol.ReceiptDelivered, state.Status)
+	}
+
+	if _, ok := tracker.State("1"); ok {
+		t.Errorf("Read envelope should no longer be tracked")
+	}
+
+	if _, ok := tracker.State("3"); ok {
+		t.Errorf("Receipt should not start tracking unknown envelopes")
+	}
+
+	if len(changed) != 3 || changed[2] != "1:"+protocol.ReceiptRead {
+		t.Errorf("Expected 3 changes ending with read, got %v", changed)
+	}
+}
+
+func TestReceiptTrackerMaxAge(t *testing.T) {
+	tracker := NewReceiptTracker()
+	tracker.MaxAge = time.Hour
+	tracker.Track("1", "bob")
+	tracker.Track("2", "bob")
+
+	tracker.mu.Lock()
+	tracker.states["1"].UpdatedAt = time.Now().Add(-2 * time.Hour)
+	tracker.prunedAt = time.Time{}
+	tracker.mu.Unlock()
+
+	tracker.Track("3", "bob")
+
+	if _, ok := tracker.State("1"); ok {
+		t.Errorf("Envelope older than MaxAge should no longer be tracked")
+	}
+	for _, envelopeID := range []string{"2", "3"} {
+		if _, ok := tracker.State(envelopeID); !ok {
+			t.Errorf("Expected envelope %s to be tracked", envelopeID)
+		}
+	}
+}
+
+func TestPruneDelivered(t *testing.T) {
+	now := time.Now()
+	s := &SDK{
+		info: protocol.ServerInfo{MaxRetention: 60},
+		delivered: map[string]time.Time{
+			"1": now.Add(-2 * time.Minute),
+			"2": now,
+		},
+	}
+
+	s.pruneDelivered(now)
+	if _, ok := s.delivered["1"]; ok {
+		t.Errorf("Expected envelope acknowledged before retention to be forgotten")
+	}
+	if _, ok := s.delivered["2"]; !ok {
+		t.Errorf("Expected recently acknowledged envelope to be remembered")
+	}
+
+	s.forgetDelivered([]string{"2"})
+	if len(s.delivered) != 0 {
+		t.Errorf("Expected acknowledged envelope to be forgotten, got %v", s.delivered)
+	}
+}
@@ -1,19 +1,47 @@
 package sdk
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/soul-ua/server/pkg/protocol"
 	"log"
+	"time"
 )
 
+// Register new account, proof of work is solved when server requires it, which may take a while,
+// use RegisterContext to give up. Servers which require invite codes need RegisterWithInvite.
 func (s *SDK) Register(username, publicKeyArmor string) error {
-	req, _ := json.Marshal(protocol.RegisterRequest{
-		Username:  username,
-		PublicKey: publicKeyArmor,
-	})
+	return s.RegisterContext(context.Background(), username, publicKeyArmor)
+}
+
+// RegisterContext is Register which stops solving proof of work when ctx is cancelled
+func (s *SDK) RegisterContext(ctx context.Context, username, publicKeyArmor string) error {
+	return s.RegisterWithInviteContext(ctx, username, publicKeyArmor, "")
+}
+
+// RegisterWithInvite code issued by server admin
+func (s *SDK) RegisterWithInvite(username, publicKeyArmor, inviteCode string) error {
+	return s.RegisterWithInviteContext(context.Background(), username, publicKeyArmor, inviteCode)
+}
+
+// RegisterWithInviteContext is RegisterWithInvite which stops solving proof of work when ctx is cancelled
+func (s *SDK) RegisterWithInviteContext(ctx context.Context, username, publicKeyArmor, inviteCode string) error {
+	registerRequest := protocol.RegisterRequest{
+		Username:   username,
+		PublicKey:  publicKeyArmor,
+		InviteCode: inviteCode,
+	}
+
+	if policy := s.info.Registration; policy.Mode == protocol.RegistrationProofOfWork {
+		nonce, err := protocol.SolveProofOfWork(ctx, username, publicKeyArmor, policy.Difficulty)
+		if err != nil {
+			return fmt.Errorf("failed to solve proof of work: %w", err)
+		}
+		registerRequest.ProofOfWork = nonce
+	}
+
+	req, _ := json.Marshal(registerRequest)
 
 	var res protocol.RegisterResponse
 	body, err := s.Request("POST", "/register", req)
@@ -41,32 +69,53 @@ func (s *SDK) ContactRequest(username string) error {
 	return nil
 }
 
+// GetInbox fetches envelopes since envelope ID, delivery receipts are sent when envelopes are opened
 func (s *SDK) GetInbox(sinceID string) ([]*protocol.Envelope, error) {
 	req, _ := json.Marshal(protocol.GetInboxRequest{
 		SinceID: sinceID,
 	})
-	body, err := s.Request("POST", "/inbox", req)
+	body, contentType, err := s.RequestContentType("POST", "/inbox", req, "", s.contentType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get inbox: %w", err)
 	}
 
 	log.Println("inbox received")
 
-	var res protocol.GetInboxResponse
-	responseBuf := bytes.NewBuffer(body)
-	dec := gob.NewDecoder(responseBuf)
-	if err = dec.Decode(&res); err != nil {
+	if contentType != protocol.ContentTypeCBOR {
+		// old servers respond with gob labeled as json
+		contentType = protocol.ContentTypeGob
+	}
+
+	res, err := protocol.UnpackGetInboxResponse(contentType, body)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	result := make([]*protocol.Envelope, len(res.Envelopes))
 	for i, envelopePacked := range res.Envelopes {
-		envelope, err := protocol.UnpackEnvelope(envelopePacked)
+		envelope, err := protocol.UnpackEnvelopeAs(contentType, envelopePacked)
 		if err != nil {
 			return nil, fmt.Errorf("failed to unpack envelope[%d]: %w", i, err)
 		}
 		result[i] = envelope
 	}
 
+	s.pruneDelivered(time.Now())
+
 	return result, nil
 }
+
+// AckInbox deletes processed envelopes from the inbox on the server, unacknowledged envelopes count to
+// inbox limits until they expire, and senders get protocol.ErrInboxFull when the inbox is full
+func (s *SDK) AckInbox(envelopeIDs ...string) error {
+	req, _ := json.Marshal(protocol.AckInboxRequest{
+		EnvelopeIDs: envelopeIDs,
+	})
+
+	if _, err := s.Request("POST", "/inbox/ack", req); err != nil {
+		return fmt.Errorf("failed to acknowledge inbox: %w", err)
+	}
+	s.forgetDelivered(envelopeIDs)
+
+	return nil
+}
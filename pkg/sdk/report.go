@@ -0,0 +1,48 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+)
+
+// Report received envelope to server moderators. With disclose the envelope session key is sent along,
+// so moderators can read the envelope and verify it was signed by the sender; other envelopes stay private.
+// Only envelopes of version 1 and 2 can be disclosed.
+func (s *SDK) Report(envelope *protocol.Envelope, reason string, disclose bool) (protocol.ReportResponse, error) {
+	packed, err := envelope.PackAs(protocol.ContentTypeCBOR)
+	if err != nil {
+		return protocol.ReportResponse{}, fmt.Errorf("failed to pack envelope: %w", err)
+	}
+
+	reportRequest := protocol.ReportRequest{
+		Envelope: packed,
+		Reason:   reason,
+	}
+
+	if disclose {
+		privateKeyArmor, err := s.privateKey.Armor()
+		if err != nil {
+			return protocol.ReportResponse{}, fmt.Errorf("failed to armor private key: %w", err)
+		}
+
+		reportRequest.Disclosure, err = protocol.DiscloseEnvelope(envelope, privateKeyArmor)
+		if err != nil {
+			return protocol.ReportResponse{}, fmt.Errorf("failed to disclose envelope: %w", err)
+		}
+	}
+
+	req, _ := json.Marshal(reportRequest)
+
+	rsp, err := s.Request("POST", "/report", req)
+	if err != nil {
+		return protocol.ReportResponse{}, fmt.Errorf("failed to report envelope: %w", err)
+	}
+
+	var res protocol.ReportResponse
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return protocol.ReportResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res, nil
+}
@@ -3,12 +3,17 @@ package sdk
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/soul-ua/server/pkg/protocol"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Keychain interface {
@@ -23,6 +28,25 @@ type SDK struct {
 
 	username   string
 	privateKey *crypto.Key
+
+	// negotiated with server on SDK creation
+	protocolVersion int
+	contentType     string
+
+	// overrides pad policy from server info when set
+	pad *protocol.PadPolicy
+
+	sessions SessionStore
+
+	receipts *ReceiptTracker
+	// noAutoReceipts disables delivery receipts sent from OpenEnvelope
+	noAutoReceipts bool
+	// delivered envelopes in the inbox already acknowledged with delivery receipt
+	deliveredMu sync.Mutex
+	delivered   map[string]time.Time
+
+	// lastAdminTime of admin requests, server accepts every Time once
+	lastAdminTime atomic.Int64
 }
 
 func NewSDKArmor(serverURL string, keychain Keychain, username, privateKeyArmor string) (*SDK, error) {
@@ -41,6 +65,9 @@ func NewSDK(serverURL string, keychain Keychain, username string, key *crypto.Ke
 
 		username:   username,
 		privateKey: key,
+
+		receipts:  NewReceiptTracker(),
+		delivered: make(map[string]time.Time),
 	}
 
 	info, err := s.GetServerInfo()
@@ -48,6 +75,11 @@ func NewSDK(serverURL string, keychain Keychain, username string, key *crypto.Ke
 		return nil, fmt.Errorf("failed to get server info: %w", err)
 	}
 
+	s.protocolVersion, s.contentType, err = negotiate(info)
+	if err != nil {
+		return nil, err
+	}
+
 	s.info = info
 
 	return s, nil
@@ -79,57 +111,143 @@ func (s *SDK) GetServerInfo() (protocol.ServerInfo, error) {
 }
 
 func (s *SDK) Request(method, path string, data []byte) ([]byte, error) {
-	r, err := http.NewRequest(method, s.serverURL+path, bytes.NewReader(data))
+	body, _, err := s.RequestContentType(method, path, data, "", "")
+	return body, err
+}
+
+// RequestContentType sends signed request with Content-Type and Accept headers (if not empty),
+// returns verified response body and its content type
+func (s *SDK) RequestContentType(method, path string, data []byte, contentType, accept string) ([]byte, string, error) {
+	r, err := s.newRequest(method, path, data, contentType, accept)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, "", err
 	}
 
+	if err := s.signRequest(r, data); err != nil {
+		return nil, "", err
+	}
+
+	return s.do(r)
+}
+
+// signRequest adds user signature of data, which should be the request body
+func (s *SDK) signRequest(r *http.Request, data []byte) error {
 	pgpSignatureBase64, err := protocol.Sign(data, s.privateKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign data: %w", err)
+		return fmt.Errorf("failed to sign data: %w", err)
 	}
 
 	r.Header.Add("soul-username", s.username)
 	r.Header.Add("PGP-Signature", pgpSignatureBase64)
 
+	return nil
+}
+
+// newRequest without user signature
+func (s *SDK) newRequest(method, path string, data []byte, contentType, accept string) (*http.Request, error) {
+	if s.info.MaxPayloadSize > 0 && int64(len(data)) > s.info.MaxPayloadSize {
+		return nil, fmt.Errorf("%w: %d bytes, server accepts up to %d", protocol.ErrPayloadTooLarge, len(data), s.info.MaxPayloadSize)
+	}
+
+	r, err := http.NewRequest(method, s.serverURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	r.Header.Set(protocol.ProtocolVersionHeader, strconv.Itoa(s.protocolVersion))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+
+	return r, nil
+}
+
+// do sends request and verifies server signature of the response, error responses are returned as *protocol.Error
+func (s *SDK) do(r *http.Request) ([]byte, string, error) {
 	log.Println("req sent")
 	rsp, err := http.DefaultClient.Do(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, "", fmt.Errorf("failed to send request: %w", err)
 	}
-	defer rsp.Body.Close()
 	log.Println("req done")
 
+	return s.readResponse(rsp)
+}
+
+// readResponse reads and closes response body and verifies server signature
+func (s *SDK) readResponse(rsp *http.Response) ([]byte, string, error) {
+	defer rsp.Body.Close()
+
 	body, err := io.ReadAll(rsp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, "", fmt.Errorf("failed to read response: %w", err)
 	}
 	log.Println("req read done")
 
-	pgpSignatureBase64 = rsp.Header.Get("PGP-Signature")
+	pgpSignatureBase64 := rsp.Header.Get("PGP-Signature")
+	if pgpSignatureBase64 == "" && rsp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("server responded with unsigned status %d: %s", rsp.StatusCode, string(body))
+	}
 
 	if err = protocol.VerifySignArmor(body, pgpSignatureBase64, s.info.PublicKey); err != nil {
-		return nil, fmt.Errorf("failed to verify response sign: %w", err)
+		return nil, "", fmt.Errorf("failed to verify response sign: %w", err)
 	}
 
 	log.Println("req verify done")
 
-	return body, nil
+	if rsp.StatusCode != http.StatusOK {
+		var rspErr protocol.Error
+		if err = json.Unmarshal(body, &rspErr); err != nil {
+			return nil, "", fmt.Errorf("failed to decode error response with status %d: %w", rsp.StatusCode, err)
+		}
+		return nil, "", &rspErr
+	}
+
+	return body, rsp.Header.Get("Content-Type"), nil
+}
+
+// RetryAfter tells how long to wait before retrying request rejected with protocol.ErrRateLimited,
+// false if err is not a rate limit error
+func RetryAfter(err error) (time.Duration, bool) {
+	var rspErr *protocol.Error
+	if !errors.As(err, &rspErr) || rspErr.Code != protocol.ErrorCodeRateLimited {
+		return 0, false
+	}
+	return time.Duration(rspErr.RetryAfter) * time.Second, true
 }
 
-// SendEnvelope just send envelope to the server
+// SendEnvelope just send envelope to the server, envelope ID assigned by the server is set to envelope.ID
 func (s *SDK) SendEnvelope(envelop *protocol.Envelope) error {
+	_, err := s.SendEnvelopeID(envelop)
+	return err
+}
+
+// SendEnvelopeID sends envelope to the server, returns envelope ID assigned by the server which is tracked in Receipts.
+// Error matches protocol.ErrInboxFull with errors.Is when recipient inbox is full, sending can be retried later.
+func (s *SDK) SendEnvelopeID(envelop *protocol.Envelope) (string, error) {
 	envelop.From = s.username
-	packed, err := envelop.Pack()
+	packed, err := envelop.PackAs(s.contentType)
 	if err != nil {
-		return fmt.Errorf("failed to pack envelope: %w", err)
+		return "", fmt.Errorf("failed to pack envelope: %w", err)
 	}
 
-	rsp, err := s.Request("POST", "/send", packed)
+	rsp, _, err := s.RequestContentType("POST", "/send", packed, s.contentType, "")
 	if err != nil {
-		return fmt.Errorf("failed to send envelope: %w", err)
+		return "", fmt.Errorf("failed to send envelope: %w", err)
 	}
 
 	log.Println("Send envelope response:", string(rsp))
-	return nil
+
+	var res protocol.SendResponse
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	envelop.ID = res.ID
+	s.receipts.Track(res.ID, envelop.To)
+
+	return res.ID, nil
 }
@@ -1,20 +1,100 @@
 package sdk
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/soul-ua/server/pkg/protocol"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
+// newTestServer serves signed info the same way webserver does
+func newTestServer(t *testing.T, info protocol.ServerInfo) *httptest.Server {
+	serverPrivateKey, serverPublicKey, err := protocol.GeneratePair("server", "server@test")
+	if err != nil {
+		t.Fatalf("Error generating server keys: %v", err)
+	}
+	key, err := crypto.NewKeyFromArmored(serverPrivateKey)
+	if err != nil {
+		t.Fatalf("Error parsing server key: %v", err)
+	}
+
+	info.PublicKey = serverPublicKey
+	srv := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(info)
+		signature, err := protocol.Sign(data, key)
+		if err != nil {
+			t.Errorf("Error signing server info: %v", err)
+		}
+		wr.Header().Set("PGP-Signature", signature)
+		_, _ = wr.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newTestKey(t *testing.T) *crypto.Key {
+	privateKey, _, err := protocol.GeneratePair("alice", "alice@test")
+	if err != nil {
+		t.Fatalf("Error generating keys: %v", err)
+	}
+	key, err := crypto.NewKeyFromArmored(privateKey)
+	if err != nil {
+		t.Fatalf("Error parsing key: %v", err)
+	}
+	return key
+}
+
 func TestNewSDK(t *testing.T) {
-	s, err := NewSDK("http://localhost:8080")
+	srv := newTestServer(t, protocol.ServerInfo{
+		Version:          "test",
+		ProtocolVersions: []int{protocol.ProtocolVersion},
+		Encodings:        []string{protocol.ContentTypeCBOR, protocol.ContentTypeGob},
+		Features:         []string{protocol.FeatureChats},
+	})
+
+	s, err := NewSDK(srv.URL, nil, "alice", newTestKey(t))
 	if err != nil {
-		t.Errorf("Error creating SDK: %v", err)
+		t.Fatalf("Error creating SDK: %v", err)
+	}
+
+	if s.protocolVersion != protocol.ProtocolVersion || s.contentType != protocol.ContentTypeCBOR {
+		t.Errorf("Negotiated version %d and %s", s.protocolVersion, s.contentType)
 	}
 
-	info, err := s.GetServerInfo()
+	if !s.HasFeature(protocol.FeatureChats) || s.HasFeature(protocol.FeatureFederation) {
+		t.Errorf("Unexpected features %v", s.Info().Features)
+	}
+}
+
+func TestNewSDKIncompatibleServer(t *testing.T) {
+	srv := newTestServer(t, protocol.ServerInfo{
+		ProtocolVersions: []int{protocol.ProtocolVersion + 1},
+	})
+
+	_, err := NewSDK(srv.URL, nil, "alice", newTestKey(t))
+	if !errors.Is(err, ErrIncompatibleServer) {
+		t.Errorf("Expected ErrIncompatibleServer, got %v", err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	version, contentType, err := negotiate(protocol.ServerInfo{})
 	if err != nil {
-		t.Errorf("Error getting server info: %v", err)
+		t.Fatalf("Error negotiating with legacy server: %v", err)
+	}
+	if version != 1 || contentType != protocol.ContentTypeGob {
+		t.Errorf("Negotiated version %d and %s with legacy server", version, contentType)
 	}
 
-	log.Println(info)
+	_, _, err = negotiate(protocol.ServerInfo{
+		ProtocolVersions: []int{protocol.ProtocolVersion},
+		Encodings:        []string{"application/protobuf"},
+	})
+	if !errors.Is(err, ErrIncompatibleServer) {
+		t.Errorf("Expected ErrIncompatibleServer for unknown encoding, got %v", err)
+	}
 }
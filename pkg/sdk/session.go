@@ -0,0 +1,250 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"github.com/soul-ua/server/pkg/ratchet"
+	"time"
+)
+
+var ErrNoSession = errors.New("no session with user, start it with StartSession")
+
+// SessionStore keeps double ratchet keys and sessions on the client, they never leave the device.
+// Sessions are saved after every sent and received message, so the store should persist them durably.
+type SessionStore interface {
+	// Identity is long-term X25519 key pair of the current user, published in prekey bundles
+	Identity() (ratchet.KeyPair, error)
+	// Prekey returns signed or one-time prekey by ID
+	Prekey(id uint32) (ratchet.KeyPair, error)
+	// DeletePrekey is called after one-time prekey is used
+	DeletePrekey(id uint32) error
+	// Session returns nil without error if there is no session with username
+	Session(username string) (*ratchet.Session, error)
+	SaveSession(username string, session *ratchet.Session) error
+}
+
+// SetSessionStore enables forward secret messaging with SendRatchet
+func (s *SDK) SetSessionStore(sessions SessionStore) {
+	s.sessions = sessions
+}
+
+// NewPrekeyBundle signs identity key and signed prekey from SessionStore with account key,
+// oneTimePrekeyID is optional and can be 0
+func (s *SDK) NewPrekeyBundle(signedPrekeyID, oneTimePrekeyID uint32) (protocol.PrekeyBundle, error) {
+	if s.sessions == nil {
+		return protocol.PrekeyBundle{}, fmt.Errorf("session store is not set")
+	}
+
+	identity, err := s.sessions.Identity()
+	if err != nil {
+		return protocol.PrekeyBundle{}, fmt.Errorf("failed to get identity key: %w", err)
+	}
+
+	signedPrekey, err := s.sessions.Prekey(signedPrekeyID)
+	if err != nil {
+		return protocol.PrekeyBundle{}, fmt.Errorf("failed to get signed prekey: %w", err)
+	}
+
+	bundle := protocol.PrekeyBundle{
+		Username:       s.username,
+		IdentityKey:    identity.Public,
+		SignedPrekeyID: signedPrekeyID,
+		SignedPrekey:   signedPrekey.Public,
+	}
+
+	if bundle.Signature, err = protocol.Sign(bundle.SignedData(), s.privateKey); err != nil {
+		return protocol.PrekeyBundle{}, err
+	}
+
+	if oneTimePrekeyID != 0 {
+		oneTimePrekey, err := s.sessions.Prekey(oneTimePrekeyID)
+		if err != nil {
+			return protocol.PrekeyBundle{}, fmt.Errorf("failed to get one-time prekey: %w", err)
+		}
+		bundle.OneTimePrekeyID = oneTimePrekeyID
+		bundle.OneTimePrekey = oneTimePrekey.Public
+	}
+
+	return bundle, nil
+}
+
+// StartSession with bundle owner, bundle is verified with the owner public key from Keychain
+func (s *SDK) StartSession(bundle protocol.PrekeyBundle) error {
+	if s.sessions == nil {
+		return fmt.Errorf("session store is not set")
+	}
+
+	publicKey, err := s.publicKeyOf(bundle.Username)
+	if err != nil {
+		return err
+	}
+
+	if err := bundle.Verify(publicKey); err != nil {
+		return err
+	}
+
+	identity, err := s.sessions.Identity()
+	if err != nil {
+		return fmt.Errorf("failed to get identity key: %w", err)
+	}
+
+	session, err := ratchet.Initiate(identity, bundle)
+	if err != nil {
+		return fmt.Errorf("failed to initiate session: %w", err)
+	}
+
+	// responder can't look up our identity key, so it is vouched by the account key
+	if session.Handshake.IdentitySignature, err = protocol.Sign(identity.Public, s.privateKey); err != nil {
+		return err
+	}
+
+	return s.sessions.SaveSession(bundle.Username, session)
+}
+
+// SendRatchet sends v as JSON payload through double ratchet session started with StartSession, returns envelope ID
+func (s *SDK) SendRatchet(to, payloadType string, v interface{}) (string, error) {
+	if s.sessions == nil {
+		return "", fmt.Errorf("session store is not set")
+	}
+
+	session, err := s.sessions.Session(to)
+	if err != nil {
+		return "", fmt.Errorf("failed to load session: %w", err)
+	}
+	if session == nil {
+		return "", ErrNoSession
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	inner, err := json.Marshal(protocol.InnerEnvelope{
+		PayloadType: payloadType,
+		Payload:     data,
+		SentAt:      time.Now().Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal inner envelope: %w", err)
+	}
+
+	header, payload, err := session.Encrypt(s.PadPolicy().Pad(inner))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt envelope: %w", err)
+	}
+
+	// message key must not be reused even if sending fails
+	if err := s.sessions.SaveSession(to, session); err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+
+	return s.SendEnvelopeID(&protocol.Envelope{
+		To:      to,
+		Payload: payload,
+		Version: protocol.EnvelopeVersion4,
+		Ratchet: &header,
+	})
+}
+
+// openRatchet decrypts EnvelopeVersion4 envelope, session is created from handshake if there is none
+// or if the sender has started a new one. Handshake the current session is built from is never responded again,
+// so a replayed first message can't rewind the session.
+func (s *SDK) openRatchet(envelope *protocol.Envelope) (*protocol.InnerEnvelope, error) {
+	if s.sessions == nil {
+		return nil, fmt.Errorf("session store is not set")
+	}
+	if envelope.Ratchet == nil {
+		return nil, fmt.Errorf("envelope %s has no ratchet header", envelope.ID)
+	}
+	header := *envelope.Ratchet
+
+	session, err := s.sessions.Session(envelope.From)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	var data []byte
+	if session != nil {
+		data, err = session.Decrypt(header, envelope.Payload)
+	}
+
+	responded := session == nil ||
+		(err != nil && header.IsHandshake() && !bytes.Equal(header.EphemeralKey, session.HandshakeKey))
+	if responded {
+		session, err = s.respond(envelope.From, header)
+		if err != nil {
+			return nil, err
+		}
+		data, err = session.Decrypt(header, envelope.Payload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt envelope %s: %w", envelope.ID, err)
+	}
+
+	if err := s.sessions.SaveSession(envelope.From, session); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
+
+	if responded && header.OneTimePrekeyID != 0 {
+		if err := s.sessions.DeletePrekey(header.OneTimePrekeyID); err != nil {
+			return nil, fmt.Errorf("failed to delete one-time prekey: %w", err)
+		}
+	}
+
+	data, err = protocol.Unpad(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var inner protocol.InnerEnvelope
+	if err := json.Unmarshal(data, &inner); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal inner envelope: %w", err)
+	}
+
+	return &inner, nil
+}
+
+func (s *SDK) respond(from string, header protocol.RatchetHeader) (*ratchet.Session, error) {
+	if !header.IsHandshake() {
+		return nil, ErrNoSession
+	}
+
+	publicKey, err := s.publicKeyOf(from)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := protocol.VerifySignArmor(header.IdentityKey, header.IdentitySignature, publicKey); err != nil {
+		return nil, fmt.Errorf("failed to verify identity key of %s: %w", from, err)
+	}
+
+	identity, err := s.sessions.Identity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identity key: %w", err)
+	}
+
+	signedPrekey, err := s.sessions.Prekey(header.SignedPrekeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signed prekey %d: %w", header.SignedPrekeyID, err)
+	}
+
+	var oneTimePrekey *ratchet.KeyPair
+	if header.OneTimePrekeyID != 0 {
+		key, err := s.sessions.Prekey(header.OneTimePrekeyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get one-time prekey %d: %w", header.OneTimePrekeyID, err)
+		}
+		oneTimePrekey = &key
+	}
+
+	session, err := ratchet.Respond(identity, signedPrekey, oneTimePrekey, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to respond to handshake: %w", err)
+	}
+
+	return session, nil
+}
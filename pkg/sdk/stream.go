@@ -0,0 +1,126 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/soul-ua/server/pkg/protocol"
+	"io"
+	"net/http"
+)
+
+// Stream receives ephemeral envelopes, such as Typing from users and Presence of watched users from the server,
+// until ctx is done or server closes the stream. Envelopes are opened with DecodeEnvelope as usual.
+// Opening the stream brings the user online for the watchers.
+func (s *SDK) Stream(ctx context.Context, watch []string, handler func(envelope *protocol.Envelope)) error {
+	data, _ := json.Marshal(protocol.StreamRequest{
+		Presence: watch,
+	})
+
+	r, err := s.newRequest("POST", "/stream", data, "", protocol.ContentTypeCBOR)
+	if err != nil {
+		return err
+	}
+	if err := s.signRequest(r, data); err != nil {
+		return err
+	}
+
+	rsp, err := http.DefaultClient.Do(r.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		if _, _, err := s.readResponse(rsp); err != nil {
+			return fmt.Errorf("failed to open stream: %w", err)
+		}
+		return fmt.Errorf("failed to open stream: status %d", rsp.StatusCode)
+	}
+	defer rsp.Body.Close()
+
+	decoder := protocol.NewEnvelopeDecoder(rsp.Body)
+	for {
+		envelope, err := decoder.Decode()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to decode stream: %w", err)
+		}
+
+		handler(envelope)
+	}
+}
+
+// SendEphemeral sends v only to open streams of the recipient, nothing is stored on the server.
+// Returns false if recipient had no open streams and the envelope was dropped.
+func (s *SDK) SendEphemeral(to, payloadType string, v interface{}) (bool, error) {
+	envelope, err := s.seal(to, payloadType, v)
+	if err != nil {
+		return false, err
+	}
+	envelope.From = s.username
+	envelope.Ephemeral = true
+
+	packed, err := envelope.PackAs(s.contentType)
+	if err != nil {
+		return false, fmt.Errorf("failed to pack envelope: %w", err)
+	}
+
+	rsp, _, err := s.RequestContentType("POST", "/send", packed, s.contentType, "")
+	if err != nil {
+		return false, fmt.Errorf("failed to send ephemeral envelope: %w", err)
+	}
+
+	var res protocol.SendResponse
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return false, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res.Delivered, nil
+}
+
+// SendTyping with protocol.TypingStarted or protocol.TypingStopped state
+func (s *SDK) SendTyping(to, state string) error {
+	if state != protocol.TypingStarted && state != protocol.TypingStopped {
+		return fmt.Errorf("invalid typing state %q", state)
+	}
+
+	_, err := s.SendEphemeral(to, "Typing", protocol.Typing{
+		State: state,
+	})
+	return err
+}
+
+// SetPrivacy settings, with protocol.PresenceNobody the user is never shown online and last seen is hidden
+func (s *SDK) SetPrivacy(settings protocol.PrivacySettings) error {
+	req, _ := json.Marshal(settings)
+
+	if _, err := s.Request("POST", "/privacy", req); err != nil {
+		return fmt.Errorf("failed to set privacy settings: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SDK) GetPresence(usernames ...string) ([]protocol.Presence, error) {
+	req, _ := json.Marshal(protocol.GetPresenceRequest{
+		Usernames: usernames,
+	})
+
+	rsp, err := s.Request("POST", "/presence", req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presence: %w", err)
+	}
+
+	var res protocol.GetPresenceResponse
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res.Presence, nil
+}